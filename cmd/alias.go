@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// awsProfile and awsRegion select the AWS credentials and region of the
+// client, they are set by aliases
+var (
+	awsProfile string
+	awsRegion  string
+)
+
+// aliasCmd represents the alias command
+var aliasCmd = &cobra.Command{
+	Use:   "alias",
+	Short: "manage service aliases defined in the config file",
+}
+
+// aliasListCmd represents the alias list command
+var aliasListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list service aliases and what they expand to",
+	RunE:  listAliases,
+}
+
+func init() {
+	RootCmd.AddCommand(aliasCmd)
+	aliasCmd.AddCommand(aliasListCmd)
+}
+
+func listAliases(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return ErrTooManyArguments
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if len(config.Aliases) == 0 {
+		return fmt.Errorf("No aliases defined in '%s'", configFile)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, '\t', 0)
+	fmt.Fprintln(w, "Alias\tGroups\tDefaults")
+	for _, name := range config.AliasNames() {
+		alias := config.Aliases[name]
+
+		defaults := []string{}
+		for _, d := range []struct{ name, value string }{
+			{"task", alias.Task},
+			{"since", alias.Since},
+			{"filter-pattern", alias.FilterPattern},
+			{"format", alias.Format},
+			{"aws-profile", alias.AWSProfile},
+			{"region", alias.Region},
+		} {
+			if d.value != "" {
+				defaults = append(defaults, fmt.Sprintf("%s=%q", d.name, d.value))
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, strings.Join(alias.Groups, ","), strings.Join(defaults, " "))
+	}
+	w.Flush()
+	return nil
+}
+
+// applyAliases expands the aliases in args and uses their defaults for the
+// flags of cmd left unset
+func applyAliases(cmd *cobra.Command, args []string) ([]string, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	groups, defaults, err := config.ExpandAliases(args)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := map[string]string{
+		"task":           defaults.Task,
+		"since":          defaults.Since,
+		"filter-pattern": defaults.FilterPattern,
+	}
+
+	// an alias format doesn't replace any format chosen on the command line
+	if defaults.Format != "" && !formatChanged(cmd) {
+		if strings.Contains(defaults.Format, "{{") {
+			flags["format"] = defaults.Format
+		} else {
+			flags["profile-format"] = defaults.Format
+		}
+	}
+
+	for name, value := range flags {
+		flag := cmd.Flags().Lookup(name)
+		if value == "" || flag == nil || flag.Changed {
+			continue
+		}
+		if err := flag.Value.Set(value); err != nil {
+			return nil, fmt.Errorf("Invalid alias default %s '%s': %s", name, value, err)
+		}
+	}
+
+	awsProfile, awsRegion = defaults.AWSProfile, defaults.Region
+	return groups, nil
+}
+
+// formatChanged reports whether any flag choosing the output format of cmd is
+// set
+func formatChanged(cmd *cobra.Command) bool {
+	for _, name := range append([]string{"output"}, formatFlags...) {
+		if flag := cmd.Flags().Lookup(name); flag != nil && flag.Changed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+const testAliases = `
+aliases:
+  payments:
+    groups: [/ecs/payments-api, /ecs/payments-worker]
+    task: web
+    since: 6h
+    filter_pattern: '-healthcheck'
+    format: oneline
+    aws_profile: prod
+    region: us-west-2
+  templated:
+    groups: [/ecs/templated]
+    format: '{{ .Message }}'
+  bad:
+    groups: [/ecs/bad]
+    since: 1h
+`
+
+// testCommand returns a command with the flags aliases set, parsed from args
+func testCommand(t *testing.T, args ...string) *cobra.Command {
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().String("task", "", "")
+	cmd.Flags().String("since", "1h", "")
+	cmd.Flags().String("filter-pattern", "", "")
+	cmd.Flags().String("format", "", "")
+	cmd.Flags().String("profile-format", "default", "")
+	cmd.Flags().String("output", "", "")
+	cmd.Flags().Bool("raw", false, "")
+	if err := cmd.Flags().Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestApplyAliases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cwlogs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(testAliases), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := []string{configFile, awsProfile, awsRegion}
+	defer func() { configFile, awsProfile, awsRegion = saved[0], saved[1], saved[2] }()
+	configFile = path
+
+	flag := func(cmd *cobra.Command, name string) string {
+		return cmd.Flags().Lookup(name).Value.String()
+	}
+
+	// defaults fill the flags left unset
+	cmd := testCommand(t)
+	groups, err := applyAliases(cmd, []string{"payments", "/ecs/other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(groups) != "[/ecs/payments-api /ecs/payments-worker /ecs/other]" {
+		t.Errorf("groups = %v", groups)
+	}
+	got := []string{flag(cmd, "task"), flag(cmd, "since"), flag(cmd, "filter-pattern"), flag(cmd, "format"), flag(cmd, "profile-format"), awsProfile, awsRegion}
+	if want := "[web 6h -healthcheck  oneline prod us-west-2]"; fmt.Sprint(got) != want {
+		t.Errorf("flags = %v, want %s", got, want)
+	}
+
+	// flags set on the command line are left untouched
+	cmd = testCommand(t, "--since", "2h", "--task", "worker")
+	if _, err := applyAliases(cmd, []string{"payments"}); err != nil {
+		t.Fatal(err)
+	}
+	if flag(cmd, "since") != "2h" || flag(cmd, "task") != "worker" || flag(cmd, "filter-pattern") != "-healthcheck" {
+		t.Errorf("flags = %s %s %s", flag(cmd, "since"), flag(cmd, "task"), flag(cmd, "filter-pattern"))
+	}
+
+	// any format chosen on the command line wins over the alias format
+	for _, args := range [][]string{{"--format", "{{ .ID }}"}, {"--raw"}, {"--output", "json"}} {
+		cmd = testCommand(t, args...)
+		if _, err := applyAliases(cmd, []string{"payments"}); err != nil {
+			t.Fatal(err)
+		}
+		if flag(cmd, "profile-format") != "default" {
+			t.Errorf("%v: profile format = %s, want default", args, flag(cmd, "profile-format"))
+		}
+	}
+
+	// formats with {{ are templates
+	cmd = testCommand(t)
+	if _, err := applyAliases(cmd, []string{"templated"}); err != nil {
+		t.Fatal(err)
+	}
+	if flag(cmd, "format") != "{{ .Message }}" || flag(cmd, "profile-format") != "default" {
+		t.Errorf("format = %q, profile format = %q", flag(cmd, "format"), flag(cmd, "profile-format"))
+	}
+	// aliases without a profile or region reset them
+	if awsProfile != "" || awsRegion != "" {
+		t.Errorf("profile %q and region %q left from the previous alias", awsProfile, awsRegion)
+	}
+
+	if _, err := applyAliases(testCommand(t), []string{"payments", "bad"}); err == nil {
+		t.Error("expected an error for aliases with different defaults")
+	}
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/segmentio/cwlogs/lib"
+	"github.com/segmentio/cwlogs/lib/archive"
+	"github.com/segmentio/events"
+	"github.com/spf13/cobra"
+)
+
+// fromArchive and exportDir are read by fetch and list instead of CloudWatch
+var (
+	fromArchive string
+	exportDir   string
+)
+
+// archiveCmd represents the archive command
+var archiveCmd = &cobra.Command{
+	Use:   "archive <service> <file>",
+	Short: "save the logs of a service to a local file, readable with fetch --from-archive",
+	RunE:  archiveLogs,
+}
+
+func init() {
+	RootCmd.AddCommand(archiveCmd)
+	archiveCmd.Flags().StringVarP(&task, "task", "t", "", "Task UUID or prefix")
+	archiveCmd.Flags().StringVarP(&since, "since", "s", "1h", "Archive logs since timestamp (e.g. 2013-01-02T13:23:37), relative (e.g. 42m for 42 minutes), or all for all logs")
+	archiveCmd.Flags().StringVarP(&until, "until", "u", "now", "Archive logs until timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	archiveCmd.Flags().IntVarP(&maxStreams, "max-streams", "m", 100, "Maximum number of streams to archive from (for prefix search)")
+	archiveCmd.Flags().StringVar(&filterPattern, "filter-pattern", "", "CloudWatch filter pattern applied server side")
+}
+
+func archiveLogs(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return ErrTooFewArguments
+	}
+	if len(args) > 2 {
+		return ErrTooManyArguments
+	}
+	group, path := args[0], args[1]
+
+	start, err := lib.GetTime(since, time.Now())
+	if err != nil {
+		return fmt.Errorf("Failed to parse time '%s'", since)
+	}
+
+	end := time.Now()
+	if cmd.Flags().Lookup("until").Changed {
+		end, err = lib.GetTime(until, time.Now())
+		if err != nil {
+			return fmt.Errorf("Failed to parse time '%s'", until)
+		}
+	}
+
+	if _, err := lib.ParseFilterPattern(filterPattern); err != nil {
+		return err
+	}
+
+	svc, err := lib.NewClient(lib.WithThrottle(throttleConfig()))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	logReader, err := lib.NewCloudwatchLogsReader(group, task, start, end, lib.WithClient(svc), lib.WithContext(ctx), lib.WithMaxStreams(maxStreams))
+	if err != nil {
+		return err
+	}
+
+	params := &cloudwatchlogs.FilterLogEventsInput{
+		Interleaved: aws.Bool(true),
+		StartTime:   aws.Int64(start.Unix() * 1e3),
+		EndTime:     aws.Int64(end.Unix() * 1e3),
+	}
+	if filterPattern != "" {
+		params.FilterPattern = aws.String(filterPattern)
+	}
+	if task != "" {
+		streams, err := logReader.ListStreamsWithContext(ctx)
+		if err != nil {
+			return err
+		}
+		for _, stream := range streams {
+			params.LogStreamNames = append(params.LogStreamNames, stream.LogStreamName)
+		}
+	}
+
+	w, err := archive.Create(path, group)
+	if err != nil {
+		return err
+	}
+
+	count, err := archive.Archive(ctx, svc, params, w)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "archived %d events to %s\n", count, path)
+	return nil
+}
+
+// newClient returns the client fetch and list read from, which is either the
+// archive given by --from-archive, the export given by --export-dir or
+// CloudWatch.  It also returns the log groups to read, which default to the
+// archived group.
+func newClient(args []string) (lib.CloudwatchLogsClient, []string, error) {
+	var reader *archive.Reader
+	var err error
+	switch {
+	case fromArchive != "" && exportDir != "":
+		return nil, nil, fmt.Errorf("Can't set both --from-archive and --export-dir")
+	case fromArchive != "":
+		if reader, err = archive.Open(fromArchive); err != nil {
+			return nil, nil, err
+		}
+		if len(args) == 0 {
+			args = []string{reader.Group()}
+		}
+	case exportDir != "":
+		if len(args) != 1 {
+			return nil, nil, fmt.Errorf("--export-dir needs exactly one service, the log group the export was made from")
+		}
+		if reader, err = archive.OpenExport(exportDir, args[0]); err != nil {
+			return nil, nil, err
+		}
+	default:
+		if len(args) == 0 {
+			return nil, nil, ErrTooFewArguments
+		}
+		options := []lib.ReaderOption{lib.WithThrottle(throttleConfig())}
+		if awsProfile != "" || awsRegion != "" {
+			opts := session.Options{Profile: awsProfile, SharedConfigState: session.SharedConfigEnable}
+			if awsRegion != "" {
+				opts.Config.Region = aws.String(awsRegion)
+			}
+			sess, err := session.NewSessionWithOptions(opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			options = append(options, lib.WithSession(sess))
+		}
+		svc, err := lib.NewClient(options...)
+		return svc, args, err
+	}
+
+	// no point in rate limiting local reads
+	svc, err := lib.NewClient(lib.WithClient(reader), lib.WithThrottle(lib.ThrottleConfig{MaxRetries: -1}))
+	return svc, args, err
+}
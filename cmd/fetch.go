@@ -3,9 +3,12 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"strings"
 	"syscall"
-	"text/template"
+	"text/tabwriter"
 	"time"
 
 	"github.com/pkg/errors"
@@ -15,32 +18,55 @@ import (
 )
 
 const (
-	verboseFormatString = `[ {{ uniquecolor (print .TaskShort) }} ] {{ .TimeShort }} {{ colorlevel .Level }} {{- range $key, $value := .DataFlat }} {{ printf "%v=%v" $key $value }} {{end}} {{- if gt (len .Info.Errors) 0 }} Errors=[{{- range $value := .Info.Errors }} Type={{ printf "%s" $value.Type }} Error={{ printf "%s" $value.Error }} {{ if $value.Stack }} Stack={{printf "%v" $value.Stack}} {{- end }}{{- end }}] {{ end }} - {{ .Message }}`
-	defaultFormatString = `[ {{ uniquecolor (print .TaskShort) }} ] {{ .TimeShort }} {{ colorlevel .Level }} - {{ .Message }}`
-	rawFormatString     = `{{ .PrettyPrint }}`
+	// groupFormatPrefix is prepended to the default formats when fetching
+	// from more than one log group
+	groupFormatPrefix = `{{ uniquecolor .Group }} `
+
+	// followMergeWindow is how long events from one group wait for the other
+	// groups before being printed when following several groups
+	followMergeWindow = 2 * time.Second
+
+	// checkpointInterval is how often --checkpoint is saved while events
+	// are coming in
+	checkpointInterval = 5 * time.Second
+
+	// outputFlushDelay is how long --output formats buffering events, such as
+	// table, may hold them when following
+	outputFlushDelay = 500 * time.Millisecond
 )
 
-var templateFuncMap = template.FuncMap{
-	"red":         lib.Red,
-	"green":       lib.Green,
-	"yellow":      lib.Yellow,
-	"blue":        lib.Blue,
-	"magenta":     lib.Magenta,
-	"cyan":        lib.Cyan,
-	"white":       lib.White,
-	"uniquecolor": lib.Unique,
-	"colorlevel":  lib.ColorLevel,
-}
+// formatFlags are the flags selecting the template events are printed with
+var formatFlags = []string{"format", "profile-format", "verbose", "raw"}
 
 var (
-	follow        bool
-	task          string
-	eventTemplate string
-	since         string
-	until         string
-	verbose       bool
-	raw           bool
-	maxStreams    int
+	follow         bool
+	task           string
+	eventTemplate  string
+	profileFormat  string
+	since          string
+	until          string
+	verbose        bool
+	raw            bool
+	maxStreams     int
+	parallel       int
+	filterPattern  string
+	minLevel       string
+	levelRange     string
+	where          string
+	grepPatterns   []string
+	ignoreCase     bool
+	afterContext   int
+	beforeContext  int
+	contextEvents  int
+	checkpointFile string
+	outputFormat   string
+	outputFields   []string
+	helpFormat     bool
+	parserName     string
+	multiline      bool
+	multilineStart string
+	multilineWait  time.Duration
+	lateWindow     time.Duration
 )
 
 // Error messages
@@ -52,8 +78,8 @@ var (
 
 // fetchCmd represents the fetch command
 var fetchCmd = &cobra.Command{
-	Use:   "fetch [service]",
-	Short: "fetch logs for a given service",
+	Use:   "fetch [service...]",
+	Short: "fetch logs for one or more services (log group names or globs such as 'prod-*')",
 	RunE:  fetch,
 }
 
@@ -61,21 +87,45 @@ func init() {
 	RootCmd.AddCommand(fetchCmd)
 	fetchCmd.Flags().StringVarP(&task, "task", "t", "", "Task UUID or prefix")
 	fetchCmd.Flags().BoolVarP(&follow, "follow", "f", false, "Follow log streams")
-	fetchCmd.Flags().StringVarP(&eventTemplate, "format", "o", defaultFormatString, "Format template for displaying log events")
+	fetchCmd.Flags().StringVarP(&eventTemplate, "format", "o", "", "Format template for displaying log events (see --help-format)")
+	fetchCmd.Flags().StringVar(&profileFormat, "profile-format", "default", "Named format profile from the config file, or one of the built-in default, verbose and raw")
 	fetchCmd.Flags().StringVarP(&since, "since", "s", "1h", "Fetch logs since timestamp (e.g. 2013-01-02T13:23:37), relative (e.g. 42m for 42 minutes), or all for all logs")
 	fetchCmd.Flags().StringVarP(&until, "until", "u", "now", "Fetch logs until timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
-	fetchCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose log output (includes log context in data fields)")
-	fetchCmd.Flags().BoolVarP(&raw, "raw", "r", false, "Raw JSON output")
+	fetchCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose log output (includes log context in data fields), same as --profile-format verbose")
+	fetchCmd.Flags().BoolVarP(&raw, "raw", "r", false, "Raw JSON output, same as --profile-format raw")
+	fetchCmd.Flags().StringVar(&outputFormat, "output", "", "Structured output format instead of --format: "+strings.Join(lib.OutputFormats, ", "))
+	fetchCmd.Flags().StringSliceVar(&outputFields, "fields", nil, "Comma separated fields written by --output logfmt, csv and table (e.g. time,level,stream,message,data.http.status)")
 	fetchCmd.Flags().IntVarP(&maxStreams, "max-streams", "m", 100, "Maximum number of streams to fetch from (for prefix search)")
+	fetchCmd.Flags().StringVar(&filterPattern, "filter-pattern", "", "CloudWatch filter pattern applied server side (e.g. 'ERROR -healthcheck' or '{ $.level = \"ERROR\" }')")
+	fetchCmd.Flags().StringVar(&minLevel, "level", "", "Only show events at least as severe as a level (e.g. WARN), events without a level count as INFO")
+	fetchCmd.Flags().StringVar(&levelRange, "level-range", "", "Only show events with a level in a range (e.g. DEBUG..INFO or ..WARN), events without a level count as INFO")
+	fetchCmd.Flags().StringVarP(&where, "where", "w", "", "Only show events matching a query (e.g. 'level >= WARN and data.http.status >= 500')")
+	fetchCmd.Flags().StringArrayVarP(&grepPatterns, "grep", "g", nil, "Only show events whose message or data values match a regular expression (can be repeated)")
+	fetchCmd.Flags().BoolVarP(&ignoreCase, "ignore-case", "i", false, "Make --grep case insensitive")
+	fetchCmd.Flags().IntVarP(&afterContext, "after-context", "A", 0, "Show N events after each match from the same stream")
+	fetchCmd.Flags().IntVarP(&beforeContext, "before-context", "B", 0, "Show N events before each match from the same stream")
+	fetchCmd.Flags().IntVarP(&contextEvents, "context", "C", 0, "Show N events before and after each match from the same stream")
+	fetchCmd.Flags().StringVar(&parserName, "parser", lib.AutoParser, "Log format of messages, detected for every stream by default: "+strings.Join(lib.ParserNames(), ", "))
+	fetchCmd.Flags().BoolVar(&multiline, "multiline", false, "Join indented lines and stack traces to the line before them from the same stream")
+	fetchCmd.Flags().StringVar(&multilineStart, "multiline-start", "", "Join lines not matching a regular expression to the line before them from the same stream (e.g. '^\\d{4}-\\d{2}-\\d{2}')")
+	fetchCmd.Flags().DurationVar(&multilineWait, "multiline-timeout", lib.DefaultMultilineTimeout, "How long a multi-line record waits for more lines")
+	fetchCmd.Flags().StringVar(&fromArchive, "from-archive", "", "Read logs from a file written by the archive command instead of CloudWatch")
+	fetchCmd.Flags().StringVar(&exportDir, "export-dir", "", "Read logs from a local copy of a CloudWatch Logs export to S3 instead of CloudWatch")
+	fetchCmd.Flags().StringVar(&checkpointFile, "checkpoint", "", "Save progress to a file and resume from it when restarted (overrides --since)")
+	fetchCmd.Flags().BoolVar(&helpFormat, "help-format", false, "List the functions and event fields available to --format templates")
+	fetchCmd.Flags().IntVarP(&parallel, "parallel", "p", 1, "Number of concurrent requests used to fetch large time windows (output order is unchanged)")
+	fetchCmd.Flags().DurationVar(&lateWindow, "late-window", lib.DefaultDedupWindow, "When following or resuming from --checkpoint, how far behind the newest event to look again for events arriving late, events whose timestamp is further behind when they are ingested are missed")
 }
 
 func fetch(cmd *cobra.Command, args []string) error {
-	if len(args) < 1 {
-		return ErrTooFewArguments
+	if helpFormat {
+		printFormatHelp(os.Stdout)
+		return nil
 	}
 
-	if len(args) > 1 {
-		return ErrTooManyArguments
+	args, err := applyAliases(cmd, args)
+	if err != nil {
+		return err
 	}
 
 	start, err := lib.GetTime(since, time.Now())
@@ -94,26 +144,63 @@ func fetch(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	lib.SetMaxStreams(maxStreams)
+	if follow && (fromArchive != "" || exportDir != "") {
+		return fmt.Errorf("Can't follow logs read from --from-archive or --export-dir")
+	}
+
+	if cmd.Flags().Lookup("parallel").Changed {
+		if follow {
+			return fmt.Errorf("Can't set both --parallel and --follow")
+		}
+		if parallel < 1 {
+			return fmt.Errorf("--parallel must be at least 1")
+		}
+	}
+
+	if lateWindow <= 0 {
+		return fmt.Errorf("--late-window must be positive")
+	}
 
-	logReader, err := lib.NewCloudwatchLogsReader(args[0], task, start, end)
+	if _, err := lib.ParseFilterPattern(filterPattern); err != nil {
+		return err
+	}
+
+	levels, query, grep, err := eventFilters()
 	if err != nil {
 		return err
 	}
 
-	if cmd.Flags().Lookup("verbose").Changed && cmd.Flags().Lookup("raw").Changed {
-		return fmt.Errorf("Can't set both --raw and --verbose")
+	if afterContext < 0 || beforeContext < 0 || contextEvents < 0 {
+		return fmt.Errorf("Context line counts can't be negative")
+	}
+	if !cmd.Flags().Lookup("after-context").Changed {
+		afterContext = contextEvents
+	}
+	if !cmd.Flags().Lookup("before-context").Changed {
+		beforeContext = contextEvents
 	}
 
-	if verbose {
-		eventTemplate = verboseFormatString
+	parser, err := lib.NewEventParser(parserName)
+	if err != nil {
+		return err
 	}
 
-	if raw {
-		eventTemplate = rawFormatString
+	pattern := readerPattern(levels)
+
+	if multilineStart != "" {
+		if _, err := regexp.Compile(multilineStart); err != nil {
+			return fmt.Errorf("Invalid --multiline-start pattern: %s", err)
+		}
+	}
+
+	var checkpoint *lib.Checkpoint
+	if checkpointFile != "" {
+		if checkpoint, err = lib.LoadCheckpoint(checkpointFile, lateWindow); err != nil {
+			return err
+		}
 	}
 
-	output, err := template.New("event").Funcs(templateFuncMap).Parse(eventTemplate)
+	svc, args, err := newClient(args)
 	if err != nil {
 		return err
 	}
@@ -121,38 +208,334 @@ func fetch(cmd *cobra.Command, args []string) error {
 	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	eventChan := logReader.StreamEvents(ctx, follow)
+	groups, err := lib.ExpandLogGroupsWithContext(ctx, svc, args)
+	if err != nil {
+		return err
+	}
+
+	logReaders := make([]*lib.CloudwatchLogsReader, 0, len(groups))
+	for _, group := range groups {
+		var resume *lib.CheckpointPosition
+		if checkpoint != nil {
+			if resume, err = checkpoint.Resume(group, task, pattern); err != nil {
+				return err
+			}
+		}
+
+		logReader, err := lib.NewCloudwatchLogsReader(group, task, start, end,
+			lib.WithResume(resume),
+			lib.WithClient(svc),
+			lib.WithContext(ctx),
+			lib.WithMaxStreams(maxStreams),
+			lib.WithParallelism(parallel),
+			lib.WithDedupWindow(lateWindow),
+			lib.WithFilterPattern(pattern),
+			lib.WithParser(parser),
+			lib.WithOnStreamsChanged(printStreamsChanged),
+		)
+		if err != nil {
+			return err
+		}
+		logReaders = append(logReaders, logReader)
+	}
+
+	var output *lib.EventTemplate
+	var profile string
+	var writer lib.EventWriter
+	if outputFormat != "" {
+		for _, flag := range formatFlags {
+			if cmd.Flags().Lookup(flag).Changed {
+				return fmt.Errorf("Can't set both --output and --%s", flag)
+			}
+		}
+		if writer, err = lib.NewEventWriter(os.Stdout, outputFormat, outputFields); err != nil {
+			return err
+		}
+	} else if len(outputFields) > 0 {
+		return fmt.Errorf("--fields requires --output")
+	} else if output, profile, err = outputTemplate(cmd, len(groups) > 1); err != nil {
+		return err
+	}
 
-	ticker := time.After(7 * time.Second)
+	sources := make([]*lib.EventIterator, 0, len(logReaders))
+	for _, logReader := range logReaders {
+		source := logReader.Events(ctx, follow)
+		if multiline || multilineStart != "" {
+			joiner, err := lib.NewJoiner(multilineStart, multilineWait)
+			if err != nil {
+				return err
+			}
+			source = lib.JoinLines(ctx, source, joiner)
+		}
+		sources = append(sources, source)
+	}
+
+	var window time.Duration
+	if follow {
+		window = followMergeWindow
+	}
+	merged := lib.MergeIterators(ctx, window, sources...)
+	defer merged.Close()
+
+	selectEvents := eventSelector(levels, query, grep, beforeContext, afterContext)
+	highlight := grep != nil && profile != "raw" && writer == nil
+	if writer != nil {
+		// complete the output, such as closing the json array, whatever the
+		// reason to stop
+		defer writer.Close()
+	}
 
-ReadLoop:
+	lastSave := time.Now()
+	if checkpoint != nil {
+		// save whatever was processed when exiting, even on error
+		defer func() {
+			if err := checkpoint.Save(checkpointFile); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			}
+		}()
+	}
+
+	// flushAt is set when following and the writer may hold events
+	var flushAt time.Time
 	for {
-		select {
-		case event, ok := <-eventChan:
-			if !ok {
-				break ReadLoop
+		if !flushAt.IsZero() && !time.Now().Before(flushAt) {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			flushAt = time.Time{}
+		}
+
+		// warn when no event shows up for a while
+		timeout := 7 * time.Second
+		if !flushAt.IsZero() {
+			timeout = flushAt.Sub(time.Now())
+		}
+		wait, cancelWait := context.WithTimeout(ctx, timeout)
+		event, err := merged.Next(wait)
+		cancelWait()
+
+		if err == context.DeadlineExceeded && ctx.Err() == nil {
+			if !follow {
+				fmt.Fprintf(os.Stderr, "logs are taking a while to load... possibly try a smaller time window or --parallel\n")
+			}
+			continue
+		}
+		if err == io.EOF || lib.IsCanceled(err) {
+			if writer != nil {
+				return writer.Close()
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, selected := range selectEvents(event) {
+			if writer != nil {
+				if err := writer.Write(selected.Event); err != nil {
+					return err
+				}
+				if follow && flushAt.IsZero() {
+					flushAt = time.Now().Add(outputFlushDelay)
+				}
+				continue
+			}
+
+			if selected.Gap {
+				fmt.Fprintf(os.Stdout, "%s\n", lib.Cyan("--"))
+			}
+			event := selected.Event
+			if highlight {
+				event = grep.Highlight(event)
 			}
 			err = output.Execute(os.Stdout, event)
 			if err != nil {
 				return err
 			}
 			fmt.Fprintf(os.Stdout, "\n")
-			// reset slow log warning timer
-			ticker = time.After(7 * time.Second)
-		case <-ticker:
-			if !follow {
-				fmt.Fprintf(os.Stdout, "logs are taking a while to load... possibly try a smaller time window")
+		}
+
+		if checkpoint != nil {
+			checkpoint.Observe(event)
+			if time.Since(lastSave) >= checkpointInterval {
+				if err := checkpoint.Save(checkpointFile); err != nil {
+					return err
+				}
+				lastSave = time.Now()
 			}
 		}
 	}
+}
+
+// outputTemplate returns the template selected by --format,
+// --profile-format, --verbose or --raw, along with the name of the profile
+// (empty for --format).  The default and verbose profiles are prefixed with
+// the log group when withGroup is set.
+func outputTemplate(cmd *cobra.Command, withGroup bool) (*lib.EventTemplate, string, error) {
+	var set []string
+	for _, flag := range formatFlags {
+		if cmd.Flags().Lookup(flag).Changed {
+			set = append(set, flag)
+		}
+	}
+	if len(set) > 1 {
+		return nil, "", fmt.Errorf("Can't set both --%s and --%s", set[0], set[1])
+	}
+
+	if eventTemplate != "" {
+		output, err := lib.FormatProfile{Format: eventTemplate}.Compile()
+		return output, "", err
+	}
 
-	if err := logReader.Error(); err != nil {
-		if err == context.Canceled {
+	name := profileFormat
+	if verbose {
+		name = "verbose"
+	}
+	if raw {
+		name = "raw"
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	profile, err := config.FormatProfile(name)
+	if err != nil {
+		return nil, "", err
+	}
+	if withGroup && (name == "default" || name == "verbose") && profile.Format != "" {
+		profile.Format = groupFormatPrefix + profile.Format
+	}
+
+	output, err := profile.Compile()
+	if err != nil {
+		return nil, "", fmt.Errorf("Format profile '%s': %s", name, err)
+	}
+	return output, name, nil
+}
+
+// printFormatHelp lists the functions and event fields available to --format
+// templates
+func printFormatHelp(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "Functions:")
+	for _, f := range lib.TemplateFuncs {
+		fmt.Fprintf(w, "  %s\t%s\n", f.Usage, f.Doc)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Event fields:")
+	for _, f := range lib.TemplateFields() {
+		fmt.Fprintf(w, "  %s\t%s\n", f.Path, f.Type)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\nExample:\n  --format '%s'\n", `{{ .Time | timefmt "15:04:05.000" }} {{ colorlevel .Level }} {{ .Message | truncate 80 }} {{ get "data.http.status" . | default "-" }}`)
+}
+
+// eventFilters returns the level range, query and grep selected by --level or
+// --level-range, --where and --grep, each one nil when not set
+func eventFilters() (*lib.LevelRange, *lib.Query, *lib.Grep, error) {
+	levels, err := levelFilter()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var query *lib.Query
+	if where != "" {
+		if query, err = lib.ParseQuery(where); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var grep *lib.Grep
+	if len(grepPatterns) > 0 {
+		if grep, err = lib.NewGrep(grepPatterns, ignoreCase); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return levels, query, grep, nil
+}
+
+// readerPattern returns the filter pattern given to readers, which is
+// --filter-pattern along with the level range when it can be filtered server
+// side without dropping events kept client side
+func readerPattern(levels *lib.LevelRange) string {
+	if levels == nil {
+		return filterPattern
+	}
+	if levelPattern, ok := levels.FilterPattern(parserName); ok {
+		if combined, ok := lib.CombineFilterPatterns(filterPattern, levelPattern); ok {
+			return combined
+		}
+	}
+	return filterPattern
+}
+
+// levelFilter returns the range of levels selected by --level or
+// --level-range, or nil when neither is set
+func levelFilter() (*lib.LevelRange, error) {
+	if minLevel != "" && levelRange != "" {
+		return nil, fmt.Errorf("Can't set both --level and --level-range")
+	}
+	if minLevel == "" && levelRange == "" {
+		return nil, nil
+	}
+	if minLevel != "" && strings.Contains(minLevel, "..") {
+		return nil, fmt.Errorf("--level takes a single level, use --level-range for a range")
+	}
+
+	levels, err := lib.ParseLevelRange(minLevel + levelRange)
+	if err != nil {
+		return nil, err
+	}
+	return &levels, nil
+}
+
+// eventSelector returns a function picking the events to print, which are the
+// events in the level range matching both the query and grep (when set) and
+// the requested number of events around them.  Events outside the level range
+// are dropped before anything else, they are never shown as context.
+func eventSelector(levels *lib.LevelRange, query *lib.Query, grep *lib.Grep, before int, after int) func(lib.Event) []lib.ContextEvent {
+	selector := matchSelector(query, grep, before, after)
+	if levels == nil {
+		return selector
+	}
+	return func(event lib.Event) []lib.ContextEvent {
+		if !levels.Match(event.Level) {
 			return nil
 		}
+		return selector(event)
+	}
+}
 
-		return err
+// matchSelector returns a function picking the events matching both the
+// query and grep (when set) and the requested number of events around them
+func matchSelector(query *lib.Query, grep *lib.Grep, before int, after int) func(lib.Event) []lib.ContextEvent {
+	match := func(event lib.Event) bool {
+		if query != nil && !query.Match(event) {
+			return false
+		}
+		return grep == nil || grep.Match(event)
+	}
+
+	if before == 0 && after == 0 {
+		return func(event lib.Event) []lib.ContextEvent {
+			if !match(event) {
+				return nil
+			}
+			return []lib.ContextEvent{{Event: event, Match: true}}
+		}
 	}
+	return lib.NewContextFilter(before, after, match).Add
+}
 
-	return nil
+// printStreamsChanged notifies on stderr when following picks up new streams
+// or drops quiet ones
+func printStreamsChanged(group string, joined []string, left []string) {
+	for _, stream := range joined {
+		fmt.Fprintf(os.Stderr, "%s: following new stream %s\n", group, stream)
+	}
+	for _, stream := range left {
+		fmt.Fprintf(os.Stderr, "%s: stream %s went quiet, no longer following\n", group, stream)
+	}
 }
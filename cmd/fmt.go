@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/cwlogs/lib"
+	"github.com/spf13/cobra"
+)
+
+// maxLineSize is the longest log line fmt reads
+const maxLineSize = 1024 * 1024
+
+// fmtCmd represents the fmt command
+var fmtCmd = &cobra.Command{
+	Use:   "fmt [file...]",
+	Short: "format ecs-logs lines read from files or stdin (e.g. docker-compose up | cwlogs fmt)",
+	RunE:  formatLogs,
+}
+
+func init() {
+	RootCmd.AddCommand(fmtCmd)
+	fmtCmd.Flags().StringVarP(&eventTemplate, "format", "o", "", "Format template for displaying log events (see fetch --help-format)")
+	fmtCmd.Flags().StringVar(&profileFormat, "profile-format", "default", "Named format profile from the config file, or one of the built-in default, verbose and raw")
+	fmtCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Verbose log output (includes log context in data fields), same as --profile-format verbose")
+	fmtCmd.Flags().BoolVarP(&raw, "raw", "r", false, "Raw JSON output, same as --profile-format raw")
+	fmtCmd.Flags().StringVar(&parserName, "parser", lib.AutoParser, "Log format of lines, detected for every file by default: "+strings.Join(lib.ParserNames(), ", "))
+}
+
+func formatLogs(cmd *cobra.Command, args []string) error {
+	output, _, err := outputTemplate(cmd, false)
+	if err != nil {
+		return err
+	}
+
+	parser, err := lib.NewEventParser(parserName)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return formatLines(os.Stdin, "stdin", parser, output)
+	}
+
+	for _, path := range args {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = formatLines(file, path, parser, output)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatLines renders every line of r as soon as it is read
+func formatLines(r io.Reader, source string, parser *lib.EventParser, output *lib.EventTemplate) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		event := parser.EventFromLine(scanner.Text(), time.Now(), source)
+		if err := output.Execute(os.Stdout, event); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "\n")
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,425 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/segmentio/cwlogs/lib"
+	ecslogs "github.com/segmentio/ecs-logs-go"
+	"github.com/segmentio/events"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// histogramRows is the number of buckets picked for the level chart,
+	// which draws a row per bucket
+	histogramRows = 30
+
+	// histogramRefresh is how often the chart is redrawn when following
+	histogramRefresh = time.Second
+)
+
+// sparks are the bars of sparklines, from the smallest count to the largest
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+var (
+	bucketWidth    time.Duration
+	histogramBy    string
+	histogramWidth int
+)
+
+// histogramCmd represents the histogram command
+var histogramCmd = &cobra.Command{
+	Use:   "histogram [service...]",
+	Short: "chart the rate of events over time by level, or by stream with --by stream",
+	RunE:  histogram,
+}
+
+func init() {
+	RootCmd.AddCommand(histogramCmd)
+	histogramCmd.Flags().StringVarP(&task, "task", "t", "", "Task UUID or prefix")
+	histogramCmd.Flags().BoolVarP(&follow, "follow", "f", false, "Follow log streams, scrolling the chart as time passes")
+	histogramCmd.Flags().StringVarP(&since, "since", "s", "1h", "Chart logs since timestamp (e.g. 2013-01-02T13:23:37), relative (e.g. 42m for 42 minutes), or all for all logs")
+	histogramCmd.Flags().StringVarP(&until, "until", "u", "now", "Chart logs until timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	histogramCmd.Flags().DurationVar(&bucketWidth, "bucket", 0, "Width of the buckets events are counted in (e.g. 30s or 5m), picked from the time window by default")
+	histogramCmd.Flags().StringVar(&histogramBy, "by", "level", "Series drawn: level for a bar chart of every level, stream for a sparkline per stream")
+	histogramCmd.Flags().IntVar(&histogramWidth, "width", 0, "Width of the chart in columns, $COLUMNS or 80 by default")
+	histogramCmd.Flags().IntVarP(&maxStreams, "max-streams", "m", 100, "Maximum number of streams to chart (for prefix search)")
+	histogramCmd.Flags().IntVarP(&parallel, "parallel", "p", 1, "Number of concurrent requests used to fetch large time windows")
+	histogramCmd.Flags().DurationVar(&lateWindow, "late-window", lib.DefaultDedupWindow, "When following, how far behind the newest event to look again for events arriving late, events whose timestamp is further behind when they are ingested are missed")
+	histogramCmd.Flags().StringVar(&filterPattern, "filter-pattern", "", "CloudWatch filter pattern applied server side")
+	histogramCmd.Flags().StringVar(&minLevel, "level", "", "Only count events at least as severe as a level (e.g. WARN), events without a level count as INFO")
+	histogramCmd.Flags().StringVar(&levelRange, "level-range", "", "Only count events with a level in a range (e.g. DEBUG..INFO or ..WARN)")
+	histogramCmd.Flags().StringVarP(&where, "where", "w", "", "Only count events matching a query (e.g. 'level >= WARN and data.http.status >= 500')")
+	histogramCmd.Flags().StringArrayVarP(&grepPatterns, "grep", "g", nil, "Only count events whose message or data values match a regular expression (can be repeated)")
+	histogramCmd.Flags().BoolVarP(&ignoreCase, "ignore-case", "i", false, "Make --grep case insensitive")
+	histogramCmd.Flags().StringVar(&parserName, "parser", lib.AutoParser, "Log format of messages, detected for every stream by default")
+	histogramCmd.Flags().StringVar(&fromArchive, "from-archive", "", "Read logs from a file written by the archive command instead of CloudWatch")
+	histogramCmd.Flags().StringVar(&exportDir, "export-dir", "", "Read logs from a local copy of a CloudWatch Logs export to S3 instead of CloudWatch")
+}
+
+func histogram(cmd *cobra.Command, args []string) error {
+	byStream := histogramBy == "stream"
+	if !byStream && histogramBy != "level" {
+		return fmt.Errorf("Unknown series '%s', expected level or stream", histogramBy)
+	}
+	if bucketWidth < 0 {
+		return fmt.Errorf("--bucket can't be negative")
+	}
+	if lateWindow <= 0 {
+		return fmt.Errorf("--late-window must be positive")
+	}
+	if cmd.Flags().Lookup("until").Changed && follow {
+		return fmt.Errorf("Can't set both --until and --follow")
+	}
+	if follow && (fromArchive != "" || exportDir != "") {
+		return fmt.Errorf("Can't follow logs read from --from-archive or --export-dir")
+	}
+	if follow && cmd.Flags().Lookup("parallel").Changed {
+		return fmt.Errorf("Can't set both --parallel and --follow")
+	}
+
+	args, err := applyAliases(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	start, end, err := timeWindow(cmd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := lib.ParseFilterPattern(filterPattern); err != nil {
+		return err
+	}
+	levels, query, grep, err := eventFilters()
+	if err != nil {
+		return err
+	}
+	parser, err := lib.NewEventParser(parserName)
+	if err != nil {
+		return err
+	}
+
+	width := chartWidth()
+	buckets := histogramRows
+	if byStream {
+		// a column per bucket, leaving room for the stream names and totals
+		buckets = width - 20
+		if buckets < 10 {
+			buckets = 10
+		}
+	}
+
+	svc, args, err := newClient(args)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	groups, err := lib.ExpandLogGroupsWithContext(ctx, svc, args)
+	if err != nil {
+		return err
+	}
+
+	var readerEnd time.Time
+	if !follow {
+		readerEnd = end
+	}
+	sources := make([]*lib.EventIterator, 0, len(groups))
+	for _, group := range groups {
+		logReader, err := lib.NewCloudwatchLogsReader(group, task, start, readerEnd,
+			lib.WithClient(svc),
+			lib.WithContext(ctx),
+			lib.WithMaxStreams(maxStreams),
+			lib.WithParallelism(parallel),
+			lib.WithDedupWindow(lateWindow),
+			lib.WithFilterPattern(readerPattern(levels)),
+			lib.WithParser(parser),
+		)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, logReader.Events(ctx, follow))
+	}
+
+	var window time.Duration
+	if follow {
+		window = followMergeWindow
+	}
+	merged := lib.MergeIterators(ctx, window, sources...)
+	defer merged.Close()
+
+	seriesOf := func(e lib.Event) string {
+		if !byStream {
+			return levelSeries(e)
+		}
+		return streamSeries(e, len(groups) > 1)
+	}
+	newChart := func(start time.Time, end time.Time) *lib.Histogram {
+		width := bucketWidth
+		if width == 0 {
+			width = lib.BucketWidth(start, end, buckets)
+		}
+		return lib.NewHistogram(start, end, width)
+	}
+	draw := func(chart *lib.Histogram) {
+		if byStream {
+			drawStreamChart(os.Stdout, chart, width)
+		} else {
+			drawLevelChart(os.Stdout, chart, width)
+		}
+	}
+
+	selectEvents := eventSelector(levels, query, grep, 0, 0)
+	if !follow {
+		// the events are kept until the end to narrow the window to them
+		// with --since all
+		var points []chartPoint
+		for {
+			event, err := merged.Next(ctx)
+			if err == io.EOF || lib.IsCanceled(err) {
+				// draw what was read when interrupted
+				break
+			}
+			if err != nil {
+				return err
+			}
+			for _, selected := range selectEvents(event) {
+				points = append(points, chartPoint{seriesOf(selected.Event), selected.Event.Time})
+			}
+		}
+
+		if len(points) == 0 {
+			// an empty window, such as since all, would be charted as rows of zeros
+			fmt.Fprintln(os.Stdout, "No events")
+			return nil
+		}
+		if since == "all" {
+			start, end = points[0].time, points[0].time
+			for _, point := range points {
+				if point.time.Before(start) {
+					start = point.time
+				}
+				if point.time.After(end) {
+					end = point.time
+				}
+			}
+			end = end.Add(time.Nanosecond)
+		}
+		chart := newChart(start, end)
+		for _, point := range points {
+			chart.Add(point.series, point.time)
+		}
+		draw(chart)
+		return nil
+	}
+
+	chart := newChart(start, end)
+	clearScreen := isatty.IsTerminal(os.Stdout.Fd())
+	lastDraw := time.Time{}
+	for {
+		wait, cancelWait := context.WithDeadline(ctx, lastDraw.Add(histogramRefresh))
+		event, err := merged.Next(wait)
+		cancelWait()
+
+		switch {
+		case err == nil:
+			for _, selected := range selectEvents(event) {
+				chart.Add(seriesOf(selected.Event), selected.Event.Time)
+			}
+		case err == context.DeadlineExceeded && ctx.Err() == nil:
+		case err == io.EOF || lib.IsCanceled(err):
+			return nil
+		default:
+			return err
+		}
+
+		if time.Since(lastDraw) >= histogramRefresh {
+			// scroll the window to end with the current bucket
+			chart.Extend(time.Now())
+			chart.Trim(buckets)
+			if clearScreen {
+				fmt.Fprint(os.Stdout, "\033[H\033[2J")
+			} else if !lastDraw.IsZero() {
+				fmt.Fprintln(os.Stdout)
+			}
+			draw(chart)
+			lastDraw = time.Now()
+		}
+	}
+}
+
+// chartPoint is an event counted by the histogram command
+type chartPoint struct {
+	series string
+	time   time.Time
+}
+
+// levelSeries returns the series of e when charting by level, events without
+// a level counting as INFO like they do for --level
+func levelSeries(e lib.Event) string {
+	if e.Level == ecslogs.NONE {
+		return ecslogs.INFO.String()
+	}
+	return e.Level.String()
+}
+
+// streamSeries returns the series of e when charting by stream, the short
+// name of its stream prefixed by its group when there are several groups
+func streamSeries(e lib.Event, withGroup bool) string {
+	if withGroup {
+		return e.Group + " " + e.TaskShort()
+	}
+	return e.TaskShort()
+}
+
+// chartWidth returns the width given by --width, or else by $COLUMNS
+func chartWidth() int {
+	if histogramWidth > 0 {
+		return histogramWidth
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return 80
+}
+
+// drawLevelChart draws a row per bucket of h with a bar stacking the counts of
+// every level, scaled to the largest bucket
+func drawLevelChart(out io.Writer, h *lib.Histogram, width int) {
+	series := h.Series(true)
+
+	legend := make([]string, 0, len(series))
+	for _, name := range series {
+		legend = append(legend, levelCells(name, 1)+" "+name)
+	}
+	fmt.Fprintf(out, "Events per %s  %s\n", shortDuration(h.Width), strings.Join(legend, "  "))
+
+	max := 0
+	for i := 0; i < h.Len(); i++ {
+		if total := h.Total(i); total > max {
+			max = total
+		}
+	}
+	countWidth := len(strconv.Itoa(max))
+	barWidth := width - len(lib.ShortTimeFormat) - countWidth - 2
+	if barWidth < 10 {
+		barWidth = 10
+	}
+
+	for i := 0; i < h.Len(); i++ {
+		total := h.Total(i)
+		bar := bytes.Buffer{}
+		// round the running total so that the bar is as long as the total
+		// scaled, whatever the rounding of each level
+		cumulated, cells := 0, 0
+		for _, name := range series {
+			count := h.Count(name, i)
+			if count == 0 {
+				continue
+			}
+			cumulated += count
+			end := (cumulated*barWidth + max/2) / max
+			if end > cells {
+				bar.WriteString(levelCells(name, end-cells))
+				cells = end
+			}
+		}
+		if total > 0 && cells == 0 {
+			// too small to scale, show it anyway with its most severe level
+			for _, name := range series {
+				if h.Count(name, i) > 0 {
+					bar.WriteString(levelCells(name, 1))
+					break
+				}
+			}
+		}
+		fmt.Fprintf(out, "%s %*d", h.BucketTime(i).Format(lib.ShortTimeFormat), countWidth, total)
+		if bar.Len() > 0 {
+			fmt.Fprintf(out, " %s", bar.String())
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+// drawStreamChart draws a sparkline per stream of h with a column per bucket,
+// scaled to the largest bucket of any stream
+func drawStreamChart(out io.Writer, h *lib.Histogram, width int) {
+	last := h.BucketTime(h.Len() - 1)
+	fmt.Fprintf(out, "Events per %s from %s to %s\n", shortDuration(h.Width), h.Start.Format(lib.ShortTimeFormat), last.Add(h.Width).Format(lib.ShortTimeFormat))
+
+	series := h.Series(false)
+	max, labelWidth := 0, 0
+	totals := map[string]int{}
+	for _, name := range series {
+		for _, count := range h.Counts[name] {
+			totals[name] += count
+			if count > max {
+				max = count
+			}
+		}
+		if len(name) > labelWidth {
+			labelWidth = len(name)
+		}
+	}
+
+	for _, name := range series {
+		line := bytes.Buffer{}
+		for i := 0; i < h.Len(); i++ {
+			count := h.Count(name, i)
+			if count == 0 {
+				line.WriteRune(' ')
+				continue
+			}
+			line.WriteRune(sparks[(count*len(sparks)-1)/max])
+		}
+		padding := strings.Repeat(" ", labelWidth-len(name))
+		fmt.Fprintf(out, "%s%s %s %d\n", lib.Unique(name), padding, line.String(), totals[name])
+	}
+}
+
+// levelCells returns n cells of the bar of a level, colored by level or
+// drawn with a character per level when color is off
+func levelCells(name string, n int) string {
+	level, _ := ecslogs.ParseLevel(name)
+	if !color.NoColor {
+		return lib.LevelColor(level).Sprint(strings.Repeat("█", n))
+	}
+
+	glyph := "+"
+	switch {
+	case level <= ecslogs.ERROR:
+		glyph = "#"
+	case level == ecslogs.WARN:
+		glyph = "="
+	case level == ecslogs.DEBUG:
+		glyph = "-"
+	case level == ecslogs.TRACE:
+		glyph = "."
+	}
+	return strings.Repeat(glyph, n)
+}
+
+// shortDuration formats d without its zero minutes and seconds, such as 1h
+// instead of 1h0m0s
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/segmentio/cwlogs/lib"
+)
+
+func TestDrawLevelChart(t *testing.T) {
+	noColor, local := color.NoColor, time.Local
+	color.NoColor, time.Local = true, time.UTC
+	defer func() { color.NoColor, time.Local = noColor, local }()
+
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	h := lib.NewHistogram(start, start.Add(4*time.Minute), time.Minute)
+	add := func(level string, minute int, n int) {
+		for i := 0; i < n; i++ {
+			h.Add(level, start.Add(time.Duration(minute)*time.Minute))
+		}
+	}
+	// the running total is rounded, a level too small to scale on its own
+	// still takes its share of the bar
+	add("ERROR", 0, 1)
+	add("WARN", 0, 1)
+	add("INFO", 0, 1)
+	add("INFO", 1, 30)
+	add("ERROR", 2, 8)
+	add("INFO", 2, 7)
+	// too small to scale, drawn with a cell anyway
+	add("ERROR", 3, 1)
+
+	var out bytes.Buffer
+	// a 10 cell bar
+	drawLevelChart(&out, h, len(lib.ShortTimeFormat)+2+2+10)
+
+	want := []string{
+		"Events per 1m  # ERROR  = WARN  + INFO",
+		"03-01 12:00:00  3 =",
+		"03-01 12:01:00 30 ++++++++++",
+		"03-01 12:02:00 15 ###++",
+		"03-01 12:03:00  1 #",
+	}
+	if got := strings.TrimSuffix(out.String(), "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("chart =\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+}
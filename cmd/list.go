@@ -1,13 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"sort"
+	"strings"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
 	"github.com/segmentio/cwlogs/lib"
+	"github.com/segmentio/events"
 	"github.com/spf13/cobra"
 )
 
@@ -24,16 +28,23 @@ func init() {
 	listCmd.Flags().StringVarP(&since, "since", "s", "1h", "Show logs streams with activity since timestamp (e.g. 2013-01-02T13:23:37), relative (e.g. 42m for 42 minutes), or all for all logs")
 	listCmd.Flags().StringVarP(&until, "until", "u", "now", "Show log streams until timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
 	listCmd.Flags().IntVarP(&maxStreams, "max-streams", "m", 100, "Maximum number of streams to list")
+	listCmd.Flags().StringVar(&fromArchive, "from-archive", "", "List streams from a file written by the archive command instead of CloudWatch")
+	listCmd.Flags().StringVar(&exportDir, "export-dir", "", "List streams from a local copy of a CloudWatch Logs export to S3 instead of CloudWatch")
 }
 
 func list(cmd *cobra.Command, args []string) error {
-	if len(args) < 1 {
-		return ErrTooFewArguments
-	}
 	if len(args) > 1 {
 		return ErrTooManyArguments
 	}
 
+	args, err := applyAliases(cmd, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("Can't list the streams of more than one log group, '%s' is an alias of %s", cmd.Flags().Arg(0), strings.Join(args, ", "))
+	}
+
 	start, err := lib.GetTime(since, time.Now())
 	if err != nil {
 		return fmt.Errorf("Failed to parse time '%s'", since)
@@ -47,14 +58,20 @@ func list(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	lib.SetMaxStreams(maxStreams)
+	svc, args, err := newClient(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
 
-	logReader, err := lib.NewCloudwatchLogsReader(args[0], task, start, end)
+	logReader, err := lib.NewCloudwatchLogsReader(args[0], task, start, end, lib.WithMaxStreams(maxStreams), lib.WithClient(svc), lib.WithContext(ctx))
 	if err != nil {
 		return err
 	}
 
-	streams, err := logReader.ListStreams()
+	streams, err := logReader.ListStreamsWithContext(ctx)
 	if err != nil {
 		return err
 	}
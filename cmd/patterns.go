@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/segmentio/cwlogs/lib"
+	"github.com/segmentio/events"
+	"github.com/spf13/cobra"
+)
+
+// patternStreams is the number of streams named for each pattern by the
+// text report
+const patternStreams = 3
+
+var (
+	patternsOutput     string
+	patternsTop        int
+	patternsSimilarity float64
+)
+
+// patternsCmd represents the patterns command
+var patternsCmd = &cobra.Command{
+	Use:   "patterns [service...]",
+	Short: "group similar log messages into patterns and show the most frequent ones",
+	RunE:  patterns,
+}
+
+func init() {
+	RootCmd.AddCommand(patternsCmd)
+	patternsCmd.Flags().StringVarP(&task, "task", "t", "", "Task UUID or prefix")
+	patternsCmd.Flags().StringVarP(&since, "since", "s", "1h", "Group logs since timestamp (e.g. 2013-01-02T13:23:37), relative (e.g. 42m for 42 minutes), or all for all logs")
+	patternsCmd.Flags().StringVarP(&until, "until", "u", "now", "Group logs until timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	patternsCmd.Flags().IntVarP(&maxStreams, "max-streams", "m", 100, "Maximum number of streams to read (for prefix search)")
+	patternsCmd.Flags().IntVarP(&parallel, "parallel", "p", 1, "Number of concurrent requests used to fetch large time windows")
+	patternsCmd.Flags().StringVar(&filterPattern, "filter-pattern", "", "CloudWatch filter pattern applied server side")
+	patternsCmd.Flags().StringVar(&minLevel, "level", "", "Only group events at least as severe as a level (e.g. WARN), events without a level count as INFO")
+	patternsCmd.Flags().StringVar(&levelRange, "level-range", "", "Only group events with a level in a range (e.g. DEBUG..INFO or ..WARN)")
+	patternsCmd.Flags().StringVarP(&where, "where", "w", "", "Only group events matching a query (e.g. 'level >= WARN and data.http.status >= 500')")
+	patternsCmd.Flags().StringArrayVarP(&grepPatterns, "grep", "g", nil, "Only group events whose message or data values match a regular expression (can be repeated)")
+	patternsCmd.Flags().BoolVarP(&ignoreCase, "ignore-case", "i", false, "Make --grep case insensitive")
+	patternsCmd.Flags().StringVar(&parserName, "parser", lib.AutoParser, "Log format of messages, detected for every stream by default")
+	patternsCmd.Flags().BoolVar(&multiline, "multiline", false, "Join indented lines and stack traces to the line before them from the same stream")
+	patternsCmd.Flags().StringVar(&multilineStart, "multiline-start", "", "Join lines not matching a regular expression to the line before them from the same stream")
+	patternsCmd.Flags().StringVar(&fromArchive, "from-archive", "", "Read logs from a file written by the archive command instead of CloudWatch")
+	patternsCmd.Flags().StringVar(&exportDir, "export-dir", "", "Read logs from a local copy of a CloudWatch Logs export to S3 instead of CloudWatch")
+	patternsCmd.Flags().StringVar(&patternsOutput, "output", "text", "Report format: text or json")
+	patternsCmd.Flags().IntVarP(&patternsTop, "top", "n", 10, "Number of patterns shown (0 for all)")
+	patternsCmd.Flags().Float64Var(&patternsSimilarity, "similarity", lib.DefaultPatternSimilarity, "Share of words messages must have in common to be grouped, from 0 to 1 where 1 only groups messages with the same fingerprint")
+}
+
+func patterns(cmd *cobra.Command, args []string) error {
+	if patternsOutput != "text" && patternsOutput != "json" {
+		return fmt.Errorf("Unknown output format '%s', expected text or json", patternsOutput)
+	}
+	if patternsSimilarity <= 0 || patternsSimilarity > 1 {
+		return fmt.Errorf("--similarity must be between 0 and 1")
+	}
+	if patternsTop < 0 {
+		return fmt.Errorf("--top can't be negative")
+	}
+
+	args, err := applyAliases(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	start, end, err := timeWindow(cmd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := lib.ParseFilterPattern(filterPattern); err != nil {
+		return err
+	}
+	levels, query, grep, err := eventFilters()
+	if err != nil {
+		return err
+	}
+	parser, err := lib.NewEventParser(parserName)
+	if err != nil {
+		return err
+	}
+
+	svc, args, err := newClient(args)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	groups, err := lib.ExpandLogGroupsWithContext(ctx, svc, args)
+	if err != nil {
+		return err
+	}
+
+	sources := make([]*lib.EventIterator, 0, len(groups))
+	for _, group := range groups {
+		logReader, err := lib.NewCloudwatchLogsReader(group, task, start, end,
+			lib.WithClient(svc),
+			lib.WithContext(ctx),
+			lib.WithMaxStreams(maxStreams),
+			lib.WithParallelism(parallel),
+			lib.WithFilterPattern(readerPattern(levels)),
+			lib.WithParser(parser),
+		)
+		if err != nil {
+			return err
+		}
+		source := logReader.Events(ctx, false)
+		if multiline || multilineStart != "" {
+			joiner, err := lib.NewJoiner(multilineStart, 0)
+			if err != nil {
+				return fmt.Errorf("Invalid --multiline-start pattern: %s", err)
+			}
+			source = lib.JoinLines(ctx, source, joiner)
+		}
+		sources = append(sources, source)
+	}
+	merged := lib.MergeIterators(ctx, 0, sources...)
+	defer merged.Close()
+
+	selectEvents := eventSelector(levels, query, grep, 0, 0)
+	set := lib.NewPatternSet(patternsSimilarity)
+	total := 0
+	for {
+		event, err := merged.Next(ctx)
+		if err == io.EOF || lib.IsCanceled(err) {
+			// report what was read when interrupted
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for _, selected := range selectEvents(event) {
+			set.Add(selected.Event)
+			total++
+		}
+	}
+
+	top := set.Top(patternsTop)
+	if patternsOutput == "json" {
+		reports := make([]lib.PatternReport, 0, len(top))
+		for _, pattern := range top {
+			reports = append(reports, pattern.Report())
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		// keep the placeholders of patterns readable
+		enc.SetEscapeHTML(false)
+		return enc.Encode(reports)
+	}
+	printPatterns(os.Stdout, top, set.Len(), total)
+	return nil
+}
+
+// printPatterns writes the text report of the top patterns out of count
+// patterns grouping total events
+func printPatterns(out io.Writer, top []*lib.Pattern, count int, total int) {
+	fmt.Fprintf(out, "%d events in %d patterns\n", total, count)
+	for ix, pattern := range top {
+		streams := lib.SortCounts(pattern.Streams, false)
+		names := make([]string, 0, patternStreams+1)
+		for i, stream := range streams {
+			if i == patternStreams {
+				names = append(names, fmt.Sprintf("%d more", len(streams)-i))
+				break
+			}
+			names = append(names, lib.Unique(stream.Name))
+		}
+
+		fmt.Fprintln(out)
+		fmt.Fprintf(out, "#%d  %s (%.1f%%)  %s  %s .. %s  %s (%s)\n",
+			ix+1,
+			plural(pattern.Count, "event"),
+			100*float64(pattern.Count)/float64(total),
+			lib.ColorLevel(pattern.Level()),
+			pattern.First.Local().Format(lib.ShortTimeFormat),
+			pattern.Last.Local().Format(lib.ShortTimeFormat),
+			plural(len(streams), "stream"),
+			strings.Join(names, ", "),
+		)
+		fmt.Fprintf(out, "    %s\n", lib.Cyan(pattern.String()))
+
+		sample := pattern.Sample
+		message := sample.Message
+		if end := strings.IndexByte(message, '\n'); end >= 0 {
+			message = message[:end] + " ..."
+		}
+		fmt.Fprintf(out, "    e.g. [ %s ] %s %s\n", lib.Unique(sample.TaskShort()), sample.TimeShort(), message)
+	}
+	if len(top) < count {
+		fmt.Fprintf(out, "\n(%d more patterns)\n", count-len(top))
+	}
+}
+
+// plural returns n followed by word, with an s unless n is 1
+func plural(n int, word string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, word)
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
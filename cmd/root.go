@@ -4,12 +4,19 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/segmentio/cwlogs/lib"
 	"github.com/spf13/cobra"
 	"github.com/fatih/color"
 )
 
-var useColor bool
+var (
+	useColor       bool
+	callsPerSecond float64
+	configFile     string
+)
 
 var ErrInvalidCommand = errors.New("Invalid command")
 
@@ -54,4 +61,28 @@ func Execute() {
 
 func init() {
 	RootCmd.PersistentFlags().BoolVarP(&useColor, "color", "c", true, "Enable color output")
+	RootCmd.PersistentFlags().StringVar(&configFile, "config", lib.DefaultConfigPath(), "Config file defining format profiles and service aliases")
+	RootCmd.PersistentFlags().Float64Var(&callsPerSecond, "rate", 5, "Maximum number of AWS API calls per second (0 for unlimited)")
+}
+
+// loadConfig reads the config file given by --config
+func loadConfig() (*lib.Config, error) {
+	return lib.LoadConfig(configFile)
+}
+
+// throttleConfig returns the rate limit and retry settings shared by every
+// command, reporting retries on stderr
+func throttleConfig() lib.ThrottleConfig {
+	return lib.ThrottleConfig{
+		CallsPerSecond: callsPerSecond,
+		OnBackoff:      printBackoff,
+	}
+}
+
+func printBackoff(operation string, err error, delay time.Duration) {
+	reason := err.Error()
+	if awsErr, ok := err.(awserr.Error); ok {
+		reason = awsErr.Code()
+	}
+	fmt.Fprintf(os.Stderr, "%s failed (%s), retrying in %s\n", operation, reason, delay/time.Millisecond*time.Millisecond)
 }
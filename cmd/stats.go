@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/segmentio/cwlogs/lib"
+	"github.com/segmentio/events"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statsOutput string
+	statsTop    int
+)
+
+// statsCmd represents the stats command
+var statsCmd = &cobra.Command{
+	Use:   "stats [service...]",
+	Short: "summarize the logs of a time window by level, stream, host and error type",
+	RunE:  stats,
+}
+
+func init() {
+	RootCmd.AddCommand(statsCmd)
+	statsCmd.Flags().StringVarP(&task, "task", "t", "", "Task UUID or prefix")
+	statsCmd.Flags().StringVarP(&since, "since", "s", "1h", "Summarize logs since timestamp (e.g. 2013-01-02T13:23:37), relative (e.g. 42m for 42 minutes), or all for all logs")
+	statsCmd.Flags().StringVarP(&until, "until", "u", "now", "Summarize logs until timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	statsCmd.Flags().IntVarP(&maxStreams, "max-streams", "m", 100, "Maximum number of streams to summarize (for prefix search)")
+	statsCmd.Flags().IntVarP(&parallel, "parallel", "p", 1, "Number of concurrent requests used to fetch large time windows")
+	statsCmd.Flags().StringVar(&filterPattern, "filter-pattern", "", "CloudWatch filter pattern applied server side")
+	statsCmd.Flags().StringVar(&minLevel, "level", "", "Only count events at least as severe as a level (e.g. WARN), events without a level count as INFO")
+	statsCmd.Flags().StringVar(&levelRange, "level-range", "", "Only count events with a level in a range (e.g. DEBUG..INFO or ..WARN)")
+	statsCmd.Flags().StringVarP(&where, "where", "w", "", "Only count events matching a query (e.g. 'level >= WARN and data.http.status >= 500')")
+	statsCmd.Flags().StringArrayVarP(&grepPatterns, "grep", "g", nil, "Only count events whose message or data values match a regular expression (can be repeated)")
+	statsCmd.Flags().BoolVarP(&ignoreCase, "ignore-case", "i", false, "Make --grep case insensitive")
+	statsCmd.Flags().StringVar(&parserName, "parser", lib.AutoParser, "Log format of messages, detected for every stream by default")
+	statsCmd.Flags().StringVar(&fromArchive, "from-archive", "", "Read logs from a file written by the archive command instead of CloudWatch")
+	statsCmd.Flags().StringVar(&exportDir, "export-dir", "", "Read logs from a local copy of a CloudWatch Logs export to S3 instead of CloudWatch")
+	statsCmd.Flags().StringVar(&statsOutput, "output", "table", "Report format: table or json")
+	statsCmd.Flags().IntVar(&statsTop, "top", 10, "Number of streams, hosts and error types shown by the table report (0 for all)")
+}
+
+func stats(cmd *cobra.Command, args []string) error {
+	if statsOutput != "table" && statsOutput != "json" {
+		return fmt.Errorf("Unknown output format '%s', expected table or json", statsOutput)
+	}
+
+	args, err := applyAliases(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	start, end, err := timeWindow(cmd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := lib.ParseFilterPattern(filterPattern); err != nil {
+		return err
+	}
+	levels, query, grep, err := eventFilters()
+	if err != nil {
+		return err
+	}
+	parser, err := lib.NewEventParser(parserName)
+	if err != nil {
+		return err
+	}
+
+	svc, args, err := newClient(args)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := events.WithSignals(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	groups, err := lib.ExpandLogGroupsWithContext(ctx, svc, args)
+	if err != nil {
+		return err
+	}
+
+	sources := make([]*lib.EventIterator, 0, len(groups))
+	for _, group := range groups {
+		logReader, err := lib.NewCloudwatchLogsReader(group, task, start, end,
+			lib.WithClient(svc),
+			lib.WithContext(ctx),
+			lib.WithMaxStreams(maxStreams),
+			lib.WithParallelism(parallel),
+			lib.WithFilterPattern(readerPattern(levels)),
+			lib.WithParser(parser),
+		)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, logReader.Events(ctx, false))
+	}
+	merged := lib.MergeIterators(ctx, 0, sources...)
+	defer merged.Close()
+
+	selectEvents := eventSelector(levels, query, grep, 0, 0)
+	summary := lib.NewStats()
+	for {
+		event, err := merged.Next(ctx)
+		if err == io.EOF || lib.IsCanceled(err) {
+			// report what was read when interrupted
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for _, selected := range selectEvents(event) {
+			summary.Add(selected.Event)
+		}
+	}
+
+	if statsOutput == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summary.Report())
+	}
+	printStats(os.Stdout, summary)
+	return nil
+}
+
+// timeWindow returns the window given by --since and --until, the end is
+// now when --until isn't set
+func timeWindow(cmd *cobra.Command) (time.Time, time.Time, error) {
+	start, err := lib.GetTime(since, time.Now())
+	if err != nil {
+		return start, start, fmt.Errorf("Failed to parse time '%s'", since)
+	}
+
+	end := time.Now()
+	if cmd.Flags().Lookup("until").Changed {
+		if end, err = lib.GetTime(until, time.Now()); err != nil {
+			return start, end, fmt.Errorf("Failed to parse time '%s'", until)
+		}
+	}
+	return start, end, nil
+}
+
+// printStats writes the table report of stats
+func printStats(out io.Writer, stats *lib.Stats) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Events\t%d\n", stats.Events)
+	fmt.Fprintf(w, "Bytes\t%s\n", formatBytes(float64(stats.Bytes)))
+	if stats.Events > 0 {
+		fmt.Fprintf(w, "First\t%s\n", stats.First.Local().Format(lib.ShortTimeFormat))
+		fmt.Fprintf(w, "Last\t%s\n", stats.Last.Local().Format(lib.ShortTimeFormat))
+		fmt.Fprintf(w, "Duration\t%s\n", stats.Duration()/time.Second*time.Second)
+		fmt.Fprintf(w, "Events/s\t%.2f\n", stats.EventsPerSecond())
+		fmt.Fprintf(w, "Bytes/s\t%s\n", formatBytes(stats.BytesPerSecond()))
+	}
+	w.Flush()
+
+	breakdowns := []struct {
+		title   string
+		counts  map[string]int
+		byLevel bool
+	}{
+		{"Level", stats.Levels, true},
+		{"Stream", stats.Streams, false},
+		{"Host", stats.Hosts, false},
+		{"Error type", stats.Errors, false},
+	}
+	for _, b := range breakdowns {
+		if len(b.counts) == 0 {
+			continue
+		}
+		fmt.Fprintln(out)
+
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\tEvents\t%%\n", b.title)
+		counts := lib.SortCounts(b.counts, b.byLevel)
+		for ix, count := range counts {
+			if !b.byLevel && statsTop > 0 && ix == statsTop {
+				fmt.Fprintf(w, "(%d more)\t\t\n", len(counts)-ix)
+				break
+			}
+			fmt.Fprintf(w, "%s\t%d\t%.1f%%\n", count.Name, count.Count, 100*float64(count.Count)/float64(stats.Events))
+		}
+		w.Flush()
+	}
+}
+
+// formatBytes formats a number of bytes with a binary unit
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	for n >= 1024 && unit < len(units)-1 {
+		n /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[unit])
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Alias is a short name for one or more log groups, along with defaults used
+// when reading them.  Defaults are only used for the flags left unset.  Groups
+// can name other aliases.
+//
+//	aliases:
+//	  payments:
+//	    groups: [/ecs/prod/payments-api-v2, /ecs/prod/payments-worker]
+//	    since: 6h
+//	    filter_pattern: '-healthcheck'
+//	    format: oneline
+//	    aws_profile: prod
+//	    region: us-west-2
+type Alias struct {
+	Groups        []string `yaml:"groups"`
+	Task          string   `yaml:"task"`
+	Since         string   `yaml:"since"`
+	FilterPattern string   `yaml:"filter_pattern"`
+
+	// Format is the name of a format profile, or a template when it
+	// contains {{
+	Format string `yaml:"format"`
+
+	AWSProfile string `yaml:"aws_profile"`
+	Region     string `yaml:"region"`
+}
+
+// AliasNames returns the names of the configured aliases in order
+func (c *Config) AliasNames() []string {
+	names := make([]string, 0, len(c.Aliases))
+	for name := range c.Aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExpandAliases replaces the aliases in args by their groups, leaving other
+// names as they are.  It returns the defaults of the aliases used, aliases
+// setting different values for the same default can't be used together, and
+// aliases can't refer to themselves through their groups.
+func (c *Config) ExpandAliases(args []string) ([]string, Alias, error) {
+	var defaults Alias
+	setBy := map[string]string{}
+	merge := func(alias string, field string, value string, dst *string) error {
+		if value == "" {
+			return nil
+		}
+		if *dst != "" && *dst != value {
+			return fmt.Errorf("Aliases '%s' and '%s' have different %s", setBy[field], alias, field)
+		}
+		*dst = value
+		setBy[field] = alias
+		return nil
+	}
+
+	groups := []string{}
+	// via is the chain of aliases arg was reached through
+	var expand func(arg string, via []string) error
+	expand = func(arg string, via []string) error {
+		alias, ok := c.Aliases[arg]
+		if !ok {
+			groups = append(groups, arg)
+			return nil
+		}
+		via = append(via, arg)
+		for _, name := range via[:len(via)-1] {
+			if name == arg {
+				return fmt.Errorf("Alias '%s' refers to itself: %s", arg, strings.Join(via, " -> "))
+			}
+		}
+		if len(alias.Groups) == 0 {
+			return fmt.Errorf("Alias '%s' has no groups", arg)
+		}
+
+		for _, field := range []struct {
+			name  string
+			value string
+			dst   *string
+		}{
+			{"task", alias.Task, &defaults.Task},
+			{"since", alias.Since, &defaults.Since},
+			{"filter_pattern", alias.FilterPattern, &defaults.FilterPattern},
+			{"format", alias.Format, &defaults.Format},
+			{"aws_profile", alias.AWSProfile, &defaults.AWSProfile},
+			{"region", alias.Region, &defaults.Region},
+		} {
+			if err := merge(arg, field.name, field.value, field.dst); err != nil {
+				return err
+			}
+		}
+
+		for _, group := range alias.Groups {
+			if err := expand(group, via); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		if err := expand(arg, nil); err != nil {
+			return nil, defaults, err
+		}
+	}
+	defaults.Groups = groups
+	return groups, defaults, nil
+}
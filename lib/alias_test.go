@@ -0,0 +1,91 @@
+package lib
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestExpandAliases(t *testing.T) {
+	config := &Config{Aliases: map[string]Alias{
+		"payments": {Groups: []string{"/ecs/payments-api", "/ecs/payments-worker"}, Since: "6h", Format: "oneline", AWSProfile: "prod"},
+		"billing":  {Groups: []string{"/ecs/billing"}, Since: "6h", Region: "us-west-2"},
+		"money":    {Groups: []string{"payments", "billing", "/ecs/ledger"}, Task: "web"},
+		"staging":  {Groups: []string{"/ecs/staging"}, Since: "1h", AWSProfile: "staging"},
+		"empty":    {},
+		"loop":     {Groups: []string{"/ecs/a", "cycle"}},
+		"cycle":    {Groups: []string{"loop"}},
+		"self":     {Groups: []string{"self"}},
+	}}
+
+	tests := []struct {
+		args     []string
+		groups   string
+		defaults Alias
+		err      string
+	}{
+		{
+			args:   []string{"/ecs/other"},
+			groups: "[/ecs/other]",
+		},
+		{
+			args:     []string{"payments", "/ecs/other"},
+			groups:   "[/ecs/payments-api /ecs/payments-worker /ecs/other]",
+			defaults: Alias{Since: "6h", Format: "oneline", AWSProfile: "prod"},
+		},
+		{
+			// defaults merge when they agree
+			args:     []string{"payments", "billing"},
+			groups:   "[/ecs/payments-api /ecs/payments-worker /ecs/billing]",
+			defaults: Alias{Since: "6h", Format: "oneline", AWSProfile: "prod", Region: "us-west-2"},
+		},
+		{
+			args:     []string{"money"},
+			groups:   "[/ecs/payments-api /ecs/payments-worker /ecs/billing /ecs/ledger]",
+			defaults: Alias{Task: "web", Since: "6h", Format: "oneline", AWSProfile: "prod", Region: "us-west-2"},
+		},
+		{
+			args: []string{"payments", "staging"},
+			err:  "Aliases 'payments' and 'staging' have different since",
+		},
+		{
+			args: []string{"billing", "staging"},
+			err:  "Aliases 'billing' and 'staging' have different since",
+		},
+		{
+			args: []string{"empty"},
+			err:  "Alias 'empty' has no groups",
+		},
+		{
+			args: []string{"loop"},
+			err:  "Alias 'loop' refers to itself: loop -> cycle -> loop",
+		},
+		{
+			args: []string{"/ecs/other", "self"},
+			err:  "Alias 'self' refers to itself: self -> self",
+		},
+	}
+	for _, test := range tests {
+		groups, defaults, err := config.ExpandAliases(test.args)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%v: error = %v, want %s", test.args, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %s", test.args, err)
+			continue
+		}
+		if fmt.Sprint(groups) != test.groups {
+			t.Errorf("%v: groups = %v, want %s", test.args, groups, test.groups)
+		}
+		test.defaults.Groups = groups
+		if fmt.Sprintf("%+v", defaults) != fmt.Sprintf("%+v", test.defaults) {
+			t.Errorf("%v: defaults = %+v, want %+v", test.args, defaults, test.defaults)
+		}
+	}
+
+	if got := fmt.Sprint(config.AliasNames()); got != "[billing cycle empty loop money payments self staging]" {
+		t.Errorf("names = %s", got)
+	}
+}
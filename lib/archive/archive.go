@@ -0,0 +1,287 @@
+// Package archive stores CloudWatch Logs events in local files and serves
+// them back through lib.CloudwatchLogsClient, so readers and commands work the
+// same on an archive as on CloudWatch.  CloudWatch Logs exports to S3 can be
+// served the same way, see OpenExport.
+//
+// An archive holds the events of a single log group.  It starts with a magic
+// line and a JSON header, followed by blocks.  Each block is a JSON index line
+// giving the time range, streams and size of the block, then that many bytes
+// of gzip compressed JSON lines, one per event.  Blocks are only ever
+// appended, and the index lines let readers skip blocks outside the window
+// they are interested in without decompressing them.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/segmentio/cwlogs/lib"
+)
+
+// magic is the first line of every archive, it carries the format version
+const magic = "cwlogs-archive 1\n"
+
+// maxLineSize is the longest event line read back, CloudWatch Logs events are
+// at most 256KB
+const maxLineSize = 1024 * 1024
+
+// fileHeader describes the archive as a whole
+type fileHeader struct {
+	Group   string    `json:"group"`
+	Created time.Time `json:"created"`
+}
+
+// blockHeader is the index line written before every block
+type blockHeader struct {
+	Start   int64                  `json:"start"`
+	End     int64                  `json:"end"`
+	Count   int                    `json:"count"`
+	Size    int64                  `json:"size"`
+	Streams map[string]streamRange `json:"streams"`
+}
+
+// streamRange is the time range of the events of a stream within a block
+type streamRange struct {
+	First int64 `json:"first"`
+	Last  int64 `json:"last"`
+}
+
+// extendRange returns sr extended to include timestamp, a zero range is
+// treated as empty
+func extendRange(sr streamRange, timestamp int64) streamRange {
+	if sr == (streamRange{}) {
+		return streamRange{First: timestamp, Last: timestamp}
+	}
+	if timestamp < sr.First {
+		sr.First = timestamp
+	}
+	if timestamp > sr.Last {
+		sr.Last = timestamp
+	}
+	return sr
+}
+
+// record is an archived event
+type record struct {
+	Stream        string `json:"stream"`
+	ID            string `json:"id"`
+	Timestamp     int64  `json:"timestamp"`
+	IngestionTime int64  `json:"ingestion_time"`
+	Message       string `json:"message"`
+}
+
+// Writer appends blocks of events to an archive
+type Writer struct {
+	file  *os.File
+	group string
+}
+
+// Create opens the archive at path for appending, creating it for group if it
+// doesn't exist.  Appending to an archive of another group is an error.  A
+// block left incomplete by a crash is discarded.
+func Create(path string, group string) (*Writer, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if info.Size() == 0 {
+		header, _ := json.Marshal(fileHeader{Group: group, Created: time.Now().UTC()})
+		if _, err := file.WriteString(magic + string(header) + "\n"); err != nil {
+			file.Close()
+			return nil, err
+		}
+	} else {
+		header, _, end, err := scan(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("Can't append to '%s': %s", path, err)
+		}
+		if header.Group != group {
+			file.Close()
+			return nil, fmt.Errorf("Can't append to '%s', it is an archive of log group '%s'", path, header.Group)
+		}
+		if err := file.Truncate(end); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Writer{file: file, group: group}, nil
+}
+
+// Write appends events as a single block
+func (w *Writer) Write(events []*cloudwatchlogs.FilteredLogEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	header := blockHeader{
+		Start:   *events[0].Timestamp,
+		End:     *events[0].Timestamp,
+		Count:   len(events),
+		Streams: map[string]streamRange{},
+	}
+
+	var payload bytes.Buffer
+	gz := gzip.NewWriter(&payload)
+	enc := json.NewEncoder(gz)
+	for _, event := range events {
+		r := record{
+			Stream:        aws.StringValue(event.LogStreamName),
+			ID:            aws.StringValue(event.EventId),
+			Timestamp:     aws.Int64Value(event.Timestamp),
+			IngestionTime: aws.Int64Value(event.IngestionTime),
+			Message:       aws.StringValue(event.Message),
+		}
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+
+		if r.Timestamp < header.Start {
+			header.Start = r.Timestamp
+		}
+		if r.Timestamp > header.End {
+			header.End = r.Timestamp
+		}
+		header.Streams[r.Stream] = extendRange(header.Streams[r.Stream], r.Timestamp)
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	header.Size = int64(payload.Len())
+
+	line, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	// a single write keeps the index line and the payload together
+	block := make([]byte, 0, len(line)+1+payload.Len())
+	block = append(block, line...)
+	block = append(block, '\n')
+	block = append(block, payload.Bytes()...)
+	_, err = w.file.Write(block)
+	return err
+}
+
+// Close syncs and closes the archive
+func (w *Writer) Close() error {
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Archive pages through the events matching params and writes every page to
+// w as a block.  It returns the number of events written.
+func Archive(ctx context.Context, svc lib.CloudwatchLogsClient, params *cloudwatchlogs.FilterLogEventsInput, w *Writer) (int, error) {
+	input := *params
+	input.LogGroupName = aws.String(w.group)
+	input.NextToken = nil
+
+	count := 0
+	for {
+		o, err := svc.FilterLogEventsWithContext(ctx, &input)
+		if err != nil {
+			return count, err
+		}
+		if err := w.Write(o.Events); err != nil {
+			return count, err
+		}
+		count += len(o.Events)
+
+		if o.NextToken == nil {
+			return count, nil
+		}
+		input.NextToken = o.NextToken
+	}
+}
+
+// indexedBlock is a block header along with the offset of its payload
+type indexedBlock struct {
+	blockHeader
+	offset int64
+}
+
+// scan reads the header and block index of an archive.  It returns the offset
+// where the last complete block ends, a truncated block at the end of the file
+// is ignored.
+func scan(file *os.File) (fileHeader, []indexedBlock, int64, error) {
+	var header fileHeader
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return header, nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return header, nil, 0, err
+	}
+
+	r := bufio.NewReader(file)
+	line, err := r.ReadString('\n')
+	if err != nil || line != magic {
+		return header, nil, 0, fmt.Errorf("not a cwlogs archive")
+	}
+	offset := int64(len(line))
+
+	line, err = r.ReadString('\n')
+	if err != nil {
+		return header, nil, 0, fmt.Errorf("truncated archive header")
+	}
+	if err := json.Unmarshal([]byte(line), &header); err != nil {
+		return header, nil, 0, fmt.Errorf("invalid archive header: %s", err)
+	}
+	offset += int64(len(line))
+	end := offset
+
+	blocks := []indexedBlock{}
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			break
+		}
+		if err != nil {
+			// a partial index line, left by an interrupted write
+			break
+		}
+
+		var block indexedBlock
+		if err := json.Unmarshal([]byte(line), &block.blockHeader); err != nil {
+			return header, nil, 0, fmt.Errorf("invalid block index at offset %d: %s", offset, err)
+		}
+		block.offset = offset + int64(len(line))
+		if block.offset+block.Size > info.Size() {
+			break
+		}
+		if _, err := r.Discard(int(block.Size)); err != nil {
+			break
+		}
+
+		blocks = append(blocks, block)
+		offset = block.offset + block.Size
+		end = offset
+	}
+
+	return header, blocks, end, nil
+}
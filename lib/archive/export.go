@@ -0,0 +1,145 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/segmentio/cwlogs/lib"
+)
+
+// OpenExport serves the files written to S3 by a CloudWatch Logs export
+// task, synced to dir, as the events of group.
+//
+// Exports are laid out as <prefix>/<export id>/<stream>/000000.gz, with one
+// `<timestamp> <message>` line per event.  dir can be the prefix or any
+// directory above the streams, stream names are recovered from the path
+// below the export id, or below dir when no export id is found.  The time
+// range of a file is worked out the first time a read needs it, by scanning
+// the timestamps of its lines without keeping them, and its events are only
+// loaded for the pages overlapping it.
+func OpenExport(dir string, group string) (*Reader, error) {
+	r := &Reader{group: group}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".gz") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		stream := exportStream(filepath.ToSlash(filepath.Dir(rel)))
+		if stream == "" {
+			return nil
+		}
+
+		r.blocks = append(r.blocks, &blockRef{
+			name: fmt.Sprintf("export file '%s'", path),
+			index: func() (map[string]streamRange, error) {
+				return indexExportFile(path, stream)
+			},
+			read: func() ([]record, error) {
+				return readExportFile(path, stream)
+			},
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(r.blocks) == 0 {
+		return nil, fmt.Errorf("No export files found in '%s'", dir)
+	}
+	return r, nil
+}
+
+// exportStream returns the stream name of the files in dir, which is relative
+// to the export root.  Export ids are UUIDs, the stream is what follows the
+// first one.
+func exportStream(dir string) string {
+	if dir == "." {
+		return ""
+	}
+
+	parts := strings.Split(dir, "/")
+	for ix, part := range parts[:len(parts)-1] {
+		if lib.TaskUUIDPattern.MatchString(part) {
+			return strings.Join(parts[ix+1:], "/")
+		}
+	}
+	return dir
+}
+
+// indexExportFile returns the time range of the events of an export file
+func indexExportFile(path string, stream string) (map[string]streamRange, error) {
+	streams := map[string]streamRange{}
+	err := scanExportFile(path, func(line int, timestamp int64, message string) {
+		streams[stream] = extendRange(streams[stream], timestamp)
+	})
+	return streams, err
+}
+
+// readExportFile parses the lines of an export file.  Exports don't carry
+// event IDs or ingestion times, IDs are derived from the position of the line
+// and the ingestion time is the event time.
+func readExportFile(path string, stream string) ([]record, error) {
+	records := []record{}
+	err := scanExportFile(path, func(line int, timestamp int64, message string) {
+		records = append(records, record{
+			Stream:        stream,
+			ID:            fmt.Sprintf("%s:%08d", path, line),
+			Timestamp:     timestamp,
+			IngestionTime: timestamp,
+			Message:       message,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// scanExportFile calls fn with the line number, timestamp and message of
+// every line of an export file
+func scanExportFile(path string, fn func(line int, timestamp int64, message string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+
+		space := strings.IndexByte(text, ' ')
+		if space < 0 {
+			return fmt.Errorf("line %d: missing timestamp", line)
+		}
+		t, err := time.Parse(time.RFC3339Nano, text[:space])
+		if err != nil {
+			return fmt.Errorf("line %d: invalid timestamp '%s'", line, text[:space])
+		}
+		fn(line, t.UnixNano()/int64(time.Millisecond), text[space+1:])
+	}
+	return scanner.Err()
+}
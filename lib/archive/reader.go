@@ -0,0 +1,486 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/segmentio/cwlogs/lib"
+)
+
+// Reader serves the events of an archive or of an export as a CloudWatch
+// Logs client holding a single log group.  Filter calls return pages of at
+// most lib.MaxEventsPerCall events linked by NextToken, like CloudWatch, and
+// only decompress the blocks overlapping the page.  It is safe for concurrent
+// use.
+type Reader struct {
+	group   string
+	created int64
+
+	mu      sync.Mutex
+	closers []io.Closer
+	blocks  []*blockRef
+}
+
+// blockRef is a unit of events loaded at once, a block of an archive or a
+// file of an export.  The time range of an export file is only known once
+// indexed.
+type blockRef struct {
+	name    string
+	known   bool
+	start   int64
+	end     int64
+	streams map[string]streamRange
+	index   func() (map[string]streamRange, error)
+	read    func() ([]record, error)
+	records []record
+}
+
+var _ lib.CloudwatchLogsClient = (*Reader)(nil)
+
+// Open reads the index of the archive at path
+func Open(path string) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header, blocks, _, err := scan(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Can't read archive '%s': %s", path, err)
+	}
+
+	r := &Reader{
+		group:   header.Group,
+		created: header.Created.UnixNano() / 1e6,
+		closers: []io.Closer{file},
+	}
+	for _, block := range blocks {
+		block := block
+		r.blocks = append(r.blocks, &blockRef{
+			name:    fmt.Sprintf("block at offset %d of '%s'", block.offset, path),
+			known:   true,
+			start:   block.Start,
+			end:     block.End,
+			streams: block.Streams,
+			read: func() ([]record, error) {
+				return readBlock(io.NewSectionReader(file, block.offset, block.Size), block.Count)
+			},
+		})
+	}
+	return r, nil
+}
+
+// Group returns the name of the log group
+func (r *Reader) Group() string {
+	return r.group
+}
+
+// Close closes the underlying files
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	for _, closer := range r.closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// canceled returns the error the SDK returns for calls made with a done
+// context
+func canceled(ctx aws.Context) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	return nil
+}
+
+func notFound() error {
+	return awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log group does not exist.", nil)
+}
+
+// DescribeLogGroupsWithContext returns the archived group if it matches the
+// prefix
+func (r *Reader) DescribeLogGroupsWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogGroupsInput, opts ...request.Option) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	out := &cloudwatchlogs.DescribeLogGroupsOutput{LogGroups: []*cloudwatchlogs.LogGroup{}}
+	if strings.HasPrefix(r.group, aws.StringValue(input.LogGroupNamePrefix)) {
+		out.LogGroups = append(out.LogGroups, &cloudwatchlogs.LogGroup{
+			LogGroupName: aws.String(r.group),
+			CreationTime: aws.Int64(r.created),
+		})
+	}
+	return out, nil
+}
+
+// DescribeLogStreamsPagesWithContext returns the archived streams in a single
+// page.  The creation time of a stream is the time of its first archived
+// event.
+func (r *Reader) DescribeLogStreamsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogStreamsInput, fn func(*cloudwatchlogs.DescribeLogStreamsOutput, bool) bool, opts ...request.Option) error {
+	if err := canceled(ctx); err != nil {
+		return err
+	}
+	if aws.StringValue(input.LogGroupName) != r.group {
+		return notFound()
+	}
+
+	streams, err := r.streamRanges()
+	if err != nil {
+		return err
+	}
+
+	out := &cloudwatchlogs.DescribeLogStreamsOutput{LogStreams: []*cloudwatchlogs.LogStream{}}
+	for name, s := range streams {
+		if !strings.HasPrefix(name, aws.StringValue(input.LogStreamNamePrefix)) {
+			continue
+		}
+		out.LogStreams = append(out.LogStreams, &cloudwatchlogs.LogStream{
+			LogStreamName:       aws.String(name),
+			CreationTime:        aws.Int64(s.First),
+			FirstEventTimestamp: aws.Int64(s.First),
+			LastEventTimestamp:  aws.Int64(s.Last),
+			LastIngestionTime:   aws.Int64(s.Last),
+		})
+	}
+
+	byTime := aws.StringValue(input.OrderBy) == cloudwatchlogs.OrderByLastEventTime
+	descending := aws.BoolValue(input.Descending)
+	sort.Slice(out.LogStreams, func(i, j int) bool {
+		a, b := out.LogStreams[i], out.LogStreams[j]
+		if byTime && *a.LastEventTimestamp != *b.LastEventTimestamp {
+			return (*a.LastEventTimestamp < *b.LastEventTimestamp) != descending
+		}
+		return (*a.LogStreamName < *b.LogStreamName) != descending
+	})
+
+	fn(out, true)
+	return nil
+}
+
+// FilterLogEventsWithContext returns the archived events within the window,
+// ordered by timestamp.  Events archived more than once are returned once.
+func (r *Reader) FilterLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.FilterLogEventsInput, opts ...request.Option) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	if aws.StringValue(input.LogGroupName) != r.group {
+		return nil, notFound()
+	}
+
+	var pattern *lib.FilterPattern
+	if input.FilterPattern != nil {
+		var err error
+		if pattern, err = lib.ParseFilterPattern(*input.FilterPattern); err != nil {
+			return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "Invalid filter pattern", err)
+		}
+	}
+
+	var streams map[string]bool
+	if input.LogStreamNames != nil {
+		streams = map[string]bool{}
+		for _, name := range input.LogStreamNames {
+			streams[aws.StringValue(name)] = true
+		}
+	}
+
+	after, err := parsePageToken(input.NextToken)
+	if err != nil {
+		return nil, err
+	}
+	limit := lib.MaxEventsPerCall
+	if input.Limit != nil && *input.Limit > 0 && *input.Limit < int64(limit) {
+		limit = int(*input.Limit)
+	}
+
+	records, more, err := r.page(input.StartTime, input.EndTime, after, limit, streams, func(rec record) bool {
+		return pattern == nil || pattern.Match(rec.Message)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := &cloudwatchlogs.FilterLogEventsOutput{Events: []*cloudwatchlogs.FilteredLogEvent{}}
+	for _, rec := range records {
+		out.Events = append(out.Events, rec.filtered())
+	}
+	if more {
+		out.NextToken = pageToken(records[len(records)-1])
+	}
+	return out, nil
+}
+
+// GetLogEventsWithContext returns the archived events of a stream within the
+// window in a single page, oldest first
+func (r *Reader) GetLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.GetLogEventsInput, opts ...request.Option) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+	if aws.StringValue(input.LogGroupName) != r.group {
+		return nil, notFound()
+	}
+
+	var end *int64
+	if input.EndTime != nil {
+		// the end of GetLogEvents is exclusive
+		end = aws.Int64(*input.EndTime - 1)
+	}
+	streams := map[string]bool{aws.StringValue(input.LogStreamName): true}
+	records, _, err := r.page(input.StartTime, end, nil, 0, streams, func(record) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+
+	out := &cloudwatchlogs.GetLogEventsOutput{Events: []*cloudwatchlogs.OutputLogEvent{}}
+	for _, rec := range records {
+		out.Events = append(out.Events, &cloudwatchlogs.OutputLogEvent{
+			IngestionTime: aws.Int64(rec.IngestionTime),
+			Message:       aws.String(rec.Message),
+			Timestamp:     aws.Int64(rec.Timestamp),
+		})
+	}
+	return out, nil
+}
+
+// streamRanges returns the time range of every stream, indexing the blocks
+// whose range isn't known yet
+func (r *Reader) streamRanges() (map[string]streamRange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	streams := map[string]streamRange{}
+	for _, block := range r.blocks {
+		if err := block.indexed(); err != nil {
+			return nil, err
+		}
+		for name, sr := range block.streams {
+			if s, ok := streams[name]; ok {
+				if s.First < sr.First {
+					sr.First = s.First
+				}
+				if s.Last > sr.Last {
+					sr.Last = s.Last
+				}
+			}
+			streams[name] = sr
+		}
+	}
+	return streams, nil
+}
+
+// pagePosition is the timestamp and ID of the last record of a page
+type pagePosition struct {
+	timestamp int64
+	id        string
+}
+
+func pageToken(rec record) *string {
+	return aws.String(fmt.Sprintf("%d/%s", rec.Timestamp, rec.ID))
+}
+
+func parsePageToken(token *string) (*pagePosition, error) {
+	if token == nil {
+		return nil, nil
+	}
+	parts := strings.SplitN(*token, "/", 2)
+	if len(parts) == 2 {
+		if timestamp, err := strconv.ParseInt(parts[0], 10, 64); err == nil {
+			return &pagePosition{timestamp: timestamp, id: parts[1]}, nil
+		}
+	}
+	return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, fmt.Sprintf("The specified nextToken is invalid: %s", *token), nil)
+}
+
+// page returns up to limit records within [start, end] coming after the
+// position after, ordered by timestamp and ID and without duplicates, and
+// whether more records may follow.  A zero limit returns every record.
+// Only the records of the given streams, or all if nil, for which match
+// returns true are kept.
+//
+// Blocks are loaded in the order of their start until the page is full, so
+// a page only decompresses the blocks it overlaps.  Those stay in memory for
+// the next page, the others are dropped.
+func (r *Reader) page(start *int64, end *int64, after *pagePosition, limit int, streams map[string]bool, match func(record) bool) ([]record, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	from := start
+	if after != nil && (from == nil || after.timestamp > *from) {
+		from = &after.timestamp
+	}
+
+	candidates := []*blockRef{}
+	for _, block := range r.blocks {
+		if err := block.indexed(); err != nil {
+			return nil, false, err
+		}
+		if block.overlaps(from, end) && block.holds(streams) {
+			candidates = append(candidates, block)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].start < candidates[j].start })
+
+	used := map[*blockRef]bool{}
+	seen := map[string]bool{}
+	records := []record{}
+	more := false
+	for _, block := range candidates {
+		if limit > 0 && len(records) >= limit {
+			sortRecords(records)
+			if block.start > records[limit-1].Timestamp {
+				// the blocks left only hold records after the page
+				more = true
+				break
+			}
+		}
+
+		blockRecords, err := block.load()
+		if err != nil {
+			return nil, false, err
+		}
+		used[block] = true
+		for _, rec := range blockRecords {
+			if (from != nil && rec.Timestamp < *from) || (end != nil && rec.Timestamp > *end) {
+				continue
+			}
+			if after != nil && (rec.Timestamp < after.timestamp || (rec.Timestamp == after.timestamp && rec.ID <= after.id)) {
+				continue
+			}
+			if seen[rec.ID] || (streams != nil && !streams[rec.Stream]) || !match(rec) {
+				continue
+			}
+			seen[rec.ID] = true
+			records = append(records, rec)
+		}
+	}
+
+	for _, block := range r.blocks {
+		if !used[block] {
+			block.records = nil
+		}
+	}
+
+	sortRecords(records)
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+		more = true
+	}
+	return records, more, nil
+}
+
+func sortRecords(records []record) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Timestamp != records[j].Timestamp {
+			return records[i].Timestamp < records[j].Timestamp
+		}
+		return records[i].ID < records[j].ID
+	})
+}
+
+// indexed makes sure the time range and streams of the block are known
+func (b *blockRef) indexed() error {
+	if b.known {
+		return nil
+	}
+	streams, err := b.index()
+	if err != nil {
+		return fmt.Errorf("Corrupted %s: %s", b.name, err)
+	}
+	b.streams = streams
+	first := true
+	for _, sr := range streams {
+		if first || sr.First < b.start {
+			b.start = sr.First
+		}
+		if first || sr.Last > b.end {
+			b.end = sr.Last
+		}
+		first = false
+	}
+	b.known = true
+	return nil
+}
+
+// overlaps reports whether the block holds records within [start, end]
+func (b *blockRef) overlaps(start *int64, end *int64) bool {
+	if len(b.streams) == 0 {
+		return false
+	}
+	return !(start != nil && b.end < *start) && !(end != nil && b.start > *end)
+}
+
+// holds reports whether the block has records of any of streams, or of any
+// stream if streams is nil
+func (b *blockRef) holds(streams map[string]bool) bool {
+	if streams == nil {
+		return true
+	}
+	for name := range b.streams {
+		if streams[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads the records of a block, keeping them in memory until a page
+// doesn't need them
+func (b *blockRef) load() ([]record, error) {
+	if b.records != nil {
+		return b.records, nil
+	}
+	records, err := b.read()
+	if err != nil {
+		return nil, fmt.Errorf("Corrupted %s: %s", b.name, err)
+	}
+	b.records = records
+	return records, nil
+}
+
+// readBlock decompresses the records of an archive block
+func readBlock(r io.Reader, count int) ([]record, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	records := make([]record, 0, count)
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+func (rec record) filtered() *cloudwatchlogs.FilteredLogEvent {
+	return &cloudwatchlogs.FilteredLogEvent{
+		EventId:       aws.String(rec.ID),
+		IngestionTime: aws.Int64(rec.IngestionTime),
+		LogStreamName: aws.String(rec.Stream),
+		Message:       aws.String(rec.Message),
+		Timestamp:     aws.Int64(rec.Timestamp),
+	}
+}
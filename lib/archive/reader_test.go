@@ -0,0 +1,213 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+var base = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func millis(seconds int) int64 {
+	return base.Add(time.Duration(seconds)*time.Second).UnixNano() / int64(time.Millisecond)
+}
+
+func event(stream string, seconds int) *cloudwatchlogs.FilteredLogEvent {
+	id := fmt.Sprintf("%s-%03d", stream, seconds)
+	return &cloudwatchlogs.FilteredLogEvent{
+		EventId:       aws.String(id),
+		IngestionTime: aws.Int64(millis(seconds)),
+		LogStreamName: aws.String(stream),
+		Message:       aws.String(id),
+		Timestamp:     aws.Int64(millis(seconds)),
+	}
+}
+
+// countReads counts the blocks decompressed by r
+func countReads(r *Reader) *int {
+	reads := 0
+	for _, block := range r.blocks {
+		read := block.read
+		block.read = func() ([]record, error) {
+			reads++
+			return read()
+		}
+	}
+	return &reads
+}
+
+// filterAll pages through the events of input, returning their messages and
+// the number of pages
+func filterAll(t *testing.T, r *Reader, input cloudwatchlogs.FilterLogEventsInput) ([]string, int) {
+	input.LogGroupName = aws.String("group")
+	messages := []string{}
+	pages := 0
+	for {
+		out, err := r.FilterLogEventsWithContext(context.Background(), &input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		if input.Limit != nil && len(out.Events) > int(*input.Limit) {
+			t.Errorf("page of %d events, want at most %d", len(out.Events), *input.Limit)
+		}
+		for _, e := range out.Events {
+			messages = append(messages, *e.Message)
+		}
+		if out.NextToken == nil {
+			return messages, pages
+		}
+		input.NextToken = out.NextToken
+	}
+}
+
+func TestArchivePages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "group.cwa")
+
+	w, err := Create(path, "group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := [][]*cloudwatchlogs.FilteredLogEvent{
+		{event("a", 1), event("b", 2), event("a", 3), event("b", 4)},
+		// overlaps the first block, with an event archived twice
+		{event("b", 4), event("a", 5), event("b", 6)},
+		{event("a", 20), event("b", 21), event("a", 22)},
+		{event("b", 40), event("a", 41)},
+	}
+	for _, block := range blocks {
+		if err := w.Write(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	reads := countReads(r)
+
+	got, pages := filterAll(t, r, cloudwatchlogs.FilterLogEventsInput{Limit: aws.Int64(3)})
+	want := "[a-001 b-002 a-003 b-004 a-005 b-006 a-020 b-021 a-022 b-040 a-041]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("events = %v, want %s", got, want)
+	}
+	if pages != 4 {
+		t.Errorf("%d pages, want 4", pages)
+	}
+	// the blocks a page ends in are kept for the next page
+	if *reads != len(blocks) {
+		t.Errorf("%d blocks decompressed, want %d", *reads, len(blocks))
+	}
+
+	// a window only decompresses the blocks it overlaps
+	for _, block := range r.blocks {
+		block.records = nil
+	}
+	*reads = 0
+	got, _ = filterAll(t, r, cloudwatchlogs.FilterLogEventsInput{
+		StartTime:      aws.Int64(millis(10)),
+		EndTime:        aws.Int64(millis(30)),
+		LogStreamNames: aws.StringSlice([]string{"a"}),
+		Limit:          aws.Int64(1),
+	})
+	if want := "[a-020 a-022]"; fmt.Sprint(got) != want {
+		t.Errorf("events = %v, want %s", got, want)
+	}
+	if *reads != 1 {
+		t.Errorf("%d blocks decompressed, want 1", *reads)
+	}
+
+	_, err = r.FilterLogEventsWithContext(context.Background(), &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName: aws.String("group"),
+		NextToken:    aws.String("invalid"),
+	})
+	if err == nil {
+		t.Error("expected an error for an invalid token")
+	}
+}
+
+func writeExportFile(t *testing.T, path string, seconds ...int) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(file)
+	for _, s := range seconds {
+		fmt.Fprintf(gz, "%s line at %d\n", time.Unix(0, millis(s)*int64(time.Millisecond)).UTC().Format(time.RFC3339Nano), s)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportPages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	export := filepath.Join(dir, "exports", "0f4c5a3e-1b2c-4d5e-8f90-a1b2c3d4e5f6")
+	writeExportFile(t, filepath.Join(export, "web", "000000.gz"), 1, 2, 3)
+	writeExportFile(t, filepath.Join(export, "web", "000001.gz"), 30, 31)
+	writeExportFile(t, filepath.Join(export, "worker", "000000.gz"), 2, 4, 32)
+
+	r, err := OpenExport(dir, "group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reads := countReads(r)
+
+	// listing streams indexes the files without keeping their events
+	streams, err := r.streamRanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if web := streams["web"]; web.First != millis(1) || web.Last != millis(31) {
+		t.Errorf("web range = %d..%d, want %d..%d", web.First, web.Last, millis(1), millis(31))
+	}
+	if *reads != 0 {
+		t.Errorf("%d files decompressed by streamRanges, want 0", *reads)
+	}
+
+	got, pages := filterAll(t, r, cloudwatchlogs.FilterLogEventsInput{
+		StartTime: aws.Int64(millis(0)),
+		EndTime:   aws.Int64(millis(10)),
+		Limit:     aws.Int64(2),
+	})
+	want := "[line at 1 line at 2 line at 2 line at 3 line at 4]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("events = %v, want %s", got, want)
+	}
+	if pages != 3 {
+		t.Errorf("%d pages, want 3", pages)
+	}
+	// the second file of web is outside the window
+	if *reads != 2 {
+		t.Errorf("%d files decompressed, want 2", *reads)
+	}
+}
@@ -0,0 +1,221 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checkpointVersion is bumped when the checkpoint file format changes.
+// Version 1 files only have the IDs at the position's timestamp.
+const checkpointVersion = 2
+
+// Checkpoint records how far the events of one or more log groups were
+// processed, so an interrupted fetch can resume without gaps or duplicates.
+// It is safe for concurrent use.
+type Checkpoint struct {
+	mu     sync.Mutex
+	dirty  bool
+	window time.Duration
+	groups map[string]*CheckpointPosition
+}
+
+// CheckpointPosition is the position of a log group in a checkpoint, along
+// with the parameters it was read with
+type CheckpointPosition struct {
+	StreamPrefix  string `json:"stream_prefix,omitempty"`
+	FilterPattern string `json:"filter_pattern,omitempty"`
+
+	// Timestamp is the creation time in milliseconds of the last processed
+	// event, and IDs are the IDs of the processed events at that time
+	Timestamp int64    `json:"timestamp"`
+	IDs       []string `json:"ids,omitempty"`
+
+	// Earlier maps the IDs of the processed events from Since up to
+	// Timestamp to their creation time, so that events arriving late in
+	// that window can be told apart from the ones already processed
+	Since   int64            `json:"since,omitempty"`
+	Earlier map[string]int64 `json:"earlier,omitempty"`
+}
+
+type checkpointFile struct {
+	Version int                            `json:"version"`
+	Groups  map[string]*CheckpointPosition `json:"groups"`
+}
+
+// NewCheckpoint returns an empty checkpoint remembering the IDs of the events
+// processed up to window before the newest one, the window a resumed reader
+// goes back to pick up events that arrived late.  It should match the reader's
+// dedup window.
+func NewCheckpoint(window time.Duration) *Checkpoint {
+	return &Checkpoint{window: window, groups: map[string]*CheckpointPosition{}}
+}
+
+// LoadCheckpoint reads a checkpoint from path, a missing file is an empty
+// checkpoint.  See NewCheckpoint for window.
+func LoadCheckpoint(path string, window time.Duration) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return NewCheckpoint(window), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file checkpointFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("Invalid checkpoint file '%s': %s", path, err)
+	}
+	if file.Version < 1 || file.Version > checkpointVersion {
+		return nil, fmt.Errorf("Unsupported checkpoint file version %d in '%s'", file.Version, path)
+	}
+
+	checkpoint := NewCheckpoint(window)
+	for group, position := range file.Groups {
+		if position == nil {
+			continue
+		}
+		if file.Version == 1 {
+			// nothing is known about the events before the timestamp
+			position.Since = position.Timestamp
+		}
+		checkpoint.groups[group] = position
+	}
+	return checkpoint, nil
+}
+
+// Resume returns the position to resume group from, or nil if the group has
+// no position yet.  It fails if the group was checkpointed with a different
+// stream prefix or filter pattern, since resuming would skip events.
+func (c *Checkpoint) Resume(group string, streamPrefix string, filterPattern string) (*CheckpointPosition, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	position, ok := c.groups[group]
+	if !ok {
+		c.groups[group] = &CheckpointPosition{StreamPrefix: streamPrefix, FilterPattern: filterPattern}
+		return nil, nil
+	}
+	if position.StreamPrefix != streamPrefix || position.FilterPattern != filterPattern {
+		return nil, fmt.Errorf("The checkpoint of log group '%s' was written with task '%s' and filter pattern '%s', use another checkpoint file to change them", group, position.StreamPrefix, position.FilterPattern)
+	}
+	if position.Timestamp == 0 {
+		return nil, nil
+	}
+
+	resume := *position
+	resume.IDs = append([]string(nil), position.IDs...)
+	resume.Earlier = make(map[string]int64, len(position.Earlier))
+	for id, timestamp := range position.Earlier {
+		resume.Earlier[id] = timestamp
+	}
+	return &resume, nil
+}
+
+// Observe advances the position of the event's log group, along with the
+// events joined to it.  Events must be observed in creation time order, except
+// for events arriving late within the checkpoint's window, older ones are
+// ignored.
+func (c *Checkpoint) Observe(e Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	position, ok := c.groups[e.Group]
+	if !ok {
+		position = &CheckpointPosition{}
+		c.groups[e.Group] = position
+	}
+
+	c.observe(position, e.ID, e.CreationTime)
+	for _, joined := range e.Joined {
+		c.observe(position, joined.ID, joined.CreationTime)
+	}
+}
+
+// observe advances position to an event, c.mu must be held
+func (c *Checkpoint) observe(position *CheckpointPosition, id string, created time.Time) {
+	timestamp := created.UnixNano() / int64(time.Millisecond)
+	switch {
+	case timestamp > position.Timestamp:
+		if position.Earlier == nil {
+			position.Earlier = map[string]int64{}
+		}
+		for _, previous := range position.IDs {
+			position.Earlier[previous] = position.Timestamp
+		}
+		position.Timestamp = timestamp
+		position.IDs = []string{id}
+
+		// the events that fell out of the window are forgotten when saving
+		if since := timestamp - int64(c.window/time.Millisecond); since > position.Since {
+			position.Since = since
+		}
+	case timestamp == position.Timestamp:
+		position.IDs = append(position.IDs, id)
+	case timestamp >= position.Since:
+		if position.Earlier == nil {
+			position.Earlier = map[string]int64{}
+		}
+		position.Earlier[id] = timestamp
+	default:
+		return
+	}
+	c.dirty = true
+}
+
+// Save writes the checkpoint to path if it changed since it was last saved.
+// The file is replaced atomically so a crash never leaves a partial
+// checkpoint behind.
+func (c *Checkpoint) Save(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	for _, position := range c.groups {
+		for id, timestamp := range position.Earlier {
+			if timestamp < position.Since {
+				delete(position.Earlier, id)
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(checkpointFile{Version: checkpointVersion, Groups: c.groups}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("Failed to save checkpoint: %s", err)
+	}
+	c.dirty = false
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// over path once it is synced to disk
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestCheckpointObserveJoined(t *testing.T) {
+	base := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	line := func(id string, millis int, message string) Event {
+		e := Event{Group: "group", Stream: "stream", ID: id, CreationTime: base.Add(time.Duration(millis) * time.Millisecond)}
+		e.Message = message
+		e.Time = e.CreationTime
+		return e
+	}
+
+	joiner, err := NewJoiner("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	joiner.Add(line("a", 0, "panic: boom"), now)
+	joiner.Add(line("b", 1, "\tat main.go:12"), now)
+	joiner.Add(line("c", 1, "\tat main.go:20"), now)
+	records := joiner.Flush()
+	if len(records) != 1 {
+		t.Fatalf("%d records, want 1", len(records))
+	}
+
+	checkpoint := NewCheckpoint(time.Second)
+	checkpoint.Observe(records[0])
+	position := checkpoint.groups["group"]
+	want := base.Add(time.Millisecond).UnixNano() / int64(time.Millisecond)
+	if position.Timestamp != want || fmt.Sprint(position.IDs) != "[b c]" {
+		t.Errorf("position = %d %v, want %d [b c]", position.Timestamp, position.IDs, want)
+	}
+
+	// resuming skips every line of the record
+	emitted := []string{}
+	emit := skipResumed(position, func(e Event) error {
+		emitted = append(emitted, e.ID)
+		return nil
+	})
+	for _, e := range []Event{line("a", 0, ""), line("b", 1, ""), line("c", 1, ""), line("d", 1, ""), line("e", 2, "")} {
+		emit(e)
+	}
+	if fmt.Sprint(emitted) != "[d e]" {
+		t.Errorf("events after resuming = %v, want [d e]", emitted)
+	}
+}
+
+func TestCheckpointWindow(t *testing.T) {
+	base := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	event := func(id string, seconds int) Event {
+		return Event{Group: "group", ID: id, CreationTime: base.Add(time.Duration(seconds) * time.Second)}
+	}
+	millis := func(seconds int) int64 {
+		return base.Add(time.Duration(seconds)*time.Second).UnixNano() / int64(time.Millisecond)
+	}
+
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	checkpoint := NewCheckpoint(5 * time.Second)
+	for _, e := range []Event{event("a", 0), event("b", 4), event("c", 8), event("d", 10), event("e", 10)} {
+		checkpoint.Observe(e)
+	}
+	// late events are remembered within the window only
+	checkpoint.Observe(event("late", 6))
+	checkpoint.Observe(event("too-late", 2))
+	if err := checkpoint.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadCheckpoint(path, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	position, err := loaded.Resume("group", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	earlier := []string{}
+	for id := range position.Earlier {
+		earlier = append(earlier, id)
+	}
+	sort.Strings(earlier)
+	if position.Timestamp != millis(10) || position.Since != millis(5) || fmt.Sprint(position.IDs) != "[d e]" || fmt.Sprint(earlier) != "[c late]" {
+		t.Errorf("position = %d since %d %v %v", position.Timestamp, position.Since, position.IDs, earlier)
+	}
+
+	emitted := []string{}
+	emit := skipResumed(position, func(e Event) error {
+		emitted = append(emitted, e.ID)
+		return nil
+	})
+	for _, e := range []Event{event("b", 4), event("c", 8), event("missed", 6), event("late", 6), event("d", 10), event("f", 10), event("g", 11)} {
+		emit(e)
+	}
+	if fmt.Sprint(emitted) != "[missed f g]" {
+		t.Errorf("events after resuming = %v, want [missed f g]", emitted)
+	}
+}
+
+func TestLoadCheckpointVersion1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "checkpoint.json")
+	if err := ioutil.WriteFile(path, []byte(`{"version":1,"groups":{"group":{"timestamp":1000,"ids":["a"]}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := LoadCheckpoint(path, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing before the timestamp is known to be processed
+	position, err := checkpoint.Resume("group", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position.Since != 1000 {
+		t.Errorf("version 1 position since %d, want 1000", position.Since)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"version":3,"groups":{}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCheckpoint(path, time.Second); err == nil {
+		t.Error("expected an error loading version 3")
+	}
+}
@@ -0,0 +1,19 @@
+package lib
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// CloudwatchLogsClient is the subset of the CloudWatch Logs API used by
+// CloudwatchLogsReader.  It is satisfied by *cloudwatchlogs.CloudWatchLogs and
+// by the in-memory implementation in the lib/fake package.
+type CloudwatchLogsClient interface {
+	DescribeLogGroupsWithContext(aws.Context, *cloudwatchlogs.DescribeLogGroupsInput, ...request.Option) (*cloudwatchlogs.DescribeLogGroupsOutput, error)
+	DescribeLogStreamsPagesWithContext(aws.Context, *cloudwatchlogs.DescribeLogStreamsInput, func(*cloudwatchlogs.DescribeLogStreamsOutput, bool) bool, ...request.Option) error
+	FilterLogEventsWithContext(aws.Context, *cloudwatchlogs.FilterLogEventsInput, ...request.Option) (*cloudwatchlogs.FilterLogEventsOutput, error)
+	GetLogEventsWithContext(aws.Context, *cloudwatchlogs.GetLogEventsInput, ...request.Option) (*cloudwatchlogs.GetLogEventsOutput, error)
+}
+
+var _ CloudwatchLogsClient = (*cloudwatchlogs.CloudWatchLogs)(nil)
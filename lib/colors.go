@@ -17,6 +17,9 @@ var (
 	Magenta = color.New(color.FgMagenta).SprintFunc()
 	Cyan    = color.New(color.FgCyan).SprintFunc()
 	White   = color.New(color.FgWhite).SprintFunc()
+
+	// Highlight marks the parts of a log event matched by a search
+	Highlight = color.New(color.FgRed, color.Bold).SprintFunc()
 )
 
 var colorPool = []*color.Color{
@@ -56,3 +59,20 @@ func ColorLevel(l ecslogs.Level) string {
 		return fmt.Sprint(l)
 	}
 }
+
+// LevelColor returns the color charts draw a level with, from red for the
+// most severe levels to blue for debugging
+func LevelColor(l ecslogs.Level) *color.Color {
+	switch {
+	case l == ecslogs.NONE:
+		return color.New(color.FgWhite)
+	case l <= ecslogs.ERROR:
+		return color.New(color.FgRed)
+	case l == ecslogs.WARN:
+		return color.New(color.FgYellow)
+	case l <= ecslogs.INFO:
+		return color.New(color.FgGreen)
+	default:
+		return color.New(color.FgBlue)
+	}
+}
@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config holds the settings read from the cwlogs config file
+//
+//	formats:
+//	  oneline:
+//	    format: '{{ .TimeShort }} {{ .Message }}'
+//	  detailed:
+//	    files: [partials.tmpl]
+//	    format: '{{ template "line" . }}'
+//	    levels:
+//	      ERROR: '{{ template "line" . }} {{ json .Info.Errors }}'
+//	aliases:
+//	  payments:
+//	    groups: [/ecs/prod/payments-api-v2]
+//	    format: oneline
+//
+// See FormatProfile and Alias for the settings of formats and aliases.
+type Config struct {
+	Formats map[string]FormatProfile `yaml:"formats"`
+	Aliases map[string]Alias         `yaml:"aliases"`
+
+	// dir is the directory of the config file, relative paths in the config
+	// are relative to it
+	dir string
+}
+
+// DefaultConfigPath returns the path of the config file, which is
+// $XDG_CONFIG_HOME/cwlogs/config.yaml or ~/.config/cwlogs/config.yaml
+func DefaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "cwlogs", "config.yaml")
+}
+
+// LoadConfig reads the config file at path, a missing file is an empty config
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{dir: filepath.Dir(path)}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("Invalid config file '%s': %s", path, err)
+	}
+	return config, nil
+}
+
+// FormatProfile returns the profile called name, profiles from the config
+// file take precedence over BuiltinFormatProfiles
+func (c *Config) FormatProfile(name string) (FormatProfile, error) {
+	if profile, ok := c.Formats[name]; ok {
+		files := make([]string, 0, len(profile.Files))
+		for _, file := range profile.Files {
+			files = append(files, c.path(file))
+		}
+		profile.Files = files
+		return profile, nil
+	}
+	if profile, ok := BuiltinFormatProfiles[name]; ok {
+		return profile, nil
+	}
+	return FormatProfile{}, fmt.Errorf("Unknown format profile '%s', expected one of %s", name, strings.Join(c.FormatProfileNames(), ", "))
+}
+
+// FormatProfileNames returns the names of the built-in and configured profiles
+func (c *Config) FormatProfileNames() []string {
+	names := []string{}
+	for name := range BuiltinFormatProfiles {
+		if _, ok := c.Formats[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for name := range c.Formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// path expands ~ and resolves paths relative to the config file
+func (c *Config) path(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[2:])
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(c.dir, path)
+	}
+	return path
+}
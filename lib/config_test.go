@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+formats:
+  oneline:
+    format: '{{ .Message }}'
+  detailed:
+    files: [partials.tmpl, /etc/cwlogs/shared.tmpl, ~/cwlogs.tmpl]
+    levels:
+      ERROR: '{{ template "line" . }} {{ json .Info.Errors }}'
+  default:
+    format: '{{ .Level }} {{ .Message }}'
+aliases:
+  payments:
+    groups: [/ecs/prod/payments-api-v2]
+    format: oneline
+`
+
+// writeConfig writes a config file to a temporary directory, the returned
+// func removes it
+func writeConfig(t *testing.T, config string) (string, func()) {
+	dir, err := ioutil.TempDir("", "cwlogs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadConfig(t *testing.T) {
+	path, remove := writeConfig(t, testConfig)
+	defer remove()
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Formats) != 3 || len(config.Aliases) != 1 || config.Aliases["payments"].Format != "oneline" {
+		t.Errorf("config = %+v", config)
+	}
+
+	// a missing file is an empty config
+	config, err = LoadConfig(filepath.Join(filepath.Dir(path), "missing.yaml"))
+	if err != nil || len(config.Formats) != 0 || len(config.Aliases) != 0 {
+		t.Errorf("missing config = %+v, %v", config, err)
+	}
+
+	invalid, remove := writeConfig(t, "formats: [oneline]\n")
+	defer remove()
+	if _, err := LoadConfig(invalid); err == nil || !strings.HasPrefix(err.Error(), "Invalid config file") {
+		t.Errorf("error = %v, want an invalid config file", err)
+	}
+}
+
+func TestConfigFormatProfile(t *testing.T) {
+	path, remove := writeConfig(t, testConfig)
+	defer remove()
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the config overrides the built-in profiles
+	tests := []struct {
+		name   string
+		format string
+	}{
+		{"oneline", "{{ .Message }}"},
+		{"default", "{{ .Level }} {{ .Message }}"},
+		{"verbose", VerboseFormat},
+		{"raw", RawFormat},
+	}
+	for _, test := range tests {
+		profile, err := config.FormatProfile(test.name)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if profile.Format != test.format {
+			t.Errorf("%s: format = %q, want %q", test.name, profile.Format, test.format)
+		}
+	}
+
+	// files are relative to the config file
+	profile, err := config.FormatProfile("detailed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(filepath.Dir(path), "partials.tmpl"),
+		"/etc/cwlogs/shared.tmpl",
+		filepath.Join(os.Getenv("HOME"), "cwlogs.tmpl"),
+	}
+	if fmt.Sprint(profile.Files) != fmt.Sprint(want) {
+		t.Errorf("files = %v, want %v", profile.Files, want)
+	}
+	if config.Formats["detailed"].Files[0] != "partials.tmpl" {
+		t.Errorf("looking up a profile changed the config: %v", config.Formats["detailed"].Files)
+	}
+
+	if got := fmt.Sprint(config.FormatProfileNames()); got != "[default detailed oneline raw verbose]" {
+		t.Errorf("names = %s", got)
+	}
+	_, err = config.FormatProfile("missing")
+	if err == nil || err.Error() != "Unknown format profile 'missing', expected one of default, detailed, oneline, raw, verbose" {
+		t.Errorf("error = %v", err)
+	}
+
+	// without a config file only the built-in profiles exist
+	empty := &Config{}
+	if got := fmt.Sprint(empty.FormatProfileNames()); got != "[default raw verbose]" {
+		t.Errorf("built-in names = %s", got)
+	}
+	if _, err := empty.FormatProfile("oneline"); err == nil {
+		t.Error("expected an error for a profile of another config")
+	}
+}
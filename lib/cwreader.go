@@ -5,12 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
-	"github.com/hashicorp/golang-lru"
 )
 
 const (
@@ -19,135 +18,352 @@ const (
 )
 
 var (
-	// MaxStreams is the maximum number of streams you can give to a filter call
-	MaxStreams = 100
+	// MaxStreams is the default maximum number of streams used by
+	// NewCloudwatchLogsReader.
+	//
+	// Deprecated: use WithMaxStreams or ReaderConfig.MaxStreams instead.
+	MaxStreams = DefaultMaxStreams
 )
 
 // CloudwatchLogsReader is responsible for fetching logs for a particular log
 // group
 type CloudwatchLogsReader struct {
 	logGroupName string
-	svc          *cloudwatchlogs.CloudWatchLogs
-	eventCache   *lru.Cache
+	svc          *ThrottledClient
 	start        time.Time
 	end          time.Time
+	mu           sync.Mutex
 	error        error
 	streamPrefix string
+	pattern      string
+	maxStreams   int
+	pollInterval time.Duration
+	dedupWindow  time.Duration
+	parallelism  int
+	resume       *CheckpointPosition
+	parser       *EventParser
+
+	discoveryInterval time.Duration
+	idleStreamTimeout time.Duration
+	onStreamsChanged  func(group string, joined []string, left []string)
 }
 
-// Set the maximum number of streams for describe/filter calls
+// SetMaxStreams sets the default maximum number of streams for describe/filter
+// calls.
+//
+// Deprecated: use WithMaxStreams or ReaderConfig.MaxStreams instead.
 func SetMaxStreams(max int) {
 	MaxStreams = max
 }
 
 // NewCloudwatchLogsReader takes a group and optionally a stream prefix, start and
 // end time, and returns a reader for any logs that match those parameters.
-func NewCloudwatchLogsReader(group string, streamPrefix string, start time.Time, end time.Time) (*CloudwatchLogsReader, error) {
-	session := session.New()
-	svc := cloudwatchlogs.New(session, &aws.Config{MaxRetries: aws.Int(10)})
+// Options are applied on top of the defaults.
+func NewCloudwatchLogsReader(group string, streamPrefix string, start time.Time, end time.Time, options ...ReaderOption) (*CloudwatchLogsReader, error) {
+	config := ReaderConfig{
+		Group:        group,
+		StreamPrefix: streamPrefix,
+		Start:        start,
+		End:          end,
+		MaxStreams:   MaxStreams,
+	}
+	for _, option := range options {
+		option(&config)
+	}
+	return NewReader(config)
+}
+
+// NewCloudwatchLogsReaderWithClient is like NewCloudwatchLogsReader but uses
+// the given client instead of creating one from the default AWS session.
+func NewCloudwatchLogsReaderWithClient(svc CloudwatchLogsClient, group string, streamPrefix string, start time.Time, end time.Time) (*CloudwatchLogsReader, error) {
+	return NewCloudwatchLogsReader(group, streamPrefix, start, end, WithClient(svc))
+}
 
-	if _, err := getLogGroup(svc, group); err != nil {
+// NewReader returns a reader configured by config
+func NewReader(config ReaderConfig) (*CloudwatchLogsReader, error) {
+	config = config.withDefaults()
+
+	// report pattern syntax errors before making any call
+	if _, err := ParseFilterPattern(config.FilterPattern); err != nil {
 		return nil, err
 	}
 
-	cache, err := lru.New(MaxEventsPerCall)
+	svc, err := config.client()
 	if err != nil {
 		return nil, err
 	}
 
+	if _, err := getLogGroup(config.Context, svc, config.Group); err != nil {
+		return nil, err
+	}
+
 	reader := &CloudwatchLogsReader{
-		logGroupName: group,
+		logGroupName: config.Group,
 		svc:          svc,
-		eventCache:   cache,
-		start:        start,
-		end:          end,
-		streamPrefix: streamPrefix,
+		start:        config.Start,
+		end:          config.End,
+		streamPrefix: config.StreamPrefix,
+		pattern:      config.FilterPattern,
+		maxStreams:   config.MaxStreams,
+		pollInterval: config.PollInterval,
+		dedupWindow:  config.DedupWindow,
+		parallelism:  config.Parallelism,
+		resume:       config.Resume,
+		parser:       config.Parser,
+
+		discoveryInterval: config.DiscoveryInterval,
+		idleStreamTimeout: config.IdleStreamTimeout,
+		onStreamsChanged:  config.OnStreamsChanged,
+	}
+
+	if reader.resume != nil {
+		reader.start = time.Unix(0, reader.resume.Timestamp*int64(time.Millisecond)).Add(-reader.dedupWindow)
 	}
 
 	return reader, nil
 }
 
 // ListStreams returns any log streams that match the params given in the
-// reader's constructor.  Will return at most `MaxStreams` streams
+// reader's constructor.  Will return at most the configured maximum number
+// of streams
 func (c *CloudwatchLogsReader) ListStreams() ([]*cloudwatchlogs.LogStream, error) {
-	return c.getLogStreams()
+	return c.ListStreamsWithContext(context.Background())
+}
+
+// ListStreamsWithContext is like ListStreams, giving up when ctx is done
+func (c *CloudwatchLogsReader) ListStreamsWithContext(ctx context.Context) ([]*cloudwatchlogs.LogStream, error) {
+	return c.getLogStreams(ctx, c.end)
 }
 
 // StreamEvents returns a channel where you can read events matching the params
 // given in the readers constructor.  The channel will be closed once
-// all events are read or an error occurs.  You can check for errors
-// after the channel is closed by calling Error()
+// all events are read, an error occurs or ctx is done.  You can check for
+// errors after the channel is closed by calling Error().  Cancel ctx when
+// you stop reading before the channel is closed.
+//
+// Events returns an iterator which doesn't require a separate error check.
 func (c *CloudwatchLogsReader) StreamEvents(ctx context.Context, follow bool) <-chan Event {
 	eventChan := make(chan Event)
-	go c.pumpEvents(ctx, eventChan, follow)
+	go func() {
+		err := c.pumpEvents(ctx, follow, func(event Event) error {
+			select {
+			case eventChan <- event:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		c.mu.Lock()
+		c.error = err
+		c.mu.Unlock()
+		close(eventChan)
+	}()
 
 	return eventChan
 }
 
-func (c *CloudwatchLogsReader) pumpEvents(ctx context.Context, eventChan chan<- Event, follow bool) {
+// Events returns an iterator over the events matching the params given in the
+// reader's constructor.  The iterator stops when all events are read (never
+// when following), an error occurs or ctx is done.
+func (c *CloudwatchLogsReader) Events(ctx context.Context, follow bool) *EventIterator {
+	return newEventIterator(ctx, func(ctx context.Context, emit func(Event) error) error {
+		return c.pumpEvents(ctx, follow, emit)
+	})
+}
+
+// pumpEvents passes the events to emit until they are exhausted, an error
+// occurs or emit fails
+func (c *CloudwatchLogsReader) pumpEvents(ctx context.Context, follow bool, emit func(Event) error) error {
 	startTime := c.start.Unix() * 1e3
+	if c.resume != nil {
+		// go back a window to pick up the events that arrived late while
+		// stopped, the checkpoint knows which ones were processed
+		startTime = c.resume.Timestamp - int64(c.dedupWindow/time.Millisecond)
+		emit = skipResumed(c.resume, emit)
+	}
+
 	params := &cloudwatchlogs.FilterLogEventsInput{
 		Interleaved:  aws.Bool(true),
 		LogGroupName: aws.String(c.logGroupName),
 		StartTime:    aws.Int64(startTime),
 	}
 
-	if !follow && c.end.IsZero() {
-		c.end = time.Now()
+	if c.pattern != "" {
+		params.FilterPattern = aws.String(c.pattern)
+	}
+
+	end := c.end
+	if !follow && end.IsZero() {
+		end = time.Now()
 	}
 
-	if !c.end.IsZero() {
-		endTime := c.end.Unix() * 1e3
+	if !end.IsZero() {
+		endTime := end.Unix() * 1e3
 		params.EndTime = aws.Int64(endTime)
 	}
 
-	if c.streamPrefix != "" {
-		streams, err := c.getLogStreams()
+	// When following a stream prefix, streams created after startup are
+	// discovered periodically and quiet ones are dropped
+	var followed *streamSet
+	var nextDiscovery time.Time
+
+	if c.streamPrefix != "" && follow {
+		// no matching streams is fine, they may be created later
+		var streams []*cloudwatchlogs.LogStream
+		for attempt := 0; ; attempt++ {
+			var err error
+			if streams, err = c.findLogStreams(ctx, end); err == nil {
+				break
+			}
+			if IsRetryable(err) {
+				if c.waitRetry(ctx, "DescribeLogStreams", err, attempt) {
+					continue
+				}
+				err = ctx.Err()
+			}
+			return err
+		}
+		params.LogStreamNames = streamsToNames(streams)
+		followed = newStreamSet(streams)
+		nextDiscovery = time.Now().Add(c.discoveryInterval)
+	} else if c.streamPrefix != "" {
+		streams, err := c.getLogStreams(ctx, end)
 		if err != nil {
-			c.error = err
-			close(eventChan)
-			return
+			return err
 		}
 		params.LogStreamNames = streamsToNames(streams)
 	}
 
+	if !follow && c.parallelism > 1 {
+		return c.pumpShards(ctx, params, emit)
+	}
+
+	seen := newEventDeduper(c.dedupWindow)
+	failures := 0
+
 	for {
+		if followed != nil && !time.Now().Before(nextDiscovery) {
+			if c.refreshStreams(ctx, followed, time.Now()) {
+				// the pagination token is tied to the stream names, restart
+				// from the watermark
+				params.LogStreamNames = followed.names()
+				c.restartFromWatermark(params, seen)
+			}
+			nextDiscovery = time.Now().Add(c.discoveryInterval)
+		}
+
+		if followed != nil && len(params.LogStreamNames) == 0 {
+			// no stream is active, wait for the next discovery
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.pollInterval):
+			}
+			continue
+		}
+
 		o, err := c.svc.FilterLogEventsWithContext(ctx, params)
 		if err != nil {
-			c.error = err
-			close(eventChan)
-			return
+			// throttling and transient errors are never fatal when following,
+			// keep backing off until the call goes through
+			if follow && IsRetryable(err) {
+				if c.waitRetry(ctx, "FilterLogEvents", err, failures) {
+					failures++
+					continue
+				}
+				err = ctx.Err()
+			}
+			return err
 		}
+		failures = 0
 
 		for _, event := range o.Events {
-			if _, ok := c.eventCache.Peek(*event.EventId); !ok {
-				eventChan <- NewEvent(*event, c.logGroupName)
-				c.eventCache.Add(*event.EventId, nil)
+			if followed != nil {
+				followed.observe(*event.LogStreamName, *event.Timestamp)
+			}
+			if seen.add(*event.EventId, *event.Timestamp) {
+				if err := emit(c.parser.Event(*event, c.logGroupName)); err != nil {
+					return err
+				}
 			}
 		}
+		seen.prune()
 
 		if o.NextToken != nil {
 			params.NextToken = o.NextToken
 		} else if !follow {
-			close(eventChan)
-			return
+			return nil
+		} else {
+			// all events up to now were read, poll again from the watermark
+			c.restartFromWatermark(params, seen)
 		}
 
-		time.Sleep(100 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.pollInterval):
+		}
 	}
 }
 
-// Error returns an error if one occurred while streaming events.
+// skipResumed wraps emit to drop the events a checkpoint says were already
+// processed, along with the events older than it knows about
+func skipResumed(position *CheckpointPosition, emit func(Event) error) func(Event) error {
+	processed := make(map[string]bool, len(position.IDs)+len(position.Earlier))
+	for _, id := range position.IDs {
+		processed[id] = true
+	}
+	for id := range position.Earlier {
+		processed[id] = true
+	}
+
+	return func(event Event) error {
+		timestamp := event.CreationTime.UnixNano() / int64(time.Millisecond)
+		if timestamp < position.Since || processed[event.ID] {
+			return nil
+		}
+		return emit(event)
+	}
+}
+
+// waitRetry backs off before retrying a call that failed with a retryable
+// error.  It returns false if ctx is done first.
+func (c *CloudwatchLogsReader) waitRetry(ctx context.Context, operation string, err error, attempt int) bool {
+	delay := c.svc.Backoff(attempt)
+	c.svc.NotifyBackoff(operation, err, delay)
+
+	select {
+	case <-time.After(delay):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// restartFromWatermark points a filter call at the watermark of seen events,
+// dropping the pagination token.  Events between the floor and the watermark
+// are returned again and skipped by the deduper.
+func (c *CloudwatchLogsReader) restartFromWatermark(params *cloudwatchlogs.FilterLogEventsInput, seen *eventDeduper) {
+	params.NextToken = nil
+	if floor, ok := seen.floor(); ok && floor > *params.StartTime {
+		params.StartTime = aws.Int64(floor)
+	}
+}
+
+// Error returns an error if one occurred while streaming events.  It is only
+// meaningful once the channel returned by StreamEvents is closed.
 func (c *CloudwatchLogsReader) Error() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.error
 }
 
-func getLogGroup(svc *cloudwatchlogs.CloudWatchLogs, name string) (*cloudwatchlogs.LogGroup, error) {
+func getLogGroup(ctx context.Context, svc CloudwatchLogsClient, name string) (*cloudwatchlogs.LogGroup, error) {
 	describeLogGroupsInput := &cloudwatchlogs.DescribeLogGroupsInput{
 		LogGroupNamePrefix: aws.String(name),
 	}
 
-	resp, err := svc.DescribeLogGroups(describeLogGroupsInput)
+	resp, err := svc.DescribeLogGroupsWithContext(ctx, describeLogGroupsInput)
 	if err != nil {
 		return nil, err
 	}
@@ -171,7 +387,24 @@ func getLogGroup(svc *cloudwatchlogs.CloudWatchLogs, name string) (*cloudwatchlo
 	return resp.LogGroups[0], nil
 }
 
-func (c *CloudwatchLogsReader) getLogStreams() ([]*cloudwatchlogs.LogStream, error) {
+func (c *CloudwatchLogsReader) getLogStreams(ctx context.Context, end time.Time) ([]*cloudwatchlogs.LogStream, error) {
+	streams, err := c.findLogStreams(ctx, end)
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) == 0 {
+		if c.streamPrefix != "" {
+			return nil, fmt.Errorf("No log streams found matching task prefix '%s' in your time window.  Consider adjusting your time window with --since and/or --until", c.streamPrefix)
+		} else {
+			return nil, errors.New("No log streams found in your time window.  Consider adjusting your time window with --since and/or --until")
+		}
+	}
+	return streams, nil
+}
+
+// findLogStreams returns the streams active between the start time and end, or
+// now if end is zero
+func (c *CloudwatchLogsReader) findLogStreams(ctx context.Context, end time.Time) ([]*cloudwatchlogs.LogStream, error) {
 	params := &cloudwatchlogs.DescribeLogStreamsInput{
 		LogGroupName: aws.String(c.logGroupName),
 	}
@@ -189,15 +422,15 @@ func (c *CloudwatchLogsReader) getLogStreams() ([]*cloudwatchlogs.LogStream, err
 
 	startTimestamp := c.start.Unix() * 1e3
 	endTimestamp := time.Now().Unix() * 1e3
-	if !c.end.IsZero() {
-		endTimestamp = c.end.Unix() * 1e3
+	if !end.IsZero() {
+		endTimestamp = end.Unix() * 1e3
 	}
 
 	streams := []*cloudwatchlogs.LogStream{}
-	if err := c.svc.DescribeLogStreamsPages(params, func(o *cloudwatchlogs.DescribeLogStreamsOutput, lastPage bool) bool {
+	if err := c.svc.DescribeLogStreamsPagesWithContext(ctx, params, func(o *cloudwatchlogs.DescribeLogStreamsOutput, lastPage bool) bool {
 		pastWindow := false
 		for _, s := range o.LogStreams {
-			if len(streams) >= MaxStreams {
+			if len(streams) >= c.maxStreams {
 				return false
 			}
 			if s.LastEventTimestamp == nil {
@@ -238,13 +471,6 @@ func (c *CloudwatchLogsReader) getLogStreams() ([]*cloudwatchlogs.LogStream, err
 		return nil, err
 	}
 	sort.Sort(sort.Reverse(ByLastEvent(streams)))
-	if len(streams) == 0 {
-		if c.streamPrefix != "" {
-			return nil, fmt.Errorf("No log streams found matching task prefix '%s' in your time window.  Consider adjusting your time window with --since and/or --until", c.streamPrefix)
-		} else {
-			return nil, errors.New("No log streams found in your time window.  Consider adjusting your time window with --since and/or --until")
-		}
-	}
 	return streams, nil
 }
 
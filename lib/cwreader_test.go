@@ -0,0 +1,374 @@
+package lib_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/segmentio/cwlogs/lib"
+	"github.com/segmentio/cwlogs/lib/fake"
+)
+
+var base = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func at(seconds int) time.Time {
+	return base.Add(time.Duration(seconds) * time.Second)
+}
+
+// fastThrottle keeps retries of injected errors quick
+var fastThrottle = lib.ThrottleConfig{
+	MaxRetries: 3,
+	MinBackoff: time.Millisecond,
+	MaxBackoff: 5 * time.Millisecond,
+}
+
+func newReader(t *testing.T, client *fake.Client, prefix string, start time.Time, end time.Time, options ...lib.ReaderOption) *lib.CloudwatchLogsReader {
+	options = append([]lib.ReaderOption{
+		lib.WithClient(client),
+		lib.WithThrottle(fastThrottle),
+		lib.WithPollInterval(time.Millisecond),
+	}, options...)
+	reader, err := lib.NewCloudwatchLogsReader("group", prefix, start, end, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+// readAll returns the messages of every event of it
+func readAll(it *lib.EventIterator) ([]string, error) {
+	defer it.Close()
+	messages := []string{}
+	for {
+		event, err := it.Next(context.Background())
+		if err == io.EOF {
+			return messages, nil
+		}
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, event.Message)
+	}
+}
+
+func TestReaderPages(t *testing.T) {
+	client := fake.New()
+	client.PageSize = 7
+	want := []string{}
+	for i := 0; i < 60; i++ {
+		message := fmt.Sprintf("m%02d", i)
+		client.AddEvent("group", fmt.Sprintf("stream-%d", i%3), at(1+i*10), message)
+		want = append(want, message)
+	}
+
+	for _, parallelism := range []int{1, 4} {
+		reader := newReader(t, client, "", base, at(3600), lib.WithParallelism(parallelism))
+		got, err := readAll(reader.Events(context.Background(), false))
+		if err != nil {
+			t.Errorf("parallelism %d: %s", parallelism, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("parallelism %d: events = %v, want %v", parallelism, got, want)
+		}
+	}
+}
+
+func TestReaderWindow(t *testing.T) {
+	client := fake.New()
+	for i := 0; i < 10; i++ {
+		client.AddEvent("group", "stream", at(i*60), fmt.Sprintf("m%d", i))
+	}
+
+	reader := newReader(t, client, "", at(120), at(300))
+	got, err := readAll(reader.Events(context.Background(), false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[m2 m3 m4 m5]"; fmt.Sprint(got) != want {
+		t.Errorf("events = %v, want %s", got, want)
+	}
+}
+
+func TestReaderStreamPrefix(t *testing.T) {
+	client := fake.New()
+	client.AddEvent("group", "web-1", at(1), "web 1")
+	client.AddEvent("group", "worker-1", at(2), "worker 1")
+	client.AddEvent("group", "web-2", at(3), "web 2")
+	// streams last active before the window are ignored
+	client.AddEvent("group", "web-old", base.Add(-time.Hour), "old")
+
+	reader := newReader(t, client, "web-", base, at(3600))
+	got, err := readAll(reader.Events(context.Background(), false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[web 1 web 2]"; fmt.Sprint(got) != want {
+		t.Errorf("events = %v, want %s", got, want)
+	}
+
+	reader = newReader(t, client, "api-", base, at(3600))
+	if _, err := readAll(reader.Events(context.Background(), false)); err == nil {
+		t.Error("expected an error when no stream matches the prefix")
+	}
+}
+
+func TestReaderMaxStreams(t *testing.T) {
+	client := fake.New()
+	client.PageSize = 2
+	for i := 0; i < 5; i++ {
+		client.AddEvent("group", fmt.Sprintf("task-%d", i), at(1+i), fmt.Sprintf("m%d", i))
+	}
+
+	reader := newReader(t, client, "task-", base, at(3600), lib.WithMaxStreams(3))
+	streams, err := reader.ListStreams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 3 {
+		t.Errorf("%d streams, want 3", len(streams))
+	}
+
+	got, err := readAll(reader.Events(context.Background(), false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[m0 m1 m2]"; fmt.Sprint(got) != want {
+		t.Errorf("events = %v, want %s", got, want)
+	}
+
+	// without a prefix the most recently active streams are listed
+	reader = newReader(t, client, "", base, at(3600), lib.WithMaxStreams(2))
+	streams, err = reader.ListStreams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, s := range streams {
+		names = append(names, *s.LogStreamName)
+	}
+	if want := "[task-4 task-3]"; fmt.Sprint(names) != want {
+		t.Errorf("streams = %v, want %s", names, want)
+	}
+}
+
+func TestReaderMissingGroup(t *testing.T) {
+	client := fake.New()
+	client.AddLogGroup("group-a")
+	client.AddLogGroup("group-b")
+
+	_, err := lib.NewCloudwatchLogsReader("group", "", base, at(60), lib.WithClient(client))
+	if err == nil {
+		t.Fatal("expected an error for a missing group")
+	}
+}
+
+func TestReaderRetries(t *testing.T) {
+	client := fake.New()
+	client.PageSize = 2
+	for i := 0; i < 5; i++ {
+		client.AddEvent("group", "task-1", at(1+i), fmt.Sprintf("m%d", i))
+	}
+	throttled := awserr.New("ThrottlingException", "Rate exceeded", nil)
+	client.FailNext("DescribeLogStreams", throttled, 2)
+	client.FailNext("FilterLogEvents", throttled, 3)
+
+	backoffs := 0
+	throttle := fastThrottle
+	throttle.OnBackoff = func(operation string, err error, delay time.Duration) { backoffs++ }
+	reader := newReader(t, client, "task-", base, at(3600), lib.WithThrottle(throttle))
+	got, err := readAll(reader.Events(context.Background(), false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[m0 m1 m2 m3 m4]"; fmt.Sprint(got) != want {
+		t.Errorf("events = %v, want %s", got, want)
+	}
+	if backoffs != 5 {
+		t.Errorf("%d backoffs, want 5", backoffs)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	client := fake.New()
+	client.AddEvent("group", "stream", at(1), "m")
+
+	denied := awserr.New("AccessDeniedException", "Not authorized", nil)
+	client.FailNext("FilterLogEvents", denied, 1)
+	reader := newReader(t, client, "", base, at(60))
+	if _, err := readAll(reader.Events(context.Background(), false)); err != denied {
+		t.Errorf("error = %v, want the injected error", err)
+	}
+
+	// retries are given up after MaxRetries
+	throttled := awserr.New("ThrottlingException", "Rate exceeded", nil)
+	client.FailNext("FilterLogEvents", throttled, fastThrottle.MaxRetries+1)
+	if _, err := readAll(reader.Events(context.Background(), false)); err != throttled {
+		t.Errorf("error = %v, want the injected throttling error", err)
+	}
+}
+
+// nextMessages reads n events from a following iterator, failing the test if
+// they don't come in time
+func nextMessages(t *testing.T, it *lib.EventIterator, n int) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	messages := []string{}
+	for len(messages) < n {
+		event, err := it.Next(ctx)
+		if err != nil {
+			t.Fatalf("after %v: %s", messages, err)
+		}
+		messages = append(messages, event.Message)
+	}
+	return messages
+}
+
+func TestReaderFollowPages(t *testing.T) {
+	client := fake.New()
+	client.PageSize = 3
+	want := []string{}
+	add := func(i int) {
+		// several events share each second to split them across pages
+		message := fmt.Sprintf("m%02d", i)
+		client.AddEvent("group", fmt.Sprintf("stream-%d", i%3), at(1+i/4), message)
+		want = append(want, message)
+	}
+	for i := 0; i < 20; i++ {
+		add(i)
+	}
+
+	reader := newReader(t, client, "", base, time.Time{})
+	it := reader.Events(context.Background(), true)
+	defer it.Close()
+
+	got := nextMessages(t, it, 20)
+	for i := 20; i < 40; i++ {
+		add(i)
+	}
+	got = append(got, nextMessages(t, it, 20)...)
+
+	// a last event shows that nothing was returned twice in between
+	add(40)
+	got = append(got, nextMessages(t, it, 1)...)
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestReaderFollowLateEvents(t *testing.T) {
+	client := fake.New()
+	for i := 0; i < 5; i++ {
+		client.AddEvent("group", "stream", at(10*i), fmt.Sprintf("m%d", i))
+	}
+
+	reader := newReader(t, client, "", base, time.Time{}, lib.WithDedupWindow(15*time.Second))
+	it := reader.Events(context.Background(), true)
+	defer it.Close()
+	nextMessages(t, it, 5)
+
+	// the newest event is at 40s, events ingested late within the window are
+	// picked up, in any stream
+	client.AddEvent("group", "stream", at(37), "late")
+	client.AddEvent("group", "other", at(26), "later")
+	client.AddEvent("group", "stream", at(41), "next")
+
+	// a poll can come between the events being added
+	got := nextMessages(t, it, 3)
+	sort.Strings(got)
+	if want := "[late later next]"; fmt.Sprint(got) != want {
+		t.Errorf("events = %v, want %s", got, want)
+	}
+
+	client.AddEvent("group", "stream", at(42), "last")
+	if got := nextMessages(t, it, 1); got[0] != "last" {
+		t.Errorf("event = %s, want last", got[0])
+	}
+}
+
+func TestReaderResume(t *testing.T) {
+	client := fake.New()
+	client.AddEvent("group", "stream", at(10), "m1")
+	client.AddEvent("group", "stream", at(12), "m2")
+
+	checkpoint := lib.NewCheckpoint(5 * time.Second)
+	reader := newReader(t, client, "", base, at(3600))
+	it := reader.Events(context.Background(), false)
+	for {
+		event, err := it.Next(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkpoint.Observe(event)
+	}
+	it.Close()
+
+	// ingested while stopped, within the window and before it
+	client.AddEvent("group", "stream", at(9), "late")
+	client.AddEvent("group", "stream", at(1), "too late")
+	client.AddEvent("group", "stream", at(14), "m3")
+
+	position, err := checkpoint.Resume("group", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader = newReader(t, client, "", base, at(3600), lib.WithResume(position), lib.WithDedupWindow(5*time.Second))
+	got, err := readAll(reader.Events(context.Background(), false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[late m3]"; fmt.Sprint(got) != want {
+		t.Errorf("events after resuming = %v, want %s", got, want)
+	}
+}
+
+func TestReaderFollowDiscoveryErrors(t *testing.T) {
+	client := fake.New()
+	now := time.Now()
+	client.AddEvent("group", "web-1", now, "web 1")
+
+	discoveryErrors := make(chan error, 10)
+	throttle := fastThrottle
+	throttle.OnBackoff = func(operation string, err error, delay time.Duration) {
+		if operation == "DescribeLogStreams" && delay == 10*time.Millisecond {
+			discoveryErrors <- err
+		}
+	}
+	reader := newReader(t, client, "web-", now.Add(-time.Minute), time.Time{},
+		lib.WithThrottle(throttle),
+		lib.WithDiscoveryInterval(10*time.Millisecond),
+	)
+	it := reader.Events(context.Background(), true)
+	defer it.Close()
+
+	if got := nextMessages(t, it, 1); fmt.Sprint(got) != "[web 1]" {
+		t.Fatalf("events = %v, want [web 1]", got)
+	}
+
+	// a failed discovery is reported and the current streams are still
+	// followed
+	denied := awserr.New("AccessDeniedException", "Not authorized", nil)
+	client.FailNext("DescribeLogStreams", denied, 1)
+	select {
+	case err := <-discoveryErrors:
+		if err != denied {
+			t.Errorf("discovery error = %v, want %v", err, denied)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("discovery error not reported")
+	}
+
+	client.AddEvent("group", "web-1", now.Add(time.Second), "web 1 again")
+	client.AddEvent("group", "web-2", now.Add(2*time.Second), "web 2")
+	if got := nextMessages(t, it, 2); fmt.Sprint(got) != "[web 1 again web 2]" {
+		t.Errorf("events after the failed discovery = %v, want [web 1 again web 2]", got)
+	}
+}
@@ -0,0 +1,64 @@
+package lib
+
+import "time"
+
+// eventDeduper drops events a reader has already emitted.  Following readers
+// restart their filter calls from the watermark, the newest timestamp seen
+// minus an overlap window, so only the IDs of events at or after that point
+// can come back and need to be remembered.  Events showing up later than the
+// window, with a timestamp before the floor, are never returned again by the
+// restarted calls and are missed.
+type eventDeduper struct {
+	overlap   int64
+	watermark int64
+	seen      map[string]int64
+}
+
+func newEventDeduper(overlap time.Duration) *eventDeduper {
+	return &eventDeduper{
+		overlap:   int64(overlap / time.Millisecond),
+		watermark: -1,
+		seen:      map[string]int64{},
+	}
+}
+
+// add records an event and returns false if it was seen before.  timestamp
+// is in milliseconds, like AWS timestamps.
+func (d *eventDeduper) add(id string, timestamp int64) bool {
+	if _, ok := d.seen[id]; ok {
+		return false
+	}
+
+	if timestamp > d.watermark {
+		d.watermark = timestamp
+	}
+
+	// events before the floor can't be returned again by a restarted call,
+	// no need to remember them
+	if floor, _ := d.floor(); timestamp >= floor {
+		d.seen[id] = timestamp
+	}
+	return true
+}
+
+// floor returns the timestamp a filter call should restart from so that no
+// event after the watermark is missed, and false if nothing was seen yet
+func (d *eventDeduper) floor() (int64, bool) {
+	if d.watermark < 0 {
+		return 0, false
+	}
+	return d.watermark - d.overlap, true
+}
+
+// prune forgets the IDs of events before the floor
+func (d *eventDeduper) prune() {
+	floor, ok := d.floor()
+	if !ok {
+		return
+	}
+	for id, timestamp := range d.seen {
+		if timestamp < floor {
+			delete(d.seen, id)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventDeduperAdd(t *testing.T) {
+	d := newEventDeduper(5 * time.Second)
+	if _, ok := d.floor(); ok {
+		t.Error("floor set before any event was seen")
+	}
+
+	steps := []struct {
+		id        string
+		timestamp int64
+		want      bool
+	}{
+		{"a", 10000, true},
+		{"b", 12000, true},
+		{"a", 10000, false},
+		// out of order events within the window are new
+		{"c", 9000, true},
+		{"c", 9000, false},
+		{"d", 20000, true},
+		{"b", 12000, false},
+	}
+	for _, step := range steps {
+		if got := d.add(step.id, step.timestamp); got != step.want {
+			t.Errorf("add(%s, %d) = %v, want %v", step.id, step.timestamp, got, step.want)
+		}
+	}
+
+	if floor, ok := d.floor(); !ok || floor != 15000 {
+		t.Errorf("floor = %d, %v, want 15000", floor, ok)
+	}
+}
+
+func TestEventDeduperPrune(t *testing.T) {
+	d := newEventDeduper(5 * time.Second)
+	d.add("a", 10000)
+	d.add("b", 14000)
+	d.add("c", 20000)
+	d.prune()
+
+	// a and b are before the floor, they can't be returned again
+	if _, ok := d.seen["a"]; ok {
+		t.Error("a is remembered after prune")
+	}
+	if _, ok := d.seen["b"]; ok {
+		t.Error("b is remembered after prune")
+	}
+	if d.add("c", 20000) {
+		t.Error("c is new after prune")
+	}
+
+	// events before the floor aren't remembered at all
+	d.add("old", 1000)
+	if _, ok := d.seen["old"]; ok {
+		t.Error("event before the floor is remembered")
+	}
+
+	// the watermark never moves back
+	d.add("e", 16000)
+	if floor, _ := d.floor(); floor != 15000 {
+		t.Errorf("floor = %d, want 15000", floor)
+	}
+}
@@ -28,31 +28,38 @@ type Event struct {
 	ID           string
 	IngestTime   time.Time
 	CreationTime time.Time
-}
 
-// NewEvent takes a cloudwatch log event and returns an Event
-func NewEvent(cwEvent cloudwatchlogs.FilteredLogEvent, group string) Event {
-	var ecsLogsEvent ecslogs.Event
-	if err := json.Unmarshal([]byte(*cwEvent.Message), &ecsLogsEvent); err != nil {
-		ecsLogsEvent = ecslogs.MakeEvent(ecslogs.INFO, *cwEvent.Message)
-	}
+	// Size is the length in bytes of the message as stored in CloudWatch
+	Size int `json:"-"`
 
-	// If time was not found use AWS Timestamp
-	if ecsLogsEvent.Time.IsZero() {
-		ecsLogsEvent.Time = ParseAWSTimestamp(cwEvent.Timestamp)
-	}
+	// Joined lists the events a Joiner appended to this one, in order
+	Joined []JoinedEvent `json:"-"`
+}
 
-	return Event{
-		Event:        ecsLogsEvent,
-		Stream:       *cwEvent.LogStreamName,
-		Group:        group,
-		ID:           *cwEvent.EventId,
-		IngestTime:   ParseAWSTimestamp(cwEvent.IngestionTime),
-		CreationTime: ParseAWSTimestamp(cwEvent.Timestamp),
-	}
+// JoinedEvent identifies an event joined to the record of another one
+type JoinedEvent struct {
+	ID           string
+	CreationTime time.Time
+}
+
+// NewEvent takes a cloudwatch log event and returns an Event, decoding its
+// message with the ecs-logs parser
+func NewEvent(cwEvent cloudwatchlogs.FilteredLogEvent, group string) Event {
+	return ecsLogsEvents.Event(cwEvent, group)
+}
 
+// NewEventFromLine takes a log line read outside of CloudWatch, such as the
+// output of a local service, and returns an Event decoded by the ecs-logs
+// parser.  The arrival time is used when the line has no time of its own, and
+// source takes the place of the stream name.
+func NewEventFromLine(line string, arrival time.Time, source string) Event {
+	return ecsLogsEvents.EventFromLine(line, arrival, source)
 }
 
+// ecsLogsEvents decodes the messages of NewEvent and NewEventFromLine, falling
+// back to INFO events holding the raw message
+var ecsLogsEvents = &EventParser{fixed: parseECSLogs}
+
 // ParseAWSTimestamp takes the time stamp format given by AWS and returns an equivalent time.Time value
 func ParseAWSTimestamp(i *int64) time.Time {
 	if i == nil {
@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+func TestNewEvent(t *testing.T) {
+	timestamp := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		message string
+		level   ecslogs.Level
+		text    string
+		time    time.Time
+	}{
+		{
+			message: `{"level":"ERROR","time":"2017-03-01T11:59:58Z","message":"boom","data":{"id":1}}`,
+			level:   ecslogs.ERROR,
+			text:    "boom",
+			time:    timestamp.Add(-2 * time.Second),
+		},
+		{
+			message: `{"level":"WARN","message":"no time"}`,
+			level:   ecslogs.WARN,
+			text:    "no time",
+			time:    timestamp,
+		},
+		// any JSON object is decoded as ecs-logs, even without a message
+		{
+			message: `{"level":"ERROR","data":{"id":1}}`,
+			level:   ecslogs.ERROR,
+			text:    "",
+			time:    timestamp,
+		},
+		{
+			message: `{"msg":"zap"}`,
+			level:   ecslogs.NONE,
+			text:    "",
+			time:    timestamp,
+		},
+		{
+			message: `{"level":"ERROR"`,
+			level:   ecslogs.INFO,
+			text:    `{"level":"ERROR"`,
+			time:    timestamp,
+		},
+		{
+			message: "plain text",
+			level:   ecslogs.INFO,
+			text:    "plain text",
+			time:    timestamp,
+		},
+	}
+
+	for _, test := range tests {
+		millis := timestamp.UnixNano() / int64(time.Millisecond)
+		e := NewEvent(cloudwatchlogs.FilteredLogEvent{
+			EventId:       aws.String("id"),
+			IngestionTime: aws.Int64(millis),
+			LogStreamName: aws.String("stream"),
+			Message:       aws.String(test.message),
+			Timestamp:     aws.Int64(millis),
+		}, "group")
+		if e.Level != test.level || e.Message != test.text || !e.Time.Equal(test.time) {
+			t.Errorf("NewEvent(%q) = %s %q at %s, want %s %q at %s", test.message, e.Level, e.Message, e.Time, test.level, test.text, test.time)
+		}
+		if e.Group != "group" || e.Stream != "stream" || e.ID != "id" || e.Size != len(test.message) {
+			t.Errorf("NewEvent(%q) = %+v", test.message, e)
+		}
+
+		line := NewEventFromLine(test.message, timestamp, "local")
+		if line.Level != test.level || line.Message != test.text || !line.Time.Equal(test.time) || line.Stream != "local" {
+			t.Errorf("NewEventFromLine(%q) = %s %q at %s from %s", test.message, line.Level, line.Message, line.Time, line.Stream)
+		}
+	}
+}
+
+func TestEventJSON(t *testing.T) {
+	e := NewEventFromLine(`{"level":"INFO","message":"hello"}`, time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC), "local")
+	e.Joined = []JoinedEvent{{ID: "joined"}}
+
+	// bookkeeping fields are left out of the raw output and the format help
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(e.PrettyPrint()), &object); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"Size", "Joined"} {
+		if _, ok := object[key]; ok {
+			t.Errorf("%s in %s", key, e.PrettyPrint())
+		}
+	}
+	for _, f := range TemplateFields() {
+		if f.Path == ".Size" || strings.HasPrefix(f.Path, ".Joined") {
+			t.Errorf("%s listed in the template fields", f.Path)
+		}
+	}
+}
@@ -0,0 +1,580 @@
+// Package fake provides an in-memory CloudWatch Logs backend that satisfies
+// lib.CloudwatchLogsClient.  It is meant for testing code that embeds lib
+// without talking to AWS.
+package fake
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/segmentio/cwlogs/lib"
+)
+
+// Default page sizes used by the real service
+const (
+	DefaultDescribeLimit = 50
+	DefaultEventsLimit   = 10000
+
+	// MaxFilterStreams is the maximum number of stream names accepted by
+	// FilterLogEvents
+	MaxFilterStreams = 100
+)
+
+// Client is an in-memory CloudWatch Logs backend.  The zero value is not
+// usable, create one with New.  All methods are safe for concurrent use.
+type Client struct {
+	// PageSize, when non zero, caps the number of items returned by a single
+	// call.  It is useful to exercise pagination with small data sets.
+	PageSize int
+
+	mu     sync.Mutex
+	groups map[string]*logGroup
+	seq    int64
+	errors map[string][]error
+}
+
+type logGroup struct {
+	name         string
+	creationTime int64
+	streams      map[string]*logStream
+}
+
+type logStream struct {
+	name         string
+	creationTime int64
+	events       []*logEvent
+	storedBytes  int64
+}
+
+type logEvent struct {
+	id            string
+	seq           int64
+	stream        string
+	timestamp     int64
+	ingestionTime int64
+	message       string
+}
+
+var _ lib.CloudwatchLogsClient = (*Client)(nil)
+
+// New returns an empty backend
+func New() *Client {
+	return &Client{
+		groups: map[string]*logGroup{},
+		errors: map[string][]error{},
+	}
+}
+
+// FailNext makes the next times calls to operation (e.g. "FilterLogEvents")
+// return err, to simulate throttling or network failures
+func (c *Client) FailNext(operation string, err error, times int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := 0; i < times; i++ {
+		c.errors[operation] = append(c.errors[operation], err)
+	}
+}
+
+// injectedError pops the next error queued for operation, c.mu must be held
+func (c *Client) injectedError(operation string) error {
+	queue := c.errors[operation]
+	if len(queue) == 0 {
+		return nil
+	}
+	c.errors[operation] = queue[1:]
+	return queue[0]
+}
+
+// AddLogGroup creates a log group if it doesn't exist yet
+func (c *Client) AddLogGroup(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.group(name)
+}
+
+// AddLogStream creates a log stream (and its group) if it doesn't exist yet
+func (c *Client) AddLogStream(group string, stream string, creation time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stream(group, stream, millis(creation))
+}
+
+// AddEvent appends a log event to a stream, creating the group and stream as
+// needed, and returns the generated event ID.  The ingestion time is set to
+// the event timestamp.
+func (c *Client) AddEvent(group string, stream string, timestamp time.Time, message string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ts := millis(timestamp)
+	s := c.stream(group, stream, ts)
+
+	c.seq++
+	e := &logEvent{
+		id:            fmt.Sprintf("%056d", c.seq),
+		seq:           c.seq,
+		stream:        stream,
+		timestamp:     ts,
+		ingestionTime: ts,
+		message:       message,
+	}
+
+	// keep events ordered by timestamp, events with equal timestamps stay in
+	// insertion order
+	ix := sort.Search(len(s.events), func(i int) bool { return s.events[i].timestamp > ts })
+	s.events = append(s.events, nil)
+	copy(s.events[ix+1:], s.events[ix:])
+	s.events[ix] = e
+	s.storedBytes += int64(len(message))
+
+	return e.id
+}
+
+func (c *Client) group(name string) *logGroup {
+	g, ok := c.groups[name]
+	if !ok {
+		g = &logGroup{
+			name:         name,
+			creationTime: millis(time.Now()),
+			streams:      map[string]*logStream{},
+		}
+		c.groups[name] = g
+	}
+	return g
+}
+
+func (c *Client) stream(group string, name string, creation int64) *logStream {
+	g := c.group(group)
+	s, ok := g.streams[name]
+	if !ok {
+		s = &logStream{name: name, creationTime: creation}
+		g.streams[name] = s
+		if creation < g.creationTime {
+			g.creationTime = creation
+		}
+	}
+	return s
+}
+
+// DescribeLogGroupsWithContext lists log groups ordered by name
+func (c *Client) DescribeLogGroupsWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogGroupsInput, opts ...request.Option) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.injectedError("DescribeLogGroups"); err != nil {
+		return nil, err
+	}
+
+	prefix := aws.StringValue(input.LogGroupNamePrefix)
+	names := []string{}
+	for name := range c.groups {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	offset, err := parseOffsetToken(input.NextToken, "g")
+	if err != nil {
+		return nil, err
+	}
+	if offset > len(names) {
+		offset = len(names)
+	}
+	end := pageEnd(offset, len(names), c.limit(input.Limit, DefaultDescribeLimit))
+
+	out := &cloudwatchlogs.DescribeLogGroupsOutput{LogGroups: []*cloudwatchlogs.LogGroup{}}
+	for _, name := range names[offset:end] {
+		g := c.groups[name]
+		var stored int64
+		for _, s := range g.streams {
+			stored += s.storedBytes
+		}
+		out.LogGroups = append(out.LogGroups, &cloudwatchlogs.LogGroup{
+			Arn:          aws.String(fmt.Sprintf("arn:aws:logs:fake:000000000000:log-group:%s:*", name)),
+			CreationTime: aws.Int64(g.creationTime),
+			LogGroupName: aws.String(name),
+			StoredBytes:  aws.Int64(stored),
+		})
+	}
+	if end < len(names) {
+		out.NextToken = offsetToken("g", end)
+	}
+	return out, nil
+}
+
+// DescribeLogStreams lists the log streams of a group, ordered by name or by
+// last event time
+func (c *Client) DescribeLogStreams(input *cloudwatchlogs.DescribeLogStreamsInput) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.injectedError("DescribeLogStreams"); err != nil {
+		return nil, err
+	}
+
+	g, ok := c.groups[aws.StringValue(input.LogGroupName)]
+	if !ok {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log group does not exist.", nil)
+	}
+
+	prefix := aws.StringValue(input.LogStreamNamePrefix)
+	byTime := aws.StringValue(input.OrderBy) == cloudwatchlogs.OrderByLastEventTime
+	if byTime && prefix != "" {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "Cannot order by LastEventTime with a logStreamNamePrefix.", nil)
+	}
+
+	streams := []*logStream{}
+	for _, s := range g.streams {
+		if strings.HasPrefix(s.name, prefix) {
+			streams = append(streams, s)
+		}
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		if byTime {
+			if a, b := streams[i].lastEventTime(), streams[j].lastEventTime(); a != b {
+				return a < b
+			}
+		}
+		return streams[i].name < streams[j].name
+	})
+	if aws.BoolValue(input.Descending) {
+		for i, j := 0, len(streams)-1; i < j; i, j = i+1, j-1 {
+			streams[i], streams[j] = streams[j], streams[i]
+		}
+	}
+
+	offset, err := parseOffsetToken(input.NextToken, "s")
+	if err != nil {
+		return nil, err
+	}
+	if offset > len(streams) {
+		offset = len(streams)
+	}
+	end := pageEnd(offset, len(streams), c.limit(input.Limit, DefaultDescribeLimit))
+
+	out := &cloudwatchlogs.DescribeLogStreamsOutput{LogStreams: []*cloudwatchlogs.LogStream{}}
+	for _, s := range streams[offset:end] {
+		out.LogStreams = append(out.LogStreams, s.describe(g.name))
+	}
+	if end < len(streams) {
+		out.NextToken = offsetToken("s", end)
+	}
+	return out, nil
+}
+
+// DescribeLogStreamsPagesWithContext iterates over the pages of a
+// DescribeLogStreams operation, calling fn for each page until it returns
+// false.
+func (c *Client) DescribeLogStreamsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogStreamsInput, fn func(*cloudwatchlogs.DescribeLogStreamsOutput, bool) bool, opts ...request.Option) error {
+	params := *input
+	for {
+		if err := canceled(ctx); err != nil {
+			return err
+		}
+		out, err := c.DescribeLogStreams(&params)
+		if err != nil {
+			return err
+		}
+		lastPage := out.NextToken == nil
+		if !fn(out, lastPage) || lastPage {
+			return nil
+		}
+		params.NextToken = out.NextToken
+	}
+}
+
+// FilterLogEventsWithContext returns the events of a group within a time
+// window, interleaved across streams and ordered by timestamp.
+func (c *Client) FilterLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.FilterLogEventsInput, opts ...request.Option) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.injectedError("FilterLogEvents"); err != nil {
+		return nil, err
+	}
+
+	g, ok := c.groups[aws.StringValue(input.LogGroupName)]
+	if !ok {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log group does not exist.", nil)
+	}
+	if len(input.LogStreamNames) > MaxFilterStreams {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, fmt.Sprintf("logStreamNames must contain at most %d names.", MaxFilterStreams), nil)
+	}
+
+	streams := []*logStream{}
+	if input.LogStreamNames != nil {
+		for _, name := range input.LogStreamNames {
+			if s, ok := g.streams[aws.StringValue(name)]; ok {
+				streams = append(streams, s)
+			}
+		}
+	} else {
+		for _, s := range g.streams {
+			streams = append(streams, s)
+		}
+	}
+	sort.Slice(streams, func(i, j int) bool { return streams[i].name < streams[j].name })
+
+	var pattern *lib.FilterPattern
+	if input.FilterPattern != nil {
+		var err error
+		if pattern, err = lib.ParseFilterPattern(*input.FilterPattern); err != nil {
+			return nil, awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, "Invalid filter pattern", err)
+		}
+	}
+
+	afterTs, afterSeq, err := parseEventToken(input.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*logEvent{}
+	for _, s := range streams {
+		for _, e := range s.events {
+			if input.StartTime != nil && e.timestamp < *input.StartTime {
+				continue
+			}
+			if input.EndTime != nil && e.timestamp > *input.EndTime {
+				continue
+			}
+			if e.timestamp < afterTs || (e.timestamp == afterTs && e.seq <= afterSeq) {
+				continue
+			}
+			if pattern != nil && !pattern.Match(e.message) {
+				continue
+			}
+			events = append(events, e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].before(events[j]) })
+
+	end := pageEnd(0, len(events), c.limit(input.Limit, DefaultEventsLimit))
+
+	out := &cloudwatchlogs.FilterLogEventsOutput{
+		Events:             []*cloudwatchlogs.FilteredLogEvent{},
+		SearchedLogStreams: []*cloudwatchlogs.SearchedLogStream{},
+	}
+	for _, e := range events[:end] {
+		out.Events = append(out.Events, &cloudwatchlogs.FilteredLogEvent{
+			EventId:       aws.String(e.id),
+			IngestionTime: aws.Int64(e.ingestionTime),
+			LogStreamName: aws.String(e.stream),
+			Message:       aws.String(e.message),
+			Timestamp:     aws.Int64(e.timestamp),
+		})
+	}
+	for _, s := range streams {
+		out.SearchedLogStreams = append(out.SearchedLogStreams, &cloudwatchlogs.SearchedLogStream{
+			LogStreamName:      aws.String(s.name),
+			SearchedCompletely: aws.Bool(end == len(events)),
+		})
+	}
+	if end < len(events) {
+		last := events[end-1]
+		out.NextToken = aws.String(fmt.Sprintf("e/%d/%d", last.timestamp, last.seq))
+	}
+	return out, nil
+}
+
+// GetLogEventsWithContext returns the events of a single stream.  Without a
+// token the newest events are returned unless StartFromHead is set.
+func (c *Client) GetLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.GetLogEventsInput, opts ...request.Option) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	if err := canceled(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.injectedError("GetLogEvents"); err != nil {
+		return nil, err
+	}
+
+	g, ok := c.groups[aws.StringValue(input.LogGroupName)]
+	if !ok {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log group does not exist.", nil)
+	}
+	s, ok := g.streams[aws.StringValue(input.LogStreamName)]
+	if !ok {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log stream does not exist.", nil)
+	}
+
+	events := []*logEvent{}
+	for _, e := range s.events {
+		if input.StartTime != nil && e.timestamp < *input.StartTime {
+			continue
+		}
+		if input.EndTime != nil && e.timestamp >= *input.EndTime {
+			continue
+		}
+		events = append(events, e)
+	}
+
+	limit := c.limit(input.Limit, DefaultEventsLimit)
+	var from, to int
+	switch {
+	case input.NextToken != nil && strings.HasPrefix(*input.NextToken, "b/"):
+		offset, err := parseOffsetToken(input.NextToken, "b")
+		if err != nil {
+			return nil, err
+		}
+		to = offset
+		from = to - limit
+	case input.NextToken != nil:
+		offset, err := parseOffsetToken(input.NextToken, "f")
+		if err != nil {
+			return nil, err
+		}
+		from = offset
+		to = from + limit
+	case aws.BoolValue(input.StartFromHead):
+		from, to = 0, limit
+	default:
+		from, to = len(events)-limit, len(events)
+	}
+	if from < 0 {
+		from = 0
+	}
+	if to > len(events) {
+		to = len(events)
+	}
+	if from > to {
+		from = to
+	}
+
+	out := &cloudwatchlogs.GetLogEventsOutput{
+		Events:            []*cloudwatchlogs.OutputLogEvent{},
+		NextForwardToken:  offsetToken("f", to),
+		NextBackwardToken: offsetToken("b", from),
+	}
+	for _, e := range events[from:to] {
+		out.Events = append(out.Events, &cloudwatchlogs.OutputLogEvent{
+			IngestionTime: aws.Int64(e.ingestionTime),
+			Message:       aws.String(e.message),
+			Timestamp:     aws.Int64(e.timestamp),
+		})
+	}
+	return out, nil
+}
+
+func (c *Client) limit(requested *int64, def int) int {
+	limit := def
+	if requested != nil && int(*requested) < limit {
+		limit = int(*requested)
+	}
+	if c.PageSize > 0 && c.PageSize < limit {
+		limit = c.PageSize
+	}
+	return limit
+}
+
+func (s *logStream) lastEventTime() int64 {
+	if len(s.events) == 0 {
+		return 0
+	}
+	return s.events[len(s.events)-1].timestamp
+}
+
+func (s *logStream) describe(group string) *cloudwatchlogs.LogStream {
+	ls := &cloudwatchlogs.LogStream{
+		Arn:           aws.String(fmt.Sprintf("arn:aws:logs:fake:000000000000:log-group:%s:log-stream:%s", group, s.name)),
+		CreationTime:  aws.Int64(s.creationTime),
+		LogStreamName: aws.String(s.name),
+		StoredBytes:   aws.Int64(s.storedBytes),
+	}
+	if len(s.events) > 0 {
+		var lastIngestion int64
+		for _, e := range s.events {
+			if e.ingestionTime > lastIngestion {
+				lastIngestion = e.ingestionTime
+			}
+		}
+		ls.FirstEventTimestamp = aws.Int64(s.events[0].timestamp)
+		ls.LastEventTimestamp = aws.Int64(s.lastEventTime())
+		ls.LastIngestionTime = aws.Int64(lastIngestion)
+	}
+	return ls
+}
+
+func (e *logEvent) before(other *logEvent) bool {
+	if e.timestamp != other.timestamp {
+		return e.timestamp < other.timestamp
+	}
+	return e.seq < other.seq
+}
+
+// canceled returns the error the SDK returns for calls made with a done
+// context
+func canceled(ctx aws.Context) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+	return nil
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func pageEnd(offset int, total int, limit int) int {
+	if offset+limit > total {
+		return total
+	}
+	return offset + limit
+}
+
+func offsetToken(kind string, offset int) *string {
+	return aws.String(fmt.Sprintf("%s/%d", kind, offset))
+}
+
+func parseOffsetToken(token *string, kind string) (int, error) {
+	if token == nil {
+		return 0, nil
+	}
+	value := strings.TrimPrefix(*token, kind+"/")
+	offset, err := strconv.Atoi(value)
+	if err != nil || value == *token || offset < 0 {
+		return 0, invalidToken(*token)
+	}
+	return offset, nil
+}
+
+func parseEventToken(token *string) (int64, int64, error) {
+	if token == nil {
+		return -1 << 63, 0, nil
+	}
+	parts := strings.Split(*token, "/")
+	if len(parts) != 3 || parts[0] != "e" {
+		return 0, 0, invalidToken(*token)
+	}
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, invalidToken(*token)
+	}
+	seq, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return 0, 0, invalidToken(*token)
+	}
+	return ts, seq, nil
+}
+
+func invalidToken(token string) error {
+	return awserr.New(cloudwatchlogs.ErrCodeInvalidParameterException, fmt.Sprintf("The specified nextToken is invalid: %s", token), nil)
+}
@@ -0,0 +1,337 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+var base = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func at(seconds int) time.Time {
+	return base.Add(time.Duration(seconds) * time.Second)
+}
+
+func TestDescribeLogGroupsPages(t *testing.T) {
+	c := New()
+	c.PageSize = 2
+	for _, name := range []string{"/svc/c", "/svc/a", "/other", "/svc/b", "/svc/d"} {
+		c.AddLogGroup(name)
+	}
+
+	input := &cloudwatchlogs.DescribeLogGroupsInput{LogGroupNamePrefix: aws.String("/svc/")}
+	names := []string{}
+	pages := 0
+	for {
+		out, err := c.DescribeLogGroupsWithContext(context.Background(), input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, g := range out.LogGroups {
+			names = append(names, *g.LogGroupName)
+		}
+		if out.NextToken == nil {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+
+	if want := "[/svc/a /svc/b /svc/c /svc/d]"; fmt.Sprint(names) != want {
+		t.Errorf("groups = %v, want %s", names, want)
+	}
+	if pages != 2 {
+		t.Errorf("pages = %d, want 2", pages)
+	}
+}
+
+func TestDescribeLogStreamsPages(t *testing.T) {
+	c := New()
+	c.PageSize = 2
+	c.AddEvent("g", "task-b", at(30), "b")
+	c.AddEvent("g", "task-a", at(10), "a")
+	c.AddEvent("g", "task-c", at(20), "c")
+	c.AddEvent("g", "other", at(40), "o")
+
+	tests := []struct {
+		name  string
+		input cloudwatchlogs.DescribeLogStreamsInput
+		want  string
+	}{
+		{
+			name:  "prefix",
+			input: cloudwatchlogs.DescribeLogStreamsInput{LogStreamNamePrefix: aws.String("task-")},
+			want:  "[task-a task-b task-c]",
+		},
+		{
+			name: "last event time descending",
+			input: cloudwatchlogs.DescribeLogStreamsInput{
+				OrderBy:    aws.String(cloudwatchlogs.OrderByLastEventTime),
+				Descending: aws.Bool(true),
+			},
+			want: "[other task-b task-c task-a]",
+		},
+	}
+
+	for _, test := range tests {
+		input := test.input
+		input.LogGroupName = aws.String("g")
+		names := []string{}
+		pages := 0
+		err := c.DescribeLogStreamsPagesWithContext(context.Background(), &input, func(o *cloudwatchlogs.DescribeLogStreamsOutput, lastPage bool) bool {
+			pages++
+			if len(o.LogStreams) > 2 {
+				t.Errorf("%s: page of %d streams, want at most 2", test.name, len(o.LogStreams))
+			}
+			for _, s := range o.LogStreams {
+				names = append(names, *s.LogStreamName)
+			}
+			return true
+		})
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if fmt.Sprint(names) != test.want {
+			t.Errorf("%s: streams = %v, want %s", test.name, names, test.want)
+		}
+		if pages < 2 {
+			t.Errorf("%s: %d pages, want several", test.name, pages)
+		}
+	}
+}
+
+func TestDescribeLogStreamsPrefixOrderedByTime(t *testing.T) {
+	c := New()
+	c.AddEvent("g", "task-a", at(0), "a")
+
+	_, err := c.DescribeLogStreams(&cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        aws.String("g"),
+		LogStreamNamePrefix: aws.String("task-"),
+		OrderBy:             aws.String(cloudwatchlogs.OrderByLastEventTime),
+	})
+	if code := errorCode(err); code != cloudwatchlogs.ErrCodeInvalidParameterException {
+		t.Errorf("error code = %q, want %s", code, cloudwatchlogs.ErrCodeInvalidParameterException)
+	}
+}
+
+func TestFilterLogEventsPages(t *testing.T) {
+	c := New()
+	c.PageSize = 3
+	want := []string{}
+	for i := 0; i < 10; i++ {
+		// several events share each timestamp so that pages split them
+		stream := fmt.Sprintf("s%d", i%3)
+		message := fmt.Sprintf("m%d", i)
+		c.AddEvent("g", stream, at(i/2), message)
+		want = append(want, message)
+	}
+
+	input := &cloudwatchlogs.FilterLogEventsInput{LogGroupName: aws.String("g")}
+	got := []string{}
+	for {
+		out, err := c.FilterLogEventsWithContext(context.Background(), input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Events) > 3 {
+			t.Errorf("page of %d events, want at most 3", len(out.Events))
+		}
+		for _, e := range out.Events {
+			got = append(got, *e.Message)
+		}
+		if out.NextToken == nil {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestFilterLogEventsWindow(t *testing.T) {
+	c := New()
+	for i := 0; i < 10; i++ {
+		c.AddEvent("g", fmt.Sprintf("s%d", i%2), at(i), fmt.Sprintf("m%d", i))
+	}
+
+	tests := []struct {
+		name  string
+		input cloudwatchlogs.FilterLogEventsInput
+		want  string
+	}{
+		{
+			name: "start and end are inclusive",
+			input: cloudwatchlogs.FilterLogEventsInput{
+				StartTime: aws.Int64(millis(at(3))),
+				EndTime:   aws.Int64(millis(at(5))),
+			},
+			want: "[m3 m4 m5]",
+		},
+		{
+			name:  "start only",
+			input: cloudwatchlogs.FilterLogEventsInput{StartTime: aws.Int64(millis(at(8)))},
+			want:  "[m8 m9]",
+		},
+		{
+			name: "stream names",
+			input: cloudwatchlogs.FilterLogEventsInput{
+				EndTime:        aws.Int64(millis(at(4))),
+				LogStreamNames: aws.StringSlice([]string{"s1", "missing"}),
+			},
+			want: "[m1 m3]",
+		},
+		{
+			name:  "filter pattern",
+			input: cloudwatchlogs.FilterLogEventsInput{FilterPattern: aws.String("m7")},
+			want:  "[m7]",
+		},
+	}
+
+	for _, test := range tests {
+		input := test.input
+		input.LogGroupName = aws.String("g")
+		out, err := c.FilterLogEventsWithContext(context.Background(), &input)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		got := []string{}
+		for _, e := range out.Events {
+			got = append(got, *e.Message)
+		}
+		if fmt.Sprint(got) != test.want {
+			t.Errorf("%s: events = %v, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGetLogEventsPages(t *testing.T) {
+	c := New()
+	c.PageSize = 2
+	for i := 0; i < 5; i++ {
+		c.AddEvent("g", "s", at(i), fmt.Sprintf("m%d", i))
+	}
+
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String("g"),
+		LogStreamName: aws.String("s"),
+		StartFromHead: aws.Bool(true),
+	}
+	got := []string{}
+	for {
+		out, err := c.GetLogEventsWithContext(context.Background(), input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Events) == 0 {
+			break
+		}
+		for _, e := range out.Events {
+			got = append(got, *e.Message)
+		}
+		input.NextToken = out.NextForwardToken
+	}
+	if want := "[m0 m1 m2 m3 m4]"; fmt.Sprint(got) != want {
+		t.Errorf("forward events = %v, want %s", got, want)
+	}
+
+	// without a token the newest events come first
+	out, err := c.GetLogEventsWithContext(context.Background(), &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String("g"),
+		LogStreamName: aws.String("s"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(out.Events); got != 2 || *out.Events[1].Message != "m4" {
+		t.Errorf("tail page = %v, want m3 and m4", out.Events)
+	}
+}
+
+func TestInvalidTokens(t *testing.T) {
+	c := New()
+	c.AddEvent("g", "s", at(0), "m")
+	ctx := context.Background()
+
+	_, err := c.DescribeLogGroupsWithContext(ctx, &cloudwatchlogs.DescribeLogGroupsInput{NextToken: aws.String("s/1")})
+	if code := errorCode(err); code != cloudwatchlogs.ErrCodeInvalidParameterException {
+		t.Errorf("DescribeLogGroups error code = %q", code)
+	}
+
+	_, err = c.FilterLogEventsWithContext(ctx, &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName: aws.String("g"),
+		NextToken:    aws.String("g/1"),
+	})
+	if code := errorCode(err); code != cloudwatchlogs.ErrCodeInvalidParameterException {
+		t.Errorf("FilterLogEvents error code = %q", code)
+	}
+}
+
+func TestMissingGroup(t *testing.T) {
+	c := New()
+	_, err := c.FilterLogEventsWithContext(context.Background(), &cloudwatchlogs.FilterLogEventsInput{LogGroupName: aws.String("missing")})
+	if code := errorCode(err); code != cloudwatchlogs.ErrCodeResourceNotFoundException {
+		t.Errorf("error code = %q, want %s", code, cloudwatchlogs.ErrCodeResourceNotFoundException)
+	}
+}
+
+func TestFailNext(t *testing.T) {
+	c := New()
+	c.AddEvent("g", "s", at(0), "m")
+	throttled := awserr.New("ThrottlingException", "Rate exceeded", nil)
+	c.FailNext("FilterLogEvents", throttled, 2)
+
+	input := &cloudwatchlogs.FilterLogEventsInput{LogGroupName: aws.String("g")}
+	for i := 0; i < 2; i++ {
+		if _, err := c.FilterLogEventsWithContext(context.Background(), input); err != throttled {
+			t.Errorf("call %d: error = %v, want the injected error", i, err)
+		}
+	}
+	out, err := c.FilterLogEventsWithContext(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Events) != 1 {
+		t.Errorf("%d events, want 1", len(out.Events))
+	}
+
+	// errors are queued per operation
+	failure := errors.New("boom")
+	c.FailNext("DescribeLogStreams", failure, 1)
+	if _, err := c.DescribeLogGroupsWithContext(context.Background(), &cloudwatchlogs.DescribeLogGroupsInput{}); err != nil {
+		t.Errorf("DescribeLogGroups error = %v, want nil", err)
+	}
+	err = c.DescribeLogStreamsPagesWithContext(context.Background(), &cloudwatchlogs.DescribeLogStreamsInput{LogGroupName: aws.String("g")}, func(*cloudwatchlogs.DescribeLogStreamsOutput, bool) bool { return true })
+	if err != failure {
+		t.Errorf("DescribeLogStreams error = %v, want the injected error", err)
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	c := New()
+	c.AddEvent("g", "s", at(0), "m")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := c.FilterLogEventsWithContext(ctx, &cloudwatchlogs.FilterLogEventsInput{LogGroupName: aws.String("g")})
+	if code := errorCode(err); code != request.CanceledErrorCode {
+		t.Errorf("error code = %q, want %s", code, request.CanceledErrorCode)
+	}
+}
+
+func errorCode(err error) string {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code()
+	}
+	return ""
+}
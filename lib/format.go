@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"text/template"
+
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+const (
+	// DefaultFormat is the template of the default profile
+	DefaultFormat = `[ {{ uniquecolor (print .TaskShort) }} ] {{ .TimeShort }} {{ colorlevel .Level }} - {{ .Message }}`
+
+	// VerboseFormat is the template of the verbose profile, which adds the
+	// data fields and errors of events
+	VerboseFormat = `[ {{ uniquecolor (print .TaskShort) }} ] {{ .TimeShort }} {{ colorlevel .Level }} {{- range $key, $value := .DataFlat }} {{ printf "%v=%v" $key $value }} {{end}} {{- if gt (len .Info.Errors) 0 }} Errors=[{{- range $value := .Info.Errors }} Type={{ printf "%s" $value.Type }} Error={{ printf "%s" $value.Error }} {{ if $value.Stack }} Stack={{printf "%v" $value.Stack}} {{- end }}{{- end }}] {{ end }} - {{ .Message }}`
+
+	// RawFormat is the template of the raw profile, which prints events as
+	// indented JSON
+	RawFormat = `{{ .PrettyPrint }}`
+)
+
+// BuiltinFormatProfiles are the profiles available without a config file
+var BuiltinFormatProfiles = map[string]FormatProfile{
+	"default": {Format: DefaultFormat},
+	"verbose": {Format: VerboseFormat},
+	"raw":     {Format: RawFormat},
+}
+
+// FormatProfile is a named set of templates used to print events.  Files are
+// parsed first, each one as a template named after its base name, so they can
+// hold partials declared with define and used with template.  Format is the
+// main template, it defaults to the template of the first file.  Levels
+// replace the main template for the events of some levels.
+type FormatProfile struct {
+	Format string            `yaml:"format"`
+	Files  []string          `yaml:"files"`
+	Levels map[string]string `yaml:"levels"`
+}
+
+// EventTemplate prints events with the templates of a profile
+type EventTemplate struct {
+	main   *template.Template
+	levels map[ecslogs.Level]*template.Template
+}
+
+// Compile parses the templates of the profile
+func (p FormatProfile) Compile() (*EventTemplate, error) {
+	root := template.New("event").Funcs(TemplateFuncMap())
+
+	var first *template.Template
+	for _, file := range p.Files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		t, err := root.New(filepath.Base(file)).Parse(string(b))
+		if err != nil {
+			return nil, err
+		}
+		if first == nil {
+			first = t
+		}
+	}
+
+	main := first
+	if p.Format != "" {
+		var err error
+		if main, err = root.Parse(p.Format); err != nil {
+			return nil, err
+		}
+	}
+	if main == nil {
+		return nil, fmt.Errorf("no format or files")
+	}
+
+	levels := map[ecslogs.Level]*template.Template{}
+	for name, format := range p.Levels {
+		level, err := ecslogs.ParseLevel(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level '%s'", name)
+		}
+		if levels[level], err = root.New("level " + level.String()).Parse(format); err != nil {
+			return nil, err
+		}
+	}
+
+	return &EventTemplate{main: main, levels: levels}, nil
+}
+
+// Execute prints e with the template of its level or the main template
+func (t *EventTemplate) Execute(w io.Writer, e Event) error {
+	if level, ok := t.levels[e.Level]; ok {
+		return level.Execute(w, e)
+	}
+	return t.main.Execute(w, e)
+}
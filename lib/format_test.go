@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+func formatEvent() Event {
+	e := Event{Stream: "0f4c5a3e-1b2c-4d5e-8f90-a1b2c3d4e5f6"}
+	e.Time = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	e.Level = ecslogs.INFO
+	e.Message = "hello"
+	e.Data = ecslogs.EventData{"status": 200}
+	return e
+}
+
+func TestFormatProfileCompile(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	dir, err := ioutil.TempDir("", "cwlogs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	partials := filepath.Join(dir, "partials.tmpl")
+	if err := ioutil.WriteFile(partials, []byte(`{{ define "line" }}{{ .Level }}: {{ .Message }}{{ end }}first file`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		profile FormatProfile
+		info    string
+		error   string
+	}{
+		{"format", FormatProfile{Format: "{{ .Message }}"}, "hello", "hello"},
+		{"partials", FormatProfile{Files: []string{partials}, Format: `[{{ template "line" . }}]`}, "[INFO: hello]", "[ERROR: hello]"},
+		// the first file is the main template without a format
+		{"files only", FormatProfile{Files: []string{partials}}, "first file", "first file"},
+		// levels replace the main template
+		{"levels", FormatProfile{
+			Files:  []string{partials},
+			Format: `{{ template "line" . }}`,
+			Levels: map[string]string{"error": `!! {{ template "line" . }} {{ get "data.status" . }}`},
+		}, "INFO: hello", "!! ERROR: hello 200"},
+	}
+	for _, test := range tests {
+		tmpl, err := test.profile.Compile()
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		e := formatEvent()
+		for _, want := range []string{test.info, test.error} {
+			var out bytes.Buffer
+			if err := tmpl.Execute(&out, e); err != nil {
+				t.Errorf("%s: %s", test.name, err)
+			} else if out.String() != want {
+				t.Errorf("%s: %s event = %q, want %q", test.name, e.Level, out.String(), want)
+			}
+			e.Level = ecslogs.ERROR
+		}
+	}
+
+	errors := []struct {
+		name    string
+		profile FormatProfile
+		err     string
+	}{
+		{"empty", FormatProfile{}, "no format or files"},
+		{"syntax", FormatProfile{Format: "{{ .Message "}, "unclosed action"},
+		{"missing file", FormatProfile{Files: []string{filepath.Join(dir, "missing.tmpl")}}, "no such file"},
+		{"unknown level", FormatProfile{Format: "{{ .Message }}", Levels: map[string]string{"LOUD": "!"}}, "invalid level 'LOUD'"},
+		{"level syntax", FormatProfile{Format: "{{ .Message }}", Levels: map[string]string{"ERROR": "{{ end }}"}}, "unexpected"},
+	}
+	for _, test := range errors {
+		if _, err := test.profile.Compile(); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error = %v, want %s", test.name, err, test.err)
+		}
+	}
+}
+
+func TestBuiltinFormatProfiles(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+	defer setLocal(time.UTC)()
+
+	e := formatEvent()
+	e.Info.Errors = []ecslogs.EventError{{Type: "timeout", Error: "too slow"}}
+	output := func(name string) string {
+		tmpl, err := BuiltinFormatProfiles[name].Compile()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, e); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		return out.String()
+	}
+
+	if got, want := output("default"), "[ 0f4c5a3e ] 03-01 12:00:00 INFO - hello"; got != want {
+		t.Errorf("default = %q, want %q", got, want)
+	}
+	if got, want := output("verbose"), "[ 0f4c5a3e ] 03-01 12:00:00 INFO status=200  Errors=[ Type=timeout Error=too slow ]  - hello"; got != want {
+		t.Errorf("verbose = %q, want %q", got, want)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(output("raw")), &raw); err != nil {
+		t.Fatalf("raw isn't JSON: %s", err)
+	}
+	if raw["message"] != "hello" || raw["level"] != "INFO" {
+		t.Errorf("raw = %v", raw)
+	}
+}
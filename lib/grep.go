@@ -0,0 +1,217 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+// Grep searches the message and data values of events for regular expressions
+type Grep struct {
+	patterns []*regexp.Regexp
+}
+
+// NewGrep compiles patterns, an event matches if any of them is found
+func NewGrep(patterns []string, ignoreCase bool) (*Grep, error) {
+	g := &Grep{}
+	for _, pattern := range patterns {
+		expr := pattern
+		if ignoreCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --grep pattern '%s': %s", pattern, err)
+		}
+		g.patterns = append(g.patterns, re)
+	}
+	return g, nil
+}
+
+// Match reports whether any pattern is found in the message or a data value
+// of the event
+func (g *Grep) Match(e Event) bool {
+	if g.matchString(e.Message) {
+		return true
+	}
+	for _, value := range e.DataFlat() {
+		if g.matchString(fmt.Sprint(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Grep) matchString(s string) bool {
+	for _, re := range g.patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Highlight returns a copy of the event with the matches in its message and
+// data values colored by the Highlight color helper.  Data values containing a
+// match are turned into strings.
+func (g *Grep) Highlight(e Event) Event {
+	e.Message = g.highlightString(e.Message)
+	if e.Data != nil {
+		e.Data = g.highlightMap(e.Data)
+	}
+	return e
+}
+
+func (g *Grep) highlightMap(data map[string]interface{}) ecslogs.EventData {
+	highlighted := make(ecslogs.EventData, len(data))
+	for key, value := range data {
+		highlighted[key] = g.highlightValue(value)
+	}
+	return highlighted
+}
+
+func (g *Grep) highlightValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return g.highlightMap(v)
+	case ecslogs.EventData:
+		return g.highlightMap(v)
+	case nil:
+		return nil
+	case string:
+		return g.highlightString(v)
+	}
+	s := fmt.Sprint(value)
+	if !g.matchString(s) {
+		return value
+	}
+	return g.highlightString(s)
+}
+
+// highlightString colors every match of every pattern, merging overlapping
+// matches
+func (g *Grep) highlightString(s string) string {
+	var spans [][]int
+	for _, re := range g.patterns {
+		for _, span := range re.FindAllStringIndex(s, -1) {
+			if span[0] < span[1] {
+				spans = append(spans, span)
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return s
+	}
+
+	marked := make([]bool, len(s))
+	for _, span := range spans {
+		for i := span[0]; i < span[1]; i++ {
+			marked[i] = true
+		}
+	}
+
+	var out bytes.Buffer
+	for i := 0; i < len(s); {
+		j := i
+		for j < len(s) && marked[j] == marked[i] {
+			j++
+		}
+		if marked[i] {
+			out.WriteString(Highlight(s[i:j]))
+		} else {
+			out.WriteString(s[i:j])
+		}
+		i = j
+	}
+	return out.String()
+}
+
+// ContextEvent is an event selected by a ContextFilter
+type ContextEvent struct {
+	Event
+
+	// Match is false for events only shown as context of a match
+	Match bool
+
+	// Gap is true when events of the same stream were skipped since the
+	// previous event selected from that stream
+	Gap bool
+}
+
+// ContextFilter selects the events matching a predicate along with a number
+// of events before and after them in the same stream of the same group, like
+// grep -B and -A.  Events must be added in the order they are displayed.
+type ContextFilter struct {
+	before  int
+	after   int
+	match   func(Event) bool
+	streams map[string]*streamContext
+}
+
+type streamContext struct {
+	seen      int
+	selected  int
+	afterLeft int
+	pending   []contextEntry
+}
+
+type contextEntry struct {
+	event Event
+	seq   int
+}
+
+// NewContextFilter returns a filter keeping before and after events around
+// each event for which match returns true
+func NewContextFilter(before int, after int, match func(Event) bool) *ContextFilter {
+	return &ContextFilter{
+		before:  before,
+		after:   after,
+		match:   match,
+		streams: map[string]*streamContext{},
+	}
+}
+
+// Add returns the events to display after adding e, which are the context
+// held back before e and e itself if it matched or follows a recent match
+func (f *ContextFilter) Add(e Event) []ContextEvent {
+	// streams of different groups can share a name
+	key := e.Group + "\x00" + e.Stream
+	stream, ok := f.streams[key]
+	if !ok {
+		stream = &streamContext{}
+		f.streams[key] = stream
+	}
+	stream.seen++
+	seq := stream.seen
+
+	if f.match(e) {
+		selected := make([]ContextEvent, 0, len(stream.pending)+1)
+		for _, entry := range stream.pending {
+			selected = append(selected, stream.selectEvent(entry.event, entry.seq, false))
+		}
+		stream.pending = stream.pending[:0]
+		stream.afterLeft = f.after
+		return append(selected, stream.selectEvent(e, seq, true))
+	}
+
+	if stream.afterLeft > 0 {
+		stream.afterLeft--
+		return []ContextEvent{stream.selectEvent(e, seq, false)}
+	}
+
+	if f.before > 0 {
+		if len(stream.pending) == f.before {
+			stream.pending = append(stream.pending[:0], stream.pending[1:]...)
+		}
+		stream.pending = append(stream.pending, contextEntry{e, seq})
+	}
+	return nil
+}
+
+func (s *streamContext) selectEvent(e Event, seq int, match bool) ContextEvent {
+	gap := s.selected > 0 && seq != s.selected+1
+	s.selected = seq
+	return ContextEvent{Event: e, Match: match, Gap: gap}
+}
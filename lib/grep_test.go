@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestContextFilter(t *testing.T) {
+	f := NewContextFilter(1, 1, func(e Event) bool { return strings.HasPrefix(e.Message, "match") })
+
+	add := func(group string, stream string, message string) string {
+		e := Event{Group: group, Stream: stream}
+		e.Message = message
+		selected := []string{}
+		for _, c := range f.Add(e) {
+			s := c.Group + ":" + c.Message
+			if c.Gap {
+				s = "-- " + s
+			}
+			selected = append(selected, s)
+		}
+		return fmt.Sprint(selected)
+	}
+
+	steps := []struct {
+		group, stream, message string
+		want                   string
+	}{
+		{"a", "web", "a1", "[]"},
+		// the same stream name in another group has its own context
+		{"b", "web", "b1", "[]"},
+		{"a", "web", "match a2", "[a:a1 a:match a2]"},
+		{"b", "web", "b2", "[]"},
+		{"a", "web", "a3", "[a:a3]"},
+		{"a", "web", "a4", "[]"},
+		{"a", "web", "a5", "[]"},
+		{"b", "web", "match b3", "[b:b2 b:match b3]"},
+		{"a", "web", "match a6", "[-- a:a5 a:match a6]"},
+	}
+	for _, step := range steps {
+		if got := add(step.group, step.stream, step.message); got != step.want {
+			t.Errorf("Add(%s/%s %q) = %s, want %s", step.group, step.stream, step.message, got, step.want)
+		}
+	}
+}
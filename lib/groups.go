@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// NewClient returns the CloudWatch Logs client a reader built with the same
+// options would use.  It lets several readers share one client.
+func NewClient(options ...ReaderOption) (CloudwatchLogsClient, error) {
+	config := ReaderConfig{}
+	for _, option := range options {
+		option(&config)
+	}
+	svc, err := config.client()
+	if err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+// ExpandLogGroups resolves a list of log group names and glob patterns (as
+// understood by path.Match, so `*` doesn't cross `/`) into log group names.
+// Names without glob characters are returned as is, and duplicates are
+// removed while preserving the order of the input.
+func ExpandLogGroups(svc CloudwatchLogsClient, patterns []string) ([]string, error) {
+	return ExpandLogGroupsWithContext(context.Background(), svc, patterns)
+}
+
+// ExpandLogGroupsWithContext is like ExpandLogGroups, giving up when ctx is
+// done
+func ExpandLogGroupsWithContext(ctx context.Context, svc CloudwatchLogsClient, patterns []string) ([]string, error) {
+	seen := map[string]bool{}
+	groups := []string{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			groups = append(groups, name)
+		}
+	}
+
+	for _, pattern := range patterns {
+		if !isGlob(pattern) {
+			add(pattern)
+			continue
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid log group pattern '%s'", pattern)
+		}
+
+		prefix := pattern[:strings.IndexAny(pattern, `*?[\`)]
+		all, err := listLogGroups(ctx, svc, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		matched := false
+		for _, group := range all {
+			if ok, _ := path.Match(pattern, *group.LogGroupName); ok {
+				add(*group.LogGroupName)
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("No log groups match '%s'", pattern)
+		}
+	}
+
+	return groups, nil
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+func listLogGroups(ctx context.Context, svc CloudwatchLogsClient, prefix string) ([]*cloudwatchlogs.LogGroup, error) {
+	params := &cloudwatchlogs.DescribeLogGroupsInput{}
+	if prefix != "" {
+		params.LogGroupNamePrefix = aws.String(prefix)
+	}
+
+	groups := []*cloudwatchlogs.LogGroup{}
+	for {
+		resp, err := svc.DescribeLogGroupsWithContext(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, resp.LogGroups...)
+
+		if resp.NextToken == nil {
+			return groups, nil
+		}
+		params.NextToken = resp.NextToken
+	}
+}
@@ -0,0 +1,164 @@
+package lib
+
+import "time"
+
+// bucketWidths are the widths picked by BucketWidth, from the narrowest
+var bucketWidths = []time.Duration{
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	15 * time.Second,
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	15 * time.Minute,
+	30 * time.Minute,
+	time.Hour,
+	2 * time.Hour,
+	3 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+// BucketWidth returns the narrowest round width splitting the window from
+// start to end in at most max buckets
+func BucketWidth(start time.Time, end time.Time, max int) time.Duration {
+	if max < 1 {
+		max = 1
+	}
+	for _, width := range bucketWidths {
+		if bucketCount(start, end, width) <= max {
+			return width
+		}
+	}
+	return bucketWidths[len(bucketWidths)-1]
+}
+
+// BucketStart returns the start of the bucket of the given width holding t.
+// Buckets are aligned on the local time zone, like TimeShort, so that hourly
+// buckets start on the hour and daily buckets at midnight wherever the offset
+// isn't a whole number of hours.
+func BucketStart(t time.Time, width time.Duration) time.Time {
+	_, offset := t.Local().Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(width).Add(-shift).Local()
+}
+
+func bucketCount(start time.Time, end time.Time, width time.Duration) int {
+	first := BucketStart(start, width)
+	if !end.After(first) {
+		return 1
+	}
+	return int((end.Sub(first) + width - 1) / width)
+}
+
+// Histogram counts events in buckets of equal width, one series of counts
+// per name, such as a level or a stream
+type Histogram struct {
+	Start  time.Time
+	Width  time.Duration
+	Counts map[string][]int
+	size   int
+}
+
+// NewHistogram returns a histogram with the buckets covering start to end
+func NewHistogram(start time.Time, end time.Time, width time.Duration) *Histogram {
+	return &Histogram{
+		Start:  BucketStart(start, width),
+		Width:  width,
+		Counts: map[string][]int{},
+		size:   bucketCount(start, end, width),
+	}
+}
+
+// Len returns the number of buckets
+func (h *Histogram) Len() int {
+	return h.size
+}
+
+// BucketTime returns the start of bucket i
+func (h *Histogram) BucketTime(i int) time.Time {
+	return h.Start.Add(time.Duration(i) * h.Width)
+}
+
+// Add counts an event at t in series, adding buckets when t is past the last
+// one.  Events before the first bucket are ignored.
+func (h *Histogram) Add(series string, t time.Time) {
+	if t.Before(h.Start) {
+		return
+	}
+	i := int(t.Sub(h.Start) / h.Width)
+	if i >= h.size {
+		h.size = i + 1
+	}
+
+	counts := h.Counts[series]
+	if len(counts) < h.size {
+		counts = append(counts, make([]int, h.size-len(counts))...)
+		h.Counts[series] = counts
+	}
+	counts[i]++
+}
+
+// Extend adds buckets up to the one holding t
+func (h *Histogram) Extend(t time.Time) {
+	if i := int(t.Sub(h.Start) / h.Width); i >= h.size {
+		h.size = i + 1
+	}
+}
+
+// Trim drops the oldest buckets to keep at most n
+func (h *Histogram) Trim(n int) {
+	drop := h.size - n
+	if drop <= 0 {
+		return
+	}
+	for series, counts := range h.Counts {
+		if drop >= len(counts) {
+			delete(h.Counts, series)
+			continue
+		}
+		h.Counts[series] = counts[drop:]
+	}
+	h.Start = h.BucketTime(drop)
+	h.size = n
+}
+
+// Count returns the count of series in bucket i
+func (h *Histogram) Count(series string, i int) int {
+	counts := h.Counts[series]
+	if i < 0 || i >= len(counts) {
+		return 0
+	}
+	return counts[i]
+}
+
+// Total returns the count of every series in bucket i
+func (h *Histogram) Total(i int) int {
+	total := 0
+	for series := range h.Counts {
+		total += h.Count(series, i)
+	}
+	return total
+}
+
+// Series returns the names of the series ordered like SortCounts, from the
+// largest or by severity when byLevel is set
+func (h *Histogram) Series(byLevel bool) []string {
+	totals := make(map[string]int, len(h.Counts))
+	for series, counts := range h.Counts {
+		for _, count := range counts {
+			totals[series] += count
+		}
+	}
+	names := []string{}
+	for _, count := range SortCounts(totals, byLevel) {
+		names = append(names, count.Name)
+	}
+	return names
+}
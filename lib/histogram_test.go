@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// setLocal changes the local time zone until the returned func is called
+func setLocal(loc *time.Location) func() {
+	local := time.Local
+	time.Local = loc
+	return func() { time.Local = local }
+}
+
+func TestBucketStart(t *testing.T) {
+	// India is 5h30 ahead of UTC
+	defer setLocal(time.FixedZone("IST", 5*3600+1800))()
+
+	tests := []struct {
+		t     time.Time
+		width time.Duration
+		want  time.Time
+	}{
+		// 17:45 IST
+		{time.Date(2017, 3, 1, 12, 15, 0, 0, time.UTC), time.Hour, time.Date(2017, 3, 1, 11, 30, 0, 0, time.UTC)},
+		{time.Date(2017, 3, 1, 12, 15, 0, 0, time.UTC), 30 * time.Minute, time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{time.Date(2017, 3, 1, 12, 15, 7, 0, time.UTC), 5 * time.Second, time.Date(2017, 3, 1, 12, 15, 5, 0, time.UTC)},
+		// 01:30 IST the next day
+		{time.Date(2017, 3, 1, 20, 0, 0, 0, time.UTC), 24 * time.Hour, time.Date(2017, 3, 1, 18, 30, 0, 0, time.UTC)},
+		// on the boundary
+		{time.Date(2017, 3, 1, 11, 30, 0, 0, time.UTC), time.Hour, time.Date(2017, 3, 1, 11, 30, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got := BucketStart(test.t, test.width)
+		if !got.Equal(test.want) {
+			t.Errorf("BucketStart(%s, %s) = %s, want %s", test.t, test.width, got, test.want.Local())
+		}
+		if got.Location() != time.Local {
+			t.Errorf("BucketStart(%s, %s) is in %s, want local time", test.t, test.width, got.Location())
+		}
+	}
+}
+
+func TestBucketWidth(t *testing.T) {
+	defer setLocal(time.UTC)()
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		window time.Duration
+		max    int
+		want   time.Duration
+	}{
+		{time.Hour, 60, time.Minute},
+		{time.Hour, 59, 2 * time.Minute},
+		{time.Hour, 30, 2 * time.Minute},
+		{time.Hour, 0, time.Hour},
+		{time.Minute, 100, time.Second},
+		{24 * time.Hour, 30, time.Hour},
+		{7 * 24 * time.Hour, 30, 6 * time.Hour},
+		// windows too large for the widest buckets are split anyway
+		{47 * 365 * 24 * time.Hour, 30, 7 * 24 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := BucketWidth(start, start.Add(test.window), test.max); got != test.want {
+			t.Errorf("BucketWidth(%s, %d) = %s, want %s", test.window, test.max, got, test.want)
+		}
+	}
+
+	// an unaligned start adds a bucket
+	if got := BucketWidth(start.Add(30*time.Second), start.Add(time.Hour+30*time.Second), 60); got != 2*time.Minute {
+		t.Errorf("BucketWidth of an unaligned hour = %s, want 2m", got)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	defer setLocal(time.UTC)()
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(seconds float64) time.Time {
+		return start.Add(time.Duration(seconds * float64(time.Second)))
+	}
+
+	h := NewHistogram(at(0.5), at(10), time.Second)
+	if !h.Start.Equal(start) || h.Len() != 10 {
+		t.Fatalf("histogram starts at %s with %d buckets, want %s and 10", h.Start, h.Len(), start)
+	}
+
+	h.Add("INFO", at(2.5))
+	h.Add("INFO", at(2))
+	h.Add("INFO", at(-1))
+	h.Add("ERROR", at(9.9))
+	if h.Count("INFO", 2) != 2 || h.Count("INFO", 3) != 0 || h.Count("ERROR", 9) != 1 {
+		t.Errorf("counts = %v", h.Counts)
+	}
+	if h.Total(2) != 2 || h.Total(0) != 0 || h.Count("WARN", 2) != 0 || h.Count("INFO", -1) != 0 {
+		t.Errorf("counts = %v", h.Counts)
+	}
+
+	// events past the end add buckets
+	h.Add("ERROR", at(12))
+	if h.Len() != 13 || h.Count("ERROR", 12) != 1 {
+		t.Errorf("%d buckets with %v, want 13", h.Len(), h.Counts)
+	}
+	h.Extend(at(20))
+	if h.Len() != 21 {
+		t.Errorf("%d buckets after Extend, want 21", h.Len())
+	}
+	h.Extend(at(5))
+	if h.Len() != 21 {
+		t.Errorf("%d buckets after extending to an earlier time, want 21", h.Len())
+	}
+	h.Add("WARN", at(18))
+
+	h.Trim(5)
+	if h.Len() != 5 || !h.Start.Equal(at(16)) {
+		t.Errorf("%d buckets from %s after Trim, want 5 from %s", h.Len(), h.Start, at(16))
+	}
+	if len(h.Counts) != 1 {
+		t.Errorf("counts of trimmed buckets kept: %v", h.Counts)
+	}
+	if h.Count("WARN", 2) != 1 || h.Count("ERROR", 4) != 0 || !h.BucketTime(2).Equal(at(18)) {
+		t.Errorf("counts = %v", h.Counts)
+	}
+	h.Trim(10)
+	if h.Len() != 5 {
+		t.Errorf("%d buckets after trimming to more, want 5", h.Len())
+	}
+
+	h.Add("INFO", at(19))
+	h.Add("INFO", at(20))
+	h.Add("ERROR", at(20))
+	if got := fmt.Sprint(h.Series(true)); got != "[ERROR WARN INFO]" {
+		t.Errorf("series by level = %s", got)
+	}
+	if got := fmt.Sprint(h.Series(false)); got != "[INFO ERROR WARN]" {
+		t.Errorf("series by count = %s", got)
+	}
+}
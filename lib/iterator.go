@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventIterator yields events produced by a background goroutine.  Next and
+// Err are safe to call from any goroutine, and Close always releases the
+// background goroutine, even when the events were not all read.
+type EventIterator struct {
+	events chan Event
+	done   chan struct{}
+	cancel context.CancelFunc
+
+	// err is written before done is closed and only read after
+	err error
+}
+
+// newEventIterator runs pump in a goroutine, passing it an emit function that
+// hands an event to Next and fails once the iterator is closed or ctx is done
+func newEventIterator(ctx context.Context, pump func(ctx context.Context, emit func(Event) error) error) *EventIterator {
+	ctx, cancel := context.WithCancel(ctx)
+	it := &EventIterator{
+		events: make(chan Event),
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+
+	go func() {
+		defer close(it.done)
+		defer cancel()
+		it.err = pump(ctx, func(event Event) error {
+			select {
+			case it.events <- event:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	return it
+}
+
+// Next returns the next event.  It returns io.EOF once every event was read,
+// or the error that stopped the iterator.  If ctx is done first, Next returns
+// ctx.Err() and the iterator can still be used.
+func (it *EventIterator) Next(ctx context.Context) (Event, error) {
+	select {
+	case event := <-it.events:
+		return event, nil
+	case <-it.done:
+		if it.err != nil {
+			return Event{}, it.err
+		}
+		return Event{}, io.EOF
+	case <-ctx.Done():
+		return Event{}, ctx.Err()
+	}
+}
+
+// Err returns the error that stopped the iterator, or nil if it is still
+// running or every event was read
+func (it *EventIterator) Err() error {
+	select {
+	case <-it.done:
+		return it.err
+	default:
+		return nil
+	}
+}
+
+// Close stops the iterator and waits for its goroutine to exit
+func (it *EventIterator) Close() error {
+	it.cancel()
+	<-it.done
+	return nil
+}
+
+// MergeIterators merges the events of several iterators by creation time, see
+// MergeEvents for the meaning of window.  The merged iterator stops with the
+// first error of any source, and closing it closes the sources.
+func MergeIterators(ctx context.Context, window time.Duration, sources ...*EventIterator) *EventIterator {
+	return newEventIterator(ctx, func(ctx context.Context, emit func(Event) error) error {
+		mergeCtx, cancel := context.WithCancel(ctx)
+		defer func() {
+			cancel()
+			for _, source := range sources {
+				source.Close()
+			}
+		}()
+
+		var forwarders sync.WaitGroup
+		errs := make([]error, len(sources))
+		channels := make([]<-chan Event, len(sources))
+		for ix, source := range sources {
+			events := make(chan Event)
+			channels[ix] = events
+			forwarders.Add(1)
+			go func(ix int, source *EventIterator, events chan<- Event) {
+				defer forwarders.Done()
+				defer close(events)
+				for {
+					event, err := source.Next(mergeCtx)
+					if err != nil {
+						if err != io.EOF && mergeCtx.Err() == nil {
+							// a failed source would stall the merge, stop
+							// the others
+							errs[ix] = err
+							cancel()
+						}
+						return
+					}
+					select {
+					case events <- event:
+					case <-mergeCtx.Done():
+						return
+					}
+				}
+			}(ix, source, events)
+		}
+
+		var err error
+		for event := range MergeEvents(mergeCtx, window, channels...) {
+			if err = emit(event); err != nil {
+				break
+			}
+		}
+		cancel()
+		forwarders.Wait()
+
+		for _, sourceErr := range errs {
+			if sourceErr != nil {
+				return sourceErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+		return ctx.Err()
+	})
+}
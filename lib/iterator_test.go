@@ -0,0 +1,213 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+var errPump = errors.New("pump failed")
+
+func testEvent(id string, seconds int) Event {
+	e := Event{ID: id, CreationTime: time.Unix(int64(seconds), 0)}
+	e.Message = id
+	return e
+}
+
+// sliceIterator emits events then returns err
+func sliceIterator(err error, events ...Event) *EventIterator {
+	return newEventIterator(context.Background(), func(ctx context.Context, emit func(Event) error) error {
+		for _, event := range events {
+			if err := emit(event); err != nil {
+				return err
+			}
+		}
+		return err
+	})
+}
+
+// endlessIterator emits events one second apart from start until emit fails,
+// closing exited when its goroutine returns
+func endlessIterator(start int, exited chan<- struct{}) *EventIterator {
+	return newEventIterator(context.Background(), func(ctx context.Context, emit func(Event) error) error {
+		defer close(exited)
+		for i := start; ; i++ {
+			if err := emit(testEvent(fmt.Sprintf("e%d", i), i)); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+func readIDs(t *testing.T, it *EventIterator) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ids := []string{}
+	for {
+		event, err := it.Next(ctx)
+		if err == context.DeadlineExceeded {
+			t.Fatalf("iterator stalled after %v", ids)
+		}
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, event.ID)
+	}
+}
+
+func TestEventIteratorEOF(t *testing.T) {
+	it := sliceIterator(nil, testEvent("a", 1), testEvent("b", 2))
+	defer it.Close()
+
+	// the producer is blocked on the first event
+	if err := it.Err(); err != nil {
+		t.Errorf("Err() = %v before the end", err)
+	}
+
+	ids, err := readIDs(t, it)
+	if err != io.EOF {
+		t.Errorf("error = %v, want EOF", err)
+	}
+	if fmt.Sprint(ids) != "[a b]" {
+		t.Errorf("events = %v, want [a b]", ids)
+	}
+
+	// the end is sticky
+	if _, err := it.Next(context.Background()); err != io.EOF {
+		t.Errorf("Next() after EOF = %v, want EOF", err)
+	}
+	if err := it.Err(); err != nil {
+		t.Errorf("Err() after EOF = %v, want nil", err)
+	}
+}
+
+func TestEventIteratorError(t *testing.T) {
+	it := sliceIterator(errPump, testEvent("a", 1))
+	defer it.Close()
+
+	ids, err := readIDs(t, it)
+	if err != errPump {
+		t.Errorf("error = %v, want %v", err, errPump)
+	}
+	if fmt.Sprint(ids) != "[a]" {
+		t.Errorf("events = %v, want [a]", ids)
+	}
+
+	if _, err := it.Next(context.Background()); err != errPump {
+		t.Errorf("Next() after the error = %v, want %v", err, errPump)
+	}
+	if err := it.Err(); err != errPump {
+		t.Errorf("Err() = %v, want %v", err, errPump)
+	}
+}
+
+func TestEventIteratorNextCanceled(t *testing.T) {
+	release := make(chan struct{})
+	it := newEventIterator(context.Background(), func(ctx context.Context, emit func(Event) error) error {
+		<-release
+		return emit(testEvent("a", 1))
+	})
+	defer it.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := it.Next(ctx); err != context.Canceled {
+		t.Errorf("Next() = %v, want %v", err, context.Canceled)
+	}
+
+	// the iterator is still usable
+	close(release)
+	ids, err := readIDs(t, it)
+	if err != io.EOF || fmt.Sprint(ids) != "[a]" {
+		t.Errorf("events = %v, %v, want [a] and EOF", ids, err)
+	}
+}
+
+func TestEventIteratorEarlyClose(t *testing.T) {
+	exited := make(chan struct{})
+	it := endlessIterator(0, exited)
+
+	for i := 0; i < 3; i++ {
+		if _, err := it.Next(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	it.Close()
+
+	select {
+	case <-exited:
+	default:
+		t.Fatal("producer still running after Close")
+	}
+
+	// closing twice is fine, and the iterator reports the cancelation
+	it.Close()
+	if _, err := it.Next(context.Background()); err != context.Canceled {
+		t.Errorf("Next() after Close = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestMergeIteratorsOrder(t *testing.T) {
+	merged := MergeIterators(context.Background(), 0,
+		sliceIterator(nil, testEvent("a1", 1), testEvent("a4", 4), testEvent("a5", 5)),
+		sliceIterator(nil, testEvent("b2", 2), testEvent("b6", 6)),
+		sliceIterator(nil),
+		sliceIterator(nil, testEvent("c3", 3), testEvent("c7", 7)),
+	)
+	defer merged.Close()
+
+	ids, err := readIDs(t, merged)
+	if err != io.EOF {
+		t.Errorf("error = %v, want EOF", err)
+	}
+	if want := "[a1 b2 c3 a4 a5 b6 c7]"; fmt.Sprint(ids) != want {
+		t.Errorf("events = %v, want %s", ids, want)
+	}
+}
+
+func TestMergeIteratorsError(t *testing.T) {
+	exited := make(chan struct{})
+	merged := MergeIterators(context.Background(), 0,
+		endlessIterator(0, exited),
+		sliceIterator(errPump, testEvent("failing", 1)),
+	)
+	defer merged.Close()
+
+	if _, err := readIDs(t, merged); err != errPump {
+		t.Errorf("error = %v, want %v", err, errPump)
+	}
+	if err := merged.Err(); err != errPump {
+		t.Errorf("Err() = %v, want %v", err, errPump)
+	}
+
+	// the failure closed the other sources
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("endless source still running after the merge failed")
+	}
+}
+
+func TestMergeIteratorsClose(t *testing.T) {
+	first := make(chan struct{})
+	second := make(chan struct{})
+	merged := MergeIterators(context.Background(), 0, endlessIterator(0, first), endlessIterator(0, second))
+
+	for i := 0; i < 5; i++ {
+		if _, err := merged.Next(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	merged.Close()
+
+	for _, exited := range []chan struct{}{first, second} {
+		select {
+		case <-exited:
+		default:
+			t.Fatal("source still running after the merged iterator was closed")
+		}
+	}
+}
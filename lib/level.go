@@ -0,0 +1,195 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+// maxFilterPatternSize is the longest filter pattern CloudWatch accepts
+const maxFilterPatternSize = 1024
+
+// LevelRange keeps the events whose level is between two severities.
+//
+// Events without a level count as INFO.  This includes the plain text lines no
+// parser understands, which are INFO events, and ecs-logs events with no
+// level.
+type LevelRange struct {
+	// Min is the least severe level kept and Max the most severe, NONE
+	// leaves that side of the range open
+	Min ecslogs.Level
+	Max ecslogs.Level
+}
+
+// ParseLevelRange parses `MIN..MAX`, where either side can be omitted, or a
+// single level, which is the minimum severity.  `WARN` and `WARN..` keep WARN,
+// ERROR, CRIT, ALERT and EMERG, `DEBUG..INFO` keeps DEBUG and INFO.
+func ParseLevelRange(s string) (LevelRange, error) {
+	var r LevelRange
+	min, max := s, ""
+	if ix := strings.Index(s, ".."); ix >= 0 {
+		min, max = s[:ix], s[ix+2:]
+		if min == "" && max == "" {
+			return r, fmt.Errorf("Invalid level range '%s', expected MIN..MAX", s)
+		}
+	}
+
+	var err error
+	if min != "" {
+		if r.Min, err = ecslogs.ParseLevel(min); err != nil {
+			return r, fmt.Errorf("Invalid level '%s'", min)
+		}
+	}
+	if max != "" {
+		if r.Max, err = ecslogs.ParseLevel(max); err != nil {
+			return r, fmt.Errorf("Invalid level '%s'", max)
+		}
+	}
+	if r.Min != ecslogs.NONE && r.Max != ecslogs.NONE && r.Max > r.Min {
+		return r, fmt.Errorf("Invalid level range '%s', %s is more severe than %s", s, r.Min, r.Max)
+	}
+	return r, nil
+}
+
+// Match reports whether level is in the range, NONE counting as INFO
+func (r LevelRange) Match(level ecslogs.Level) bool {
+	if level == ecslogs.NONE {
+		level = ecslogs.INFO
+	}
+	// more severe levels are lower
+	return (r.Min == ecslogs.NONE || level <= r.Min) && (r.Max == ecslogs.NONE || level >= r.Max)
+}
+
+// levels returns the levels in the range, most severe first
+func (r LevelRange) levels() []ecslogs.Level {
+	levels := []ecslogs.Level{}
+	for level := ecslogs.EMERG; level <= ecslogs.TRACE; level++ {
+		if r.Match(level) && level != ecslogs.NONE {
+			levels = append(levels, level)
+		}
+	}
+	return levels
+}
+
+// FilterPattern translates the range into a JSON filter pattern on $.level
+// matching the events written by parser.  It returns false when filtering
+// server side could drop events the range keeps:
+//
+//   - when the range includes INFO, since events without a level or in plain
+//     text count as INFO and a JSON pattern drops them
+//   - when the parser isn't one of the JSON parsers, ecs-logs, zap, logrus
+//     and bunyan, including when the format is detected
+//   - when the pattern would be too long for CloudWatch
+func (r LevelRange) FilterPattern(parser string) (string, bool) {
+	if r.Match(ecslogs.INFO) {
+		return "", false
+	}
+	levels := r.levels()
+
+	var terms []string
+	switch parser {
+	case "ecs-logs":
+		for _, level := range levels {
+			terms = append(terms, fmt.Sprintf("$.level = %q", level.String()))
+		}
+	case "zap", "logrus":
+		for _, level := range levels {
+			for _, name := range levelNamesOf(level) {
+				terms = append(terms, fmt.Sprintf("$.level = %q", name), fmt.Sprintf("$.level = %q", strings.ToUpper(name)))
+			}
+		}
+	case "bunyan":
+		min, max, ok := bunyanRange(levels)
+		if !ok {
+			return "", false
+		}
+		switch {
+		case min == "":
+			terms = append(terms, "$.level <= "+max)
+		case max == "":
+			terms = append(terms, "$.level > "+min)
+		default:
+			terms = append(terms, fmt.Sprintf("($.level > %s && $.level <= %s)", min, max))
+		}
+	default:
+		return "", false
+	}
+	pattern := "{ " + strings.Join(terms, " || ") + " }"
+	if len(terms) == 0 || len(pattern) > maxFilterPatternSize {
+		return "", false
+	}
+	return pattern, true
+}
+
+// levelNamesOf returns the names parsers accept for level, in order
+func levelNamesOf(level ecslogs.Level) []string {
+	names := []string{}
+	for name, l := range levelNames {
+		if l == level {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bunyanRange returns the bounds of the numeric bunyan levels mapped onto
+// levels, which are contiguous.  An empty bound is open.
+func bunyanRange(levels []ecslogs.Level) (string, string, bool) {
+	type bounds struct{ min, max string }
+	ranges := map[ecslogs.Level]bounds{}
+	previous := ""
+	for _, bunyan := range bunyanLevels {
+		max := strconv.FormatFloat(bunyan.max, 'f', -1, 64)
+		ranges[bunyan.level] = bounds{previous, max}
+		previous = max
+	}
+	ranges[ecslogs.ALERT] = bounds{previous, ""}
+
+	var min, max string
+	found := false
+	// levels go from the most severe, with the highest numbers, to the least
+	for _, level := range levels {
+		b, ok := ranges[level]
+		if !ok {
+			continue
+		}
+		if !found {
+			max = b.max
+			found = true
+		}
+		min = b.min
+	}
+	return min, max, found
+}
+
+// CombineFilterPatterns returns a pattern matching the events both patterns
+// match.  Only JSON patterns can be combined, it returns false when one of
+// them isn't or when the result is too long for CloudWatch.  An empty pattern
+// matches everything.
+func CombineFilterPatterns(a string, b string) (string, bool) {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	switch {
+	case a == "":
+		return b, true
+	case b == "":
+		return a, true
+	}
+
+	inner := func(pattern string) (string, bool) {
+		if !strings.HasPrefix(pattern, "{") || !strings.HasSuffix(pattern, "}") {
+			return "", false
+		}
+		return strings.TrimSpace(pattern[1 : len(pattern)-1]), true
+	}
+	innerA, okA := inner(a)
+	innerB, okB := inner(b)
+	if !okA || !okB {
+		return "", false
+	}
+	combined := fmt.Sprintf("{ (%s) && (%s) }", innerA, innerB)
+	return combined, len(combined) <= maxFilterPatternSize
+}
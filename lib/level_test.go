@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLevelRangeFilterPattern(t *testing.T) {
+	tests := []struct {
+		parser string
+		levels string
+		want   string
+	}{
+		{"ecs-logs", "WARN", `{ $.level = "EMERG" || $.level = "ALERT" || $.level = "CRIT" || $.level = "ERROR" || $.level = "WARN" }`},
+		{"ecs-logs", "NOTICE", `{ $.level = "EMERG" || $.level = "ALERT" || $.level = "CRIT" || $.level = "ERROR" || $.level = "WARN" || $.level = "NOTICE" }`},
+		{"ecs-logs", "TRACE..DEBUG", `{ $.level = "DEBUG" || $.level = "TRACE" }`},
+
+		// every name the parser maps onto a level, lower and upper cased
+		{"zap", "ERROR..ERROR", `{ $.level = "eror" || $.level = "EROR" || $.level = "err" || $.level = "ERR" || $.level = "error" || $.level = "ERROR" }`},
+		{"logrus", "CRIT..ALERT", `{ $.level = "alert" || $.level = "ALERT" || $.level = "fatal" || $.level = "FATAL" || $.level = "crit" || $.level = "CRIT" || $.level = "critical" || $.level = "CRITICAL" || $.level = "dpanic" || $.level = "DPANIC" || $.level = "panic" || $.level = "PANIC" }`},
+		{"logrus", "TRACE..TRACE", `{ $.level = "trace" || $.level = "TRACE" }`},
+
+		// numeric ranges, 30 is INFO, 40 WARN and 50 ERROR
+		{"bunyan", "WARN", `{ $.level > 30 }`},
+		{"bunyan", "ERROR", `{ $.level > 40 }`},
+		{"bunyan", "WARN..WARN", `{ ($.level > 30 && $.level <= 40) }`},
+		{"bunyan", "WARN..ERROR", `{ ($.level > 30 && $.level <= 50) }`},
+		{"bunyan", "TRACE..DEBUG", `{ $.level <= 20 }`},
+		{"bunyan", "TRACE..TRACE", `{ $.level <= 10 }`},
+		// no bunyan level maps onto CRIT
+		{"bunyan", "CRIT..CRIT", ""},
+
+		// the range includes INFO, events without a level are kept
+		{"ecs-logs", "INFO", ""},
+		{"ecs-logs", "..WARN", ""},
+		{"zap", "DEBUG", ""},
+		{"bunyan", "TRACE..ERROR", ""},
+
+		// the parser isn't known to write JSON levels
+		{"auto", "WARN", ""},
+		{"logfmt", "WARN", ""},
+		{"text", "WARN", ""},
+		{"", "WARN", ""},
+	}
+	for _, test := range tests {
+		r, err := ParseLevelRange(test.levels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pattern, ok := r.FilterPattern(test.parser)
+		if pattern != test.want || ok != (test.want != "") {
+			t.Errorf("%s %s: pattern = %q %t, want %q", test.parser, test.levels, pattern, ok, test.want)
+			continue
+		}
+		if ok {
+			// CloudWatch has to accept it
+			if _, err := ParseFilterPattern(pattern); err != nil {
+				t.Errorf("%s %s: %s", test.parser, test.levels, err)
+			}
+		}
+	}
+
+	// no range at all includes INFO
+	if pattern, ok := (LevelRange{}).FilterPattern("ecs-logs"); ok {
+		t.Errorf("empty range: pattern = %q", pattern)
+	}
+}
+
+func TestBunyanRange(t *testing.T) {
+	r, _ := ParseLevelRange("EMERG..EMERG")
+	if min, max, ok := bunyanRange(r.levels()); ok {
+		t.Errorf("bunyanRange(EMERG) = %q %q, want none", min, max)
+	}
+	// ALERT is anything above ERROR
+	r, _ = ParseLevelRange("ALERT")
+	if min, max, ok := bunyanRange(r.levels()); !ok || min != "50" || max != "" {
+		t.Errorf("bunyanRange(ALERT) = %q %q %t, want 50 and open", min, max, ok)
+	}
+}
+
+func TestCombineFilterPatterns(t *testing.T) {
+	// short enough on its own, too long once combined
+	long := `{ $.message = "` + strings.Repeat("x", maxFilterPatternSize-30) + `" }`
+
+	tests := []struct {
+		a, b string
+		want string
+		ok   bool
+	}{
+		{"", "", "", true},
+		{"", `{ $.level = "ERROR" }`, `{ $.level = "ERROR" }`, true},
+		{` { $.level = "ERROR" } `, "", `{ $.level = "ERROR" }`, true},
+		{`{ $.level = "ERROR" }`, `{ $.status >= 500 || $.status = 404 }`, `{ ($.level = "ERROR") && ($.status >= 500 || $.status = 404) }`, true},
+		// only JSON patterns can be combined
+		{"ERROR", `{ $.level = "ERROR" }`, "", false},
+		{`{ $.level = "ERROR" }`, "[ip, user, status=5*]", "", false},
+		{"", "ERROR", "ERROR", true},
+		{`{ $.level = "ERROR" }`, long, "", false},
+	}
+	for _, test := range tests {
+		got, ok := CombineFilterPatterns(test.a, test.b)
+		if ok != test.ok || (ok && got != test.want) {
+			t.Errorf("CombineFilterPatterns(%q, %q) = %q %t, want %q %t", test.a, test.b, got, ok, test.want, test.ok)
+		}
+	}
+}
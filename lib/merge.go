@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"context"
+	"time"
+)
+
+type sourcedEvent struct {
+	source int
+	event  Event
+	ok     bool
+}
+
+type bufferedEvent struct {
+	event   Event
+	arrived time.Time
+}
+
+// MergeEvents reads events from several time ordered channels and returns a
+// channel yielding all of them ordered by creation time.  The returned channel
+// is closed once every source is closed or ctx is done.
+//
+// With a zero window an event is only emitted once every open source has an
+// event buffered, which gives a strict ordering but stalls on idle sources.
+// When following, pass a non zero window: buffered events are released in
+// order once they have waited that long for the other sources.
+func MergeEvents(ctx context.Context, window time.Duration, sources ...<-chan Event) <-chan Event {
+	out := make(chan Event)
+	go mergeEvents(ctx, window, out, sources)
+	return out
+}
+
+func mergeEvents(ctx context.Context, window time.Duration, out chan<- Event, sources []<-chan Event) {
+	defer close(out)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	in := make(chan sourcedEvent)
+	for ix, source := range sources {
+		go func(ix int, source <-chan Event) {
+			for event := range source {
+				select {
+				case in <- sourcedEvent{source: ix, event: event, ok: true}:
+				case <-done:
+					return
+				}
+			}
+			select {
+			case in <- sourcedEvent{source: ix}:
+			case <-done:
+			}
+		}(ix, source)
+	}
+
+	queues := make([][]bufferedEvent, len(sources))
+	closed := make([]bool, len(sources))
+	open := len(sources)
+
+	for {
+		// emit everything that is allowed to go out
+		for {
+			ix, ok := nextMergedSource(queues, closed, window, time.Now())
+			if !ok {
+				break
+			}
+			select {
+			case out <- queues[ix][0].event:
+				queues[ix] = queues[ix][1:]
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if open == 0 {
+			return
+		}
+
+		var timeout <-chan time.Time
+		if window > 0 {
+			if oldest, ok := oldestArrival(queues); ok {
+				timeout = time.After(oldest.Add(window).Sub(time.Now()))
+			}
+		}
+
+		select {
+		case e := <-in:
+			if e.ok {
+				queues[e.source] = append(queues[e.source], bufferedEvent{event: e.event, arrived: time.Now()})
+			} else {
+				closed[e.source] = true
+				open--
+			}
+		case <-timeout:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// nextMergedSource returns the source holding the oldest buffered event if it
+// can be emitted
+func nextMergedSource(queues [][]bufferedEvent, closed []bool, window time.Duration, now time.Time) (int, bool) {
+	next := -1
+	complete := true
+	for ix, queue := range queues {
+		if len(queue) == 0 {
+			if !closed[ix] {
+				complete = false
+			}
+			continue
+		}
+		if next < 0 || queue[0].event.CreationTime.Before(queues[next][0].event.CreationTime) {
+			next = ix
+		}
+	}
+
+	if next < 0 {
+		return 0, false
+	}
+	if complete {
+		return next, true
+	}
+	if window > 0 {
+		if oldest, _ := oldestArrival(queues); now.Sub(oldest) >= window {
+			return next, true
+		}
+	}
+	return 0, false
+}
+
+func oldestArrival(queues [][]bufferedEvent) (time.Time, bool) {
+	var oldest time.Time
+	found := false
+	for _, queue := range queues {
+		if len(queue) > 0 && (!found || queue[0].arrived.Before(oldest)) {
+			oldest = queue[0].arrived
+			found = true
+		}
+	}
+	return oldest, found
+}
@@ -0,0 +1,207 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultMultilineTimeout is how long a record waits for more lines before
+// being printed
+const DefaultMultilineTimeout = 2 * time.Second
+
+// continuationPrefixes start the lines of Java and Python stack traces that
+// aren't indented
+var continuationPrefixes = []string{
+	"at ",
+	"Caused by:",
+	"... ",
+	"Traceback (",
+	"During handling of the above exception",
+	"The above exception was the direct cause",
+}
+
+// Joiner reassembles records logged over several lines, such as stack traces,
+// which CloudWatch stores as separate events.  Lines are joined per stream,
+// and records come out in the order of their first line.
+//
+// Without a start pattern, lines that are indented or start like a stack
+// trace continue the record of their stream, as does the exception line
+// ending a Python traceback.  With a start pattern, such as a timestamp, every
+// line not matching it continues the record of its stream.
+//
+// A record is complete once its stream has a new record, or once no line was
+// added to it for the timeout, either in wall clock time or in event time.
+type Joiner struct {
+	start   *regexp.Regexp
+	timeout time.Duration
+	queue   []*joinedRecord
+	open    map[string]*joinedRecord
+}
+
+type joinedRecord struct {
+	event     Event
+	last      time.Time
+	deadline  time.Time
+	traceback bool
+	done      bool
+}
+
+// NewJoiner returns a joiner using start, when not empty, as the pattern of
+// the first line of records.  A zero timeout is DefaultMultilineTimeout.
+func NewJoiner(start string, timeout time.Duration) (*Joiner, error) {
+	j := &Joiner{timeout: timeout, open: map[string]*joinedRecord{}}
+	if j.timeout <= 0 {
+		j.timeout = DefaultMultilineTimeout
+	}
+	if start != "" {
+		var err error
+		if j.start, err = regexp.Compile(start); err != nil {
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// Add joins e to the record of its stream or starts a new record with it,
+// now being the time e was received.  It returns the records completed.
+func (j *Joiner) Add(e Event, now time.Time) []Event {
+	key := e.Group + "\x00" + e.Stream
+	if r, ok := j.open[key]; ok {
+		if j.continues(r, e.Message) {
+			r.event.Message += "\n" + e.Message
+			r.event.Size += e.Size
+			r.event.Joined = append(r.event.Joined, JoinedEvent{ID: e.ID, CreationTime: e.CreationTime})
+			r.last = e.Time
+			r.deadline = now.Add(j.timeout)
+			return j.ready(e.Time, now)
+		}
+		r.done = true
+	}
+
+	r := &joinedRecord{
+		event:     e,
+		last:      e.Time,
+		deadline:  now.Add(j.timeout),
+		traceback: strings.HasPrefix(e.Message, "Traceback ("),
+	}
+	j.open[key] = r
+	j.queue = append(j.queue, r)
+	return j.ready(e.Time, now)
+}
+
+// Expire returns the records completed by the timeout at now
+func (j *Joiner) Expire(now time.Time) []Event {
+	return j.ready(time.Time{}, now)
+}
+
+// Flush completes every record and returns them
+func (j *Joiner) Flush() []Event {
+	for _, r := range j.queue {
+		r.done = true
+	}
+	return j.ready(time.Time{}, time.Time{})
+}
+
+// Deadline returns when the next record times out, if any is waiting
+func (j *Joiner) Deadline() (time.Time, bool) {
+	var deadline time.Time
+	for _, r := range j.open {
+		if deadline.IsZero() || r.deadline.Before(deadline) {
+			deadline = r.deadline
+		}
+	}
+	return deadline, !deadline.IsZero()
+}
+
+// continues reports whether line continues the record r
+func (j *Joiner) continues(r *joinedRecord, line string) bool {
+	if j.start != nil {
+		return !j.start.MatchString(line)
+	}
+
+	if line == "" {
+		return false
+	}
+	if line[0] == ' ' || line[0] == '\t' {
+		return true
+	}
+	for _, prefix := range continuationPrefixes {
+		if strings.HasPrefix(line, prefix) {
+			if prefix == "Traceback (" {
+				r.traceback = true
+			}
+			return true
+		}
+	}
+	if r.traceback {
+		// the exception line ends the traceback
+		r.traceback = false
+		return true
+	}
+	return false
+}
+
+// ready completes the records timed out at eventTime or now, which are
+// ignored when zero, and returns the completed records at the head of the
+// queue
+func (j *Joiner) ready(eventTime time.Time, now time.Time) []Event {
+	for key, r := range j.open {
+		expired := (!now.IsZero() && !now.Before(r.deadline)) ||
+			(!eventTime.IsZero() && eventTime.Sub(r.last) > j.timeout)
+		if r.done || expired {
+			r.done = true
+			delete(j.open, key)
+		}
+	}
+
+	var events []Event
+	for len(j.queue) > 0 && j.queue[0].done {
+		events = append(events, j.queue[0].event)
+		j.queue[0] = nil
+		j.queue = j.queue[1:]
+	}
+	return events
+}
+
+// JoinLines returns an iterator yielding the records reassembled by j from
+// the events of source.  Records waiting for more lines are completed by the
+// timeout of j even when source has no new event, so it can be used when
+// following streams.  Closing the iterator closes source.
+func JoinLines(ctx context.Context, source *EventIterator, j *Joiner) *EventIterator {
+	return newEventIterator(ctx, func(ctx context.Context, emit func(Event) error) error {
+		defer source.Close()
+
+		emitAll := func(events []Event) error {
+			for _, event := range events {
+				if err := emit(event); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		for {
+			wait, cancelWait := ctx, context.CancelFunc(func() {})
+			if deadline, ok := j.Deadline(); ok {
+				wait, cancelWait = context.WithDeadline(ctx, deadline)
+			}
+			event, err := source.Next(wait)
+			cancelWait()
+
+			switch {
+			case err == nil:
+				err = emitAll(j.Add(event, time.Now()))
+			case err == context.DeadlineExceeded && ctx.Err() == nil:
+				err = emitAll(j.Expire(time.Now()))
+			case err == io.EOF:
+				return emitAll(j.Flush())
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
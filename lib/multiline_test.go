@@ -0,0 +1,255 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// joinAll adds lines to j as the events of stream a millisecond apart, and
+// returns the messages of the records with the lines separated by |
+func joinAll(j *Joiner, stream string, lines ...string) []string {
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	records := []string{}
+	collect := func(events []Event) {
+		for _, e := range events {
+			records = append(records, strings.Replace(e.Message, "\n", "|", -1))
+		}
+	}
+	for ix, line := range lines {
+		e := Event{Stream: stream, ID: fmt.Sprint(ix)}
+		e.Message = line
+		e.Time = start.Add(time.Duration(ix) * time.Millisecond)
+		collect(j.Add(e, e.Time))
+	}
+	collect(j.Flush())
+	return records
+}
+
+func TestJoinerContinuation(t *testing.T) {
+	tests := []struct {
+		name  string
+		start string
+		lines []string
+		want  []string
+	}{
+		{
+			name:  "plain lines",
+			lines: []string{"a", "b", "", "c"},
+			want:  []string{"a", "b", "", "c"},
+		},
+		{
+			name:  "indented lines",
+			lines: []string{"a", "  b", "\tc", "d"},
+			want:  []string{"a|  b|\tc", "d"},
+		},
+		{
+			name: "java stack trace",
+			lines: []string{
+				"Exception in thread \"main\" java.lang.IllegalStateException: boom",
+				"at com.example.Main.run(Main.java:12)",
+				"Caused by: java.io.IOException: closed",
+				"... 3 more",
+				"next",
+			},
+			want: []string{
+				"Exception in thread \"main\" java.lang.IllegalStateException: boom|at com.example.Main.run(Main.java:12)|Caused by: java.io.IOException: closed|... 3 more",
+				"next",
+			},
+		},
+		{
+			// the exception line ends the traceback
+			name: "python traceback",
+			lines: []string{
+				"ERROR request failed",
+				"Traceback (most recent call last):",
+				`  File "app.py", line 3, in <module>`,
+				"KeyError: 'id'",
+				"next",
+			},
+			want: []string{
+				`ERROR request failed|Traceback (most recent call last):|  File "app.py", line 3, in <module>|KeyError: 'id'`,
+				"next",
+			},
+		},
+		{
+			name:  "traceback starting a record",
+			lines: []string{"Traceback (most recent call last):", `  File "app.py", line 3`, "ValueError: bad", "next"},
+			want:  []string{`Traceback (most recent call last):|  File "app.py", line 3|ValueError: bad`, "next"},
+		},
+		{
+			name:  "start pattern",
+			start: `^\d{4}-\d{2}-\d{2} `,
+			lines: []string{"2017-03-01 ERROR failed", "java.io.IOException: closed", "  at Main.run", "", "2017-03-01 INFO done"},
+			want:  []string{"2017-03-01 ERROR failed|java.io.IOException: closed|  at Main.run|", "2017-03-01 INFO done"},
+		},
+		{
+			// only the pattern starts records
+			name:  "start pattern with indented first lines",
+			start: `^\d{4}-\d{2}-\d{2} `,
+			lines: []string{"  orphan", "continued", "2017-03-01 INFO a", "2017-03-01 INFO b"},
+			want:  []string{"  orphan|continued", "2017-03-01 INFO a", "2017-03-01 INFO b"},
+		},
+	}
+	for _, test := range tests {
+		j, err := NewJoiner(test.start, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := joinAll(j, "stream", test.lines...); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", test.want) {
+			t.Errorf("%s: records = %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	if _, err := NewJoiner("(", 0); err == nil {
+		t.Error("expected an error for an invalid start pattern")
+	}
+}
+
+func TestJoinerJoined(t *testing.T) {
+	j, _ := NewJoiner("", time.Minute)
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	for ix, line := range []string{"panic: boom", "\tat main.go:12", "\tat main.go:20"} {
+		e := Event{Stream: "stream", ID: fmt.Sprint(ix), Size: 10, CreationTime: start.Add(time.Duration(ix) * time.Millisecond)}
+		e.Message = line
+		j.Add(e, start)
+	}
+	records := j.Flush()
+	if len(records) != 1 {
+		t.Fatalf("%d records, want 1", len(records))
+	}
+	r := records[0]
+	if r.ID != "0" || r.Size != 30 || len(r.Joined) != 2 || r.Joined[1].ID != "2" || !r.Joined[1].CreationTime.Equal(start.Add(2*time.Millisecond)) {
+		t.Errorf("record %s of %d bytes joined with %v", r.ID, r.Size, r.Joined)
+	}
+}
+
+func TestJoinerStreams(t *testing.T) {
+	j, _ := NewJoiner("", time.Minute)
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	add := func(group string, stream string, message string) string {
+		e := Event{Group: group, Stream: stream}
+		e.Message = message
+		e.Time = now
+		records := []string{}
+		for _, r := range j.Add(e, now) {
+			records = append(records, strings.Replace(r.Message, "\n", "|", -1))
+		}
+		return fmt.Sprintf("%q", records)
+	}
+
+	steps := []struct {
+		group, stream, message string
+		want                   string
+	}{
+		{"g", "a", "panic: boom", `[]`},
+		{"g", "b", "hello", `[]`},
+		// the same stream name in another group is another stream
+		{"h", "a", "other", `[]`},
+		{"g", "a", "\tat main.go:12", `[]`},
+		{"g", "b", "  world", `[]`},
+		{"h", "a", "\tcontinued", `[]`},
+		// records come out in the order of their first line, the open record
+		// of b holds the next ones back
+		{"g", "a", "next a", `["panic: boom|\tat main.go:12"]`},
+		{"g", "b", "next b", `["hello|  world"]`},
+		{"h", "a", "next other", `["other|\tcontinued"]`},
+	}
+	for ix, step := range steps {
+		if got := add(step.group, step.stream, step.message); got != step.want {
+			t.Errorf("step %d (%s/%s %q): records = %s, want %s", ix, step.group, step.stream, step.message, got, step.want)
+		}
+	}
+	if records := j.Flush(); len(records) != 3 {
+		t.Errorf("%d records flushed, want 3", len(records))
+	}
+}
+
+func TestJoinerTimeout(t *testing.T) {
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	line := func(stream string, message string, seconds int) Event {
+		e := Event{Stream: stream}
+		e.Message = message
+		e.Time = now.Add(time.Duration(seconds) * time.Second)
+		return e
+	}
+
+	// wall clock, each line pushes the deadline back
+	j, _ := NewJoiner("", time.Second)
+	j.Add(line("a", "panic: boom", 0), now)
+	j.Add(line("a", "\tat main.go:12", 0), now.Add(800*time.Millisecond))
+	if deadline, ok := j.Deadline(); !ok || !deadline.Equal(now.Add(1800*time.Millisecond)) {
+		t.Errorf("deadline = %s %t, want %s", deadline, ok, now.Add(1800*time.Millisecond))
+	}
+	if records := j.Expire(now.Add(1500 * time.Millisecond)); len(records) != 0 {
+		t.Errorf("records expired before the deadline: %v", records)
+	}
+	if records := j.Expire(now.Add(1800 * time.Millisecond)); len(records) != 1 {
+		t.Errorf("%d records expired at the deadline, want 1", len(records))
+	}
+	if deadline, ok := j.Deadline(); ok {
+		t.Errorf("deadline %s with no record waiting", deadline)
+	}
+
+	// event time, when reading old events faster than the timeout
+	j, _ = NewJoiner("", time.Second)
+	j.Add(line("a", "panic: boom", 0), now)
+	if records := j.Add(line("b", "hello", 1), now); len(records) != 0 {
+		t.Errorf("records completed within the timeout: %v", records)
+	}
+	records := j.Add(line("b", "later", 3), now)
+	if len(records) != 2 || records[0].Message != "panic: boom" || records[1].Message != "hello" {
+		t.Errorf("records completed by event time = %v, want panic: boom and hello", records)
+	}
+	// a continuation joins however late it comes
+	if records := j.Add(line("b", "  continued", 10), now); len(records) != 0 {
+		t.Errorf("records = %v, want later to be continued", records)
+	}
+}
+
+func TestJoinLines(t *testing.T) {
+	lines := []Event{}
+	for ix, message := range []string{"panic: boom", "\tat main.go:12", "next", "  continued", "last"} {
+		e := testEvent(fmt.Sprintf("e%d", ix), ix)
+		e.Message = message
+		lines = append(lines, e)
+	}
+
+	j, _ := NewJoiner("", time.Minute)
+	ids, err := readIDs(t, JoinLines(context.Background(), sliceIterator(nil, lines...), j))
+	if err != io.EOF || fmt.Sprint(ids) != "[e0 e2 e4]" {
+		t.Errorf("records = %v %v, want [e0 e2 e4]", ids, err)
+	}
+
+	j, _ = NewJoiner("", time.Minute)
+	if _, err := readIDs(t, JoinLines(context.Background(), sliceIterator(errPump, lines...), j)); err != errPump {
+		t.Errorf("error = %v, want %v", err, errPump)
+	}
+}
+
+func TestJoinLinesTimeout(t *testing.T) {
+	// the source goes quiet after a line, as when following
+	source := newEventIterator(context.Background(), func(ctx context.Context, emit func(Event) error) error {
+		e := testEvent("e0", 0)
+		e.Message = "panic: boom"
+		if err := emit(e); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	j, _ := NewJoiner("", 20*time.Millisecond)
+	it := JoinLines(context.Background(), source, j)
+	defer it.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	e, err := it.Next(ctx)
+	if err != nil || e.Message != "panic: boom" {
+		t.Errorf("record = %q %v, want the line completed by the timeout", e.Message, err)
+	}
+}
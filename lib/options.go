@@ -0,0 +1,254 @@
+package lib
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// Defaults used for zero valued ReaderConfig fields
+const (
+	// DefaultMaxStreams is the maximum number of streams you can give to a
+	// filter call
+	DefaultMaxStreams = 100
+
+	// DefaultPollInterval is how long the reader waits between filter calls
+	DefaultPollInterval = 100 * time.Millisecond
+
+	// DefaultDedupWindow is how far before the newest event seen a following
+	// reader restarts its filter calls, to pick up events that arrive late or
+	// slightly out of order.  Events ingested with a timestamp older than
+	// that are missed, see WithDedupWindow.
+	DefaultDedupWindow = 5 * time.Second
+
+	// DefaultDiscoveryInterval is how often a following reader looks for new
+	// streams matching its prefix
+	DefaultDiscoveryInterval = 30 * time.Second
+
+	// DefaultIdleStreamTimeout is how long a stream can go without events
+	// before a following reader stops filtering on it
+	DefaultIdleStreamTimeout = 15 * time.Minute
+)
+
+// ReaderConfig carries the settings of a CloudwatchLogsReader.  Zero values
+// are replaced by the matching defaults.
+type ReaderConfig struct {
+	// Group is the name of the log group to read from
+	Group string
+
+	// StreamPrefix optionally restricts the reader to streams starting with
+	// this prefix
+	StreamPrefix string
+
+	// Start and End delimit the time window of events returned by the reader.
+	// A zero End means now, or no end when following.
+	Start time.Time
+	End   time.Time
+
+	// FilterPattern is a CloudWatch Logs filter pattern applied server side,
+	// see ParseFilterPattern
+	FilterPattern string
+
+	// Resume, if set, overrides Start with a checkpointed position.  Events
+	// before the position and the events it lists are skipped.
+	Resume *CheckpointPosition
+
+	// Parser turns the messages read into events, the format of every
+	// stream is detected if nil
+	Parser *EventParser
+
+	// MaxStreams is the maximum number of streams given to describe/filter
+	// calls
+	MaxStreams int
+
+	// PollInterval is how long the reader waits between filter calls
+	PollInterval time.Duration
+
+	// DedupWindow is how far before the newest event seen a following reader
+	// restarts its filter calls.  Only the IDs of events within that window
+	// are remembered to skip duplicates, and events whose timestamp is
+	// further behind the newest event when they are ingested are missed.
+	DedupWindow time.Duration
+
+	// Parallelism is the number of concurrent filter calls used to fetch a
+	// bounded time window.  The window is split in time shards whose events
+	// are emitted in the same order as a single sequential fetch.  It has no
+	// effect when following.
+	Parallelism int
+
+	// DiscoveryInterval is how often a following reader with a stream prefix
+	// looks for new matching streams
+	DiscoveryInterval time.Duration
+
+	// IdleStreamTimeout is how long a stream can go without events before a
+	// following reader stops filtering on it
+	IdleStreamTimeout time.Duration
+
+	// OnStreamsChanged, if set, is called when streams join or leave the set
+	// followed by the reader
+	OnStreamsChanged func(group string, joined []string, left []string)
+
+	// Throttle configures the client side rate limit and the retries of
+	// throttled or failing calls
+	Throttle ThrottleConfig
+
+	// AWSConfig is merged on top of the default client configuration
+	AWSConfig *aws.Config
+
+	// Session is used to create the client, a new session is created from
+	// the environment if nil
+	Session *session.Session
+
+	// Client overrides the CloudWatch Logs client, AWSConfig and Session
+	// are ignored when set.  Clients other than a ThrottledClient are wrapped
+	// in one configured by Throttle.
+	Client CloudwatchLogsClient
+
+	// Context bounds the calls made while creating the reader, such as
+	// looking up the log group.  It defaults to context.Background().
+	Context context.Context
+}
+
+// ReaderOption is a functional option that changes a ReaderConfig
+type ReaderOption func(*ReaderConfig)
+
+// WithFilterPattern sets a CloudWatch Logs filter pattern applied server side
+func WithFilterPattern(pattern string) ReaderOption {
+	return func(c *ReaderConfig) { c.FilterPattern = pattern }
+}
+
+// WithResume resumes reading from a checkpointed position, ignoring the start
+// time.  A nil position leaves the start time unchanged.
+func WithResume(position *CheckpointPosition) ReaderOption {
+	return func(c *ReaderConfig) { c.Resume = position }
+}
+
+// WithParser sets the parser turning messages into events
+func WithParser(parser *EventParser) ReaderOption {
+	return func(c *ReaderConfig) { c.Parser = parser }
+}
+
+// WithMaxStreams sets the maximum number of streams for describe/filter calls
+func WithMaxStreams(max int) ReaderOption {
+	return func(c *ReaderConfig) { c.MaxStreams = max }
+}
+
+// WithPollInterval sets how long the reader waits between filter calls
+func WithPollInterval(interval time.Duration) ReaderOption {
+	return func(c *ReaderConfig) { c.PollInterval = interval }
+}
+
+// WithDedupWindow sets how far before the newest event seen a following
+// reader restarts its filter calls.  A wider window picks up events arriving
+// later, at the cost of filtering the window again on every poll.
+func WithDedupWindow(window time.Duration) ReaderOption {
+	return func(c *ReaderConfig) { c.DedupWindow = window }
+}
+
+// WithParallelism sets the number of concurrent filter calls used to fetch a
+// bounded time window
+func WithParallelism(parallelism int) ReaderOption {
+	return func(c *ReaderConfig) { c.Parallelism = parallelism }
+}
+
+// WithDiscoveryInterval sets how often a following reader looks for new
+// streams
+func WithDiscoveryInterval(interval time.Duration) ReaderOption {
+	return func(c *ReaderConfig) { c.DiscoveryInterval = interval }
+}
+
+// WithIdleStreamTimeout sets how long a stream can go without events before a
+// following reader stops filtering on it
+func WithIdleStreamTimeout(timeout time.Duration) ReaderOption {
+	return func(c *ReaderConfig) { c.IdleStreamTimeout = timeout }
+}
+
+// WithOnStreamsChanged sets a callback invoked when streams join or leave the
+// set followed by the reader
+func WithOnStreamsChanged(fn func(group string, joined []string, left []string)) ReaderOption {
+	return func(c *ReaderConfig) { c.OnStreamsChanged = fn }
+}
+
+// WithThrottle sets the client side rate limit and retry configuration
+func WithThrottle(throttle ThrottleConfig) ReaderOption {
+	return func(c *ReaderConfig) { c.Throttle = throttle }
+}
+
+// WithAWSConfig sets configuration merged on top of the default client
+// configuration
+func WithAWSConfig(config *aws.Config) ReaderOption {
+	return func(c *ReaderConfig) { c.AWSConfig = config }
+}
+
+// WithSession sets the AWS session used to create the client
+func WithSession(sess *session.Session) ReaderOption {
+	return func(c *ReaderConfig) { c.Session = sess }
+}
+
+// WithClient sets the CloudWatch Logs client used by the reader
+func WithClient(client CloudwatchLogsClient) ReaderOption {
+	return func(c *ReaderConfig) { c.Client = client }
+}
+
+// WithContext sets the context bounding the calls made while creating the
+// reader
+func WithContext(ctx context.Context) ReaderOption {
+	return func(c *ReaderConfig) { c.Context = ctx }
+}
+
+func (config ReaderConfig) withDefaults() ReaderConfig {
+	if config.MaxStreams <= 0 {
+		config.MaxStreams = DefaultMaxStreams
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+	if config.DedupWindow <= 0 {
+		config.DedupWindow = DefaultDedupWindow
+	}
+	if config.Parallelism <= 0 {
+		config.Parallelism = 1
+	}
+	if config.DiscoveryInterval <= 0 {
+		config.DiscoveryInterval = DefaultDiscoveryInterval
+	}
+	if config.IdleStreamTimeout <= 0 {
+		config.IdleStreamTimeout = DefaultIdleStreamTimeout
+	}
+	if config.Parser == nil {
+		config.Parser, _ = NewEventParser(AutoParser)
+	}
+	if config.Context == nil {
+		config.Context = context.Background()
+	}
+	return config
+}
+
+func (config ReaderConfig) client() (*ThrottledClient, error) {
+	if throttled, ok := config.Client.(*ThrottledClient); ok {
+		return throttled, nil
+	}
+	if config.Client != nil {
+		return NewThrottledClient(config.Client, config.Throttle), nil
+	}
+
+	sess := config.Session
+	if sess == nil {
+		var err error
+		if sess, err = session.NewSession(); err != nil {
+			return nil, err
+		}
+	}
+
+	// retries are done by the ThrottledClient so they are rate limited and
+	// reported
+	awsConfig := aws.NewConfig().WithMaxRetries(0)
+	if config.AWSConfig != nil {
+		awsConfig.MergeIn(config.AWSConfig)
+	}
+
+	return NewThrottledClient(cloudwatchlogs.New(sess, awsConfig), config.Throttle), nil
+}
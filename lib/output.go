@@ -0,0 +1,413 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+// OutputFormats lists the structured formats supported by NewEventWriter
+var OutputFormats = []string{"json", "ndjson", "logfmt", "csv", "table"}
+
+// DefaultOutputFields are the columns written by the csv and table formats
+// when no fields are given
+var DefaultOutputFields = []string{"time", "level", "stream", "message"}
+
+// OutputTimeFormat is the format of times in structured output
+const OutputTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// tableBufferSize is the number of rows the table format buffers to compute
+// its column widths
+const tableBufferSize = 100
+
+// EventWriter writes events in a structured format.  Flush writes the
+// events the format buffers, such as the rows of a table waiting for their
+// column widths, and is meant to be called when following logs goes quiet.
+// Close completes the output once all events are written, it doesn't close
+// the underlying writer and can be called more than once.
+type EventWriter interface {
+	Write(e Event) error
+	Flush() error
+	Close() error
+}
+
+// NewEventWriter returns a writer for one of the OutputFormats.  The formats
+// and their schemas are:
+//
+//	json    a JSON array of event objects
+//	ndjson  one event object per line
+//	logfmt  one line of key=value pairs per event
+//	csv     a header line with the field names, then one record per event
+//	table   aligned columns headed by the upper cased field names
+//
+// Event objects always have the keys time, level, group, stream, id,
+// ingest_time, message, info and data, where info and data are the ecs-logs
+// info and data objects.  Times are written in UTC as OutputTimeFormat, and
+// every format leaves the level of events without one empty.
+//
+// Fields select the values written by logfmt, csv and table.  They are the
+// fields understood by ParseQuery (time, level, message, stream, task, group,
+// id, info.<field> and data.<path>) plus ingest_time, other names are
+// rejected.  By default logfmt writes time, level, stream, message and all
+// the flattened data, and csv and table write DefaultOutputFields.
+func NewEventWriter(w io.Writer, format string, fields []string) (EventWriter, error) {
+	getters, err := outputFields(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "json":
+		return &jsonWriter{w: w, array: true}, nil
+	case "ndjson":
+		return &jsonWriter{w: w}, nil
+	case "logfmt":
+		return &logfmtWriter{w: w, fields: fields, getters: getters}, nil
+	case "csv":
+		if fields == nil {
+			fields, getters = DefaultOutputFields, mustOutputFields(DefaultOutputFields)
+		}
+		return &csvWriter{w: csv.NewWriter(w), fields: fields, getters: getters}, nil
+	case "table":
+		if fields == nil {
+			fields, getters = DefaultOutputFields, mustOutputFields(DefaultOutputFields)
+		}
+		return &tableWriter{w: w, fields: fields, getters: getters}, nil
+	}
+	return nil, fmt.Errorf("Unknown output format '%s', expected one of %s", format, strings.Join(OutputFormats, ", "))
+}
+
+type outputGetter func(e Event) (interface{}, bool)
+
+func outputFields(fields []string) ([]outputGetter, error) {
+	getters := make([]outputGetter, 0, len(fields))
+	for _, field := range fields {
+		getter, err := outputField(field)
+		if err != nil {
+			return nil, err
+		}
+		getters = append(getters, getter)
+	}
+	return getters, nil
+}
+
+func mustOutputFields(fields []string) []outputGetter {
+	getters, err := outputFields(fields)
+	if err != nil {
+		panic(err)
+	}
+	return getters
+}
+
+func outputField(name string) (outputGetter, error) {
+	if name == "" {
+		return nil, fmt.Errorf("Empty field name")
+	}
+	switch name {
+	case "ingest_time":
+		return func(e Event) (interface{}, bool) { return e.IngestTime, true }, nil
+	case "level":
+		// unlike queries, output leaves the level of events without one empty
+		return func(e Event) (interface{}, bool) { return e.Level, e.Level != ecslogs.NONE }, nil
+	}
+
+	get, _, err := queryField(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown field '%s', expected time, level, message, stream, task, group, id, ingest_time, info.<field> or data.<path>", name)
+	}
+	return func(e Event) (interface{}, bool) { return get(&queryContext{event: e}) }, nil
+}
+
+// outputLevel formats the level of an event, empty if it has none
+func outputLevel(level ecslogs.Level) string {
+	return outputValue(level, level != ecslogs.NONE)
+}
+
+// outputValue formats a field value as text
+func outputValue(value interface{}, ok bool) string {
+	if !ok || value == nil {
+		return ""
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.UTC().Format(OutputTimeFormat)
+	case ecslogs.Level:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool, int, int64:
+		return fmt.Sprint(v)
+	}
+	if b, err := json.Marshal(value); err == nil {
+		return string(b)
+	}
+	return fmt.Sprint(value)
+}
+
+// outputEvent is the schema of the json and ndjson formats
+type outputEvent struct {
+	Time       string            `json:"time"`
+	Level      string            `json:"level"`
+	Group      string            `json:"group"`
+	Stream     string            `json:"stream"`
+	ID         string            `json:"id"`
+	IngestTime string            `json:"ingest_time"`
+	Message    string            `json:"message"`
+	Info       ecslogs.EventInfo `json:"info"`
+	Data       ecslogs.EventData `json:"data"`
+}
+
+func newOutputEvent(e Event) outputEvent {
+	data := e.Data
+	if data == nil {
+		data = ecslogs.EventData{}
+	}
+	return outputEvent{
+		Time:       e.Time.UTC().Format(OutputTimeFormat),
+		Level:      outputLevel(e.Level),
+		Group:      e.Group,
+		Stream:     e.Stream,
+		ID:         e.ID,
+		IngestTime: e.IngestTime.UTC().Format(OutputTimeFormat),
+		Message:    e.Message,
+		Info:       e.Info,
+		Data:       data,
+	}
+}
+
+type jsonWriter struct {
+	w      io.Writer
+	array  bool
+	count  int
+	closed bool
+}
+
+func (j *jsonWriter) Write(e Event) error {
+	b, err := json.Marshal(newOutputEvent(e))
+	if err != nil {
+		return err
+	}
+
+	if j.array {
+		separator := ",\n"
+		if j.count == 0 {
+			separator = "[\n"
+		}
+		b = append([]byte(separator), b...)
+	} else {
+		b = append(b, '\n')
+	}
+	j.count++
+	_, err = j.w.Write(b)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if !j.array || j.closed {
+		return nil
+	}
+	j.closed = true
+	closing := "\n]\n"
+	if j.count == 0 {
+		closing = "[]\n"
+	}
+	_, err := io.WriteString(j.w, closing)
+	return err
+}
+
+func (j *jsonWriter) Flush() error {
+	return nil
+}
+
+type logfmtWriter struct {
+	w       io.Writer
+	fields  []string
+	getters []outputGetter
+}
+
+func (l *logfmtWriter) Write(e Event) error {
+	var line bytes.Buffer
+	pair := func(key string, value string) {
+		if line.Len() > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(key)
+		line.WriteByte('=')
+		line.WriteString(logfmtValue(value))
+	}
+
+	if l.fields != nil {
+		for ix, field := range l.fields {
+			pair(field, outputValue(l.getters[ix](e)))
+		}
+	} else {
+		pair("time", outputValue(e.Time, true))
+		pair("level", outputLevel(e.Level))
+		pair("stream", e.Stream)
+		pair("message", e.Message)
+
+		flat := e.DataFlat()
+		keys := make([]string, 0, len(flat))
+		for key := range flat {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			pair("data."+key, outputValue(flat[key], true))
+		}
+	}
+
+	line.WriteByte('\n')
+	_, err := l.w.Write(line.Bytes())
+	return err
+}
+
+func (l *logfmtWriter) Flush() error {
+	return nil
+}
+
+func (l *logfmtWriter) Close() error {
+	return nil
+}
+
+// logfmtValue quotes values that are empty or contain spaces, quotes, equal
+// signs or control characters
+func logfmtValue(value string) string {
+	if value == "" {
+		return `""`
+	}
+	for _, r := range value {
+		if r <= ' ' || r == '"' || r == '=' || r == '\\' || r == utf8.RuneError {
+			return strconv.Quote(value)
+		}
+	}
+	return value
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	fields  []string
+	getters []outputGetter
+	started bool
+}
+
+func (c *csvWriter) Write(e Event) error {
+	if !c.started {
+		c.started = true
+		if err := c.w.Write(c.fields); err != nil {
+			return err
+		}
+	}
+
+	record := make([]string, len(c.getters))
+	for ix, get := range c.getters {
+		record[ix] = outputValue(get(e))
+	}
+	if err := c.w.Write(record); err != nil {
+		return err
+	}
+	// records are written as they come so that following shows them
+	return c.Flush()
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	if !c.started {
+		c.started = true
+		c.w.Write(c.fields)
+	}
+	return c.Flush()
+}
+
+// tableWriter buffers rows to compute column widths.  Columns only ever
+// grow, so rows written after the first buffer stay aligned unless a value
+// is wider than anything seen before.
+type tableWriter struct {
+	w       io.Writer
+	fields  []string
+	getters []outputGetter
+	widths  []int
+	rows    [][]string
+	header  bool
+}
+
+func (t *tableWriter) Write(e Event) error {
+	row := make([]string, len(t.getters))
+	for ix, get := range t.getters {
+		// keep every event on a single line
+		row[ix] = strings.Replace(outputValue(get(e)), "\n", `\n`, -1)
+	}
+	t.rows = append(t.rows, row)
+
+	if len(t.rows) >= tableBufferSize {
+		return t.flush()
+	}
+	return nil
+}
+
+// Flush writes the buffered rows, aligned on the widths seen so far
+func (t *tableWriter) Flush() error {
+	if len(t.rows) == 0 {
+		return nil
+	}
+	return t.flush()
+}
+
+func (t *tableWriter) Close() error {
+	return t.flush()
+}
+
+// flush writes the buffered rows, widening the columns as needed
+func (t *tableWriter) flush() error {
+	if !t.header {
+		header := make([]string, len(t.fields))
+		for ix, field := range t.fields {
+			header[ix] = strings.ToUpper(field)
+		}
+		t.rows = append([][]string{header}, t.rows...)
+		t.header = true
+	}
+
+	if t.widths == nil {
+		t.widths = make([]int, len(t.fields))
+	}
+	for _, row := range t.rows {
+		for ix, value := range row {
+			if n := utf8.RuneCountInString(value); n > t.widths[ix] {
+				t.widths[ix] = n
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	for _, row := range t.rows {
+		var line bytes.Buffer
+		for ix, value := range row {
+			line.WriteString(value)
+			if ix < len(row)-1 {
+				line.WriteString(strings.Repeat(" ", t.widths[ix]-utf8.RuneCountInString(value)+2))
+			}
+		}
+		out.WriteString(strings.TrimRight(line.String(), " "))
+		out.WriteByte('\n')
+	}
+	t.rows = t.rows[:0]
+
+	_, err := t.w.Write(out.Bytes())
+	return err
+}
@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+func TestEventWriterFlush(t *testing.T) {
+	e := Event{Stream: "web"}
+	e.Time = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	e.Message = "hello"
+
+	var out bytes.Buffer
+	csv, err := NewEventWriter(&out, "csv", []string{"stream", "message"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := csv.Write(e); err != nil {
+		t.Fatal(err)
+	}
+	// records are written without waiting for Flush or Close
+	if got, want := out.String(), "stream,message\nweb,hello\n"; got != want {
+		t.Errorf("csv = %q, want %q", got, want)
+	}
+
+	out.Reset()
+	table, err := NewEventWriter(&out, "table", []string{"stream", "message"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Write(e); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("table wrote %q before Flush", out.String())
+	}
+	if err := table.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "STREAM  MESSAGE\nweb     hello\n"; got != want {
+		t.Errorf("table = %q, want %q", got, want)
+	}
+
+	// later rows keep the widths, Flush and Close don't repeat anything
+	e.Stream = "worker"
+	table.Write(e)
+	table.Flush()
+	table.Flush()
+	table.Close()
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || lines[2] != "worker  hello" {
+		t.Errorf("table = %q", out.String())
+	}
+}
+
+func TestOutputFields(t *testing.T) {
+	tests := []struct {
+		field string
+		err   string
+	}{
+		{"time", ""},
+		{"ingest_time", ""},
+		{"info.host", ""},
+		{"data.http.status", ""},
+		// data needs its prefix
+		{"http.status", "Unknown field 'http.status'"},
+		{"levle", "Unknown field 'levle'"},
+		{"data.", "Unknown field 'data.'"},
+		{"", "Empty field name"},
+	}
+	for _, test := range tests {
+		_, err := NewEventWriter(&bytes.Buffer{}, "csv", []string{"message", test.field})
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%q: %s", test.field, err)
+		case test.err != "" && (err == nil || !strings.HasPrefix(err.Error(), test.err)):
+			t.Errorf("%q: error = %v, want %s", test.field, err, test.err)
+		}
+	}
+}
+
+func TestEventWriterLevel(t *testing.T) {
+	e := Event{Stream: "web"}
+	e.Time = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	e.Message = "hello"
+
+	// every format leaves the level of events without one empty
+	tests := []struct {
+		format string
+		fields []string
+		want   string
+	}{
+		{"ndjson", nil, `"level":"",`},
+		{"logfmt", nil, `level="" `},
+		{"logfmt", []string{"level"}, `level=""`},
+		{"csv", []string{"level", "message"}, "\n,hello\n"},
+		{"table", []string{"level", "message"}, "\n       hello\n"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		w, err := NewEventWriter(&out, test.format, test.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(e)
+		w.Close()
+		if !strings.Contains(out.String(), test.want) {
+			t.Errorf("%s %v = %q, want it to contain %q", test.format, test.fields, out.String(), test.want)
+		}
+
+		e.Level = ecslogs.WARN
+		out.Reset()
+		w, _ = NewEventWriter(&out, test.format, test.fields)
+		w.Write(e)
+		w.Close()
+		if !strings.Contains(out.String(), "WARN") {
+			t.Errorf("%s %v = %q, want the WARN level", test.format, test.fields, out.String())
+		}
+		e.Level = ecslogs.NONE
+	}
+}
@@ -0,0 +1,219 @@
+package lib
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// shardsPerWorker is how many time shards a window is split into per
+// concurrent fetch, so that workers finishing early pick up more work
+const shardsPerWorker = 4
+
+// timeShard is a slice [start, end] of a time window in milliseconds.  Events
+// at end belong to the next shard unless last is set.
+type timeShard struct {
+	start int64
+	end   int64
+	last  bool
+}
+
+// splitWindow splits [start, end] into at most n contiguous shards
+func splitWindow(start int64, end int64, n int) []timeShard {
+	if end-start < int64(n) {
+		n = int(end - start)
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	shards := make([]timeShard, 0, n)
+	for k := 0; k < n; k++ {
+		shards = append(shards, timeShard{
+			start: start + (end-start)*int64(k)/int64(n),
+			end:   start + (end-start)*int64(k+1)/int64(n),
+			last:  k == n-1,
+		})
+	}
+	return shards
+}
+
+// shardBuffer holds the pages fetched for a shard until they are emitted
+type shardBuffer struct {
+	mu     sync.Mutex
+	pages  [][]*cloudwatchlogs.FilteredLogEvent
+	done   bool
+	err    error
+	notify chan struct{}
+}
+
+func newShardBuffer() *shardBuffer {
+	return &shardBuffer{notify: make(chan struct{}, 1)}
+}
+
+func (b *shardBuffer) push(page []*cloudwatchlogs.FilteredLogEvent) {
+	b.mu.Lock()
+	b.pages = append(b.pages, page)
+	b.mu.Unlock()
+	b.signal()
+}
+
+func (b *shardBuffer) finish(err error) {
+	b.mu.Lock()
+	b.done = true
+	b.err = err
+	b.mu.Unlock()
+	b.signal()
+}
+
+func (b *shardBuffer) signal() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next blocks until a page is available and returns it, or returns false
+// once the shard is exhausted
+func (b *shardBuffer) next(ctx context.Context) ([]*cloudwatchlogs.FilteredLogEvent, bool, error) {
+	for {
+		b.mu.Lock()
+		if len(b.pages) > 0 {
+			page := b.pages[0]
+			b.pages = b.pages[1:]
+			b.mu.Unlock()
+			return page, true, nil
+		}
+		done, err := b.done, b.err
+		b.mu.Unlock()
+
+		if done {
+			return nil, false, err
+		}
+
+		select {
+		case <-b.notify:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+}
+
+// pumpShards fetches the window of params as concurrent time shards and
+// emits the events in the same order a single paginated call would
+func (c *CloudwatchLogsReader) pumpShards(ctx context.Context, params *cloudwatchlogs.FilterLogEventsInput, emit func(Event) error) error {
+	// stop the workers and wait for them before returning
+	var workers sync.WaitGroup
+	defer workers.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	shards := splitWindow(*params.StartTime, *params.EndTime, c.parallelism*shardsPerWorker)
+	buffers := make([]*shardBuffer, len(shards))
+	for i := range buffers {
+		buffers[i] = newShardBuffer()
+	}
+
+	// slots bounds the number of shards fetched but not yet emitted, which
+	// bounds memory when the first shards are slow
+	slots := make(chan struct{}, 2*c.parallelism)
+	work := make(chan int)
+	go func() {
+		defer close(work)
+		for i := range shards {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case work <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// the first shard failing stops the others, its error is returned rather
+	// than the cancellation of the shard being emitted
+	var failOnce sync.Once
+	var failure error
+	fail := func(err error) {
+		failOnce.Do(func() {
+			failure = err
+			cancel()
+		})
+	}
+
+	for w := 0; w < c.parallelism; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range work {
+				err := c.fetchShard(ctx, params, shards[i], buffers[i])
+				if err != nil {
+					fail(err)
+				}
+				buffers[i].finish(err)
+			}
+		}()
+	}
+
+	for i := range shards {
+		for {
+			page, ok, err := buffers[i].next(ctx)
+			if err != nil {
+				cancel()
+				workers.Wait()
+				if failure != nil {
+					return failure
+				}
+				return err
+			}
+			if !ok {
+				break
+			}
+			for _, event := range page {
+				if err := emit(c.parser.Event(*event, c.logGroupName)); err != nil {
+					return err
+				}
+			}
+		}
+		<-slots
+	}
+	return nil
+}
+
+// fetchShard paginates through the events of a single shard
+func (c *CloudwatchLogsReader) fetchShard(ctx context.Context, template *cloudwatchlogs.FilterLogEventsInput, shard timeShard, buffer *shardBuffer) error {
+	params := *template
+	params.StartTime = aws.Int64(shard.start)
+	params.EndTime = aws.Int64(shard.end)
+	params.NextToken = nil
+
+	for {
+		o, err := c.svc.FilterLogEventsWithContext(ctx, &params)
+		if err != nil {
+			return err
+		}
+
+		// the end of a shard is requested inclusively, leave events at the
+		// boundary to the next shard
+		page := make([]*cloudwatchlogs.FilteredLogEvent, 0, len(o.Events))
+		for _, event := range o.Events {
+			if shard.last || *event.Timestamp < shard.end {
+				page = append(page, event)
+			}
+		}
+		if len(page) > 0 {
+			buffer.push(page)
+		}
+
+		if o.NextToken == nil {
+			return nil
+		}
+		params.NextToken = o.NextToken
+	}
+}
@@ -0,0 +1,209 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+func TestSplitWindow(t *testing.T) {
+	tests := []struct {
+		start, end int64
+		n          int
+		want       string
+	}{
+		{0, 100, 4, "[{0 25 false} {25 50 false} {50 75 false} {75 100 true}]"},
+		{10, 20, 3, "[{10 13 false} {13 16 false} {16 20 true}]"},
+		// windows smaller than the number of shards get one per millisecond
+		{10, 13, 8, "[{10 11 false} {11 12 false} {12 13 true}]"},
+		{10, 10, 8, "[{10 10 true}]"},
+		{10, 20, 0, "[{10 20 true}]"},
+	}
+	for _, test := range tests {
+		if got := fmt.Sprint(splitWindow(test.start, test.end, test.n)); got != test.want {
+			t.Errorf("splitWindow(%d, %d, %d) = %s, want %s", test.start, test.end, test.n, got, test.want)
+		}
+	}
+
+	// shards are contiguous whatever the rounding
+	for n := 1; n < 40; n++ {
+		shards := splitWindow(1000, 1097, n)
+		if shards[0].start != 1000 || shards[len(shards)-1].end != 1097 {
+			t.Errorf("%d shards from %d to %d", n, shards[0].start, shards[len(shards)-1].end)
+		}
+		for i := 1; i < len(shards); i++ {
+			if shards[i].start != shards[i-1].end || shards[i-1].last {
+				t.Errorf("%d shards: %v", n, shards)
+				break
+			}
+		}
+	}
+}
+
+// shardClient serves FilterLogEvents from a list of events sorted by
+// timestamp, a few at a time.  Calls starting at failAt fail.  With block set,
+// the other calls wait until they are canceled and the failing call waits for
+// one of them to start.
+type shardClient struct {
+	CloudwatchLogsClient
+	events   []*cloudwatchlogs.FilteredLogEvent
+	pageSize int
+	failAt   int64
+	err      error
+	block    bool
+	blocked  chan struct{}
+
+	mu       sync.Mutex
+	canceled int
+}
+
+func (c *shardClient) FilterLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.FilterLogEventsInput, opts ...request.Option) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	if c.err != nil && *input.StartTime == c.failAt {
+		if c.block {
+			<-c.blocked
+		}
+		return nil, c.err
+	}
+	if c.block {
+		select {
+		case c.blocked <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		c.mu.Lock()
+		c.canceled++
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+
+	skip := 0
+	if input.NextToken != nil {
+		skip, _ = strconv.Atoi(*input.NextToken)
+	}
+	o := &cloudwatchlogs.FilterLogEventsOutput{}
+	matched := 0
+	for _, e := range c.events {
+		// both ends are inclusive, like CloudWatch Logs
+		if *e.Timestamp < *input.StartTime || *e.Timestamp > *input.EndTime {
+			continue
+		}
+		matched++
+		if matched <= skip {
+			continue
+		}
+		if len(o.Events) == c.pageSize {
+			o.NextToken = aws.String(strconv.Itoa(skip + len(o.Events)))
+			break
+		}
+		o.Events = append(o.Events, e)
+	}
+	return o, nil
+}
+
+func newShardReader(client CloudwatchLogsClient, parallelism int) *CloudwatchLogsReader {
+	return &CloudwatchLogsReader{
+		logGroupName: "group",
+		svc:          NewThrottledClient(client, ThrottleConfig{MaxRetries: -1}),
+		parallelism:  parallelism,
+		parser:       ecsLogsEvents,
+	}
+}
+
+func TestPumpShards(t *testing.T) {
+	client := &shardClient{pageSize: 2}
+	want := []string{}
+	add := func(timestamp int64) {
+		id := fmt.Sprintf("%d-%d", timestamp, len(want))
+		client.events = append(client.events, &cloudwatchlogs.FilteredLogEvent{
+			EventId:       aws.String(id),
+			LogStreamName: aws.String("stream"),
+			Message:       aws.String(id),
+			Timestamp:     aws.Int64(timestamp),
+		})
+		want = append(want, id)
+	}
+	// with 2 workers the window is split in 8 shards of 100ms, several
+	// events fall on each boundary, including the end of the window
+	for timestamp := int64(0); timestamp <= 800; timestamp += 50 {
+		add(timestamp)
+		if timestamp%100 == 0 {
+			add(timestamp)
+			add(timestamp)
+		}
+	}
+
+	tests := []struct {
+		start, end  int64
+		parallelism int
+	}{
+		{0, 800, 2},
+		{0, 800, 1},
+		{0, 800, 16},
+		// fewer milliseconds than shards
+		{100, 102, 4},
+		{100, 100, 4},
+	}
+	for _, test := range tests {
+		expected := []string{}
+		for i, e := range client.events {
+			if *e.Timestamp >= test.start && *e.Timestamp <= test.end {
+				expected = append(expected, want[i])
+			}
+		}
+
+		got := []string{}
+		params := &cloudwatchlogs.FilterLogEventsInput{StartTime: aws.Int64(test.start), EndTime: aws.Int64(test.end)}
+		err := newShardReader(client, test.parallelism).pumpShards(context.Background(), params, func(e Event) error {
+			got = append(got, e.ID)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%d..%d with %d workers: %s", test.start, test.end, test.parallelism, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("%d..%d with %d workers: events = %v, want %v", test.start, test.end, test.parallelism, got, expected)
+		}
+	}
+}
+
+func TestPumpShardsError(t *testing.T) {
+	boom := errors.New("boom")
+	// the shards are 100ms wide, the second one fails while the first one
+	// is still fetching
+	client := &shardClient{failAt: 100, err: boom, block: true, blocked: make(chan struct{}, 1)}
+	params := &cloudwatchlogs.FilterLogEventsInput{StartTime: aws.Int64(0), EndTime: aws.Int64(800)}
+
+	err := newShardReader(client, 2).pumpShards(context.Background(), params, func(e Event) error {
+		t.Errorf("unexpected event %s", e.ID)
+		return nil
+	})
+	if err != boom {
+		t.Errorf("error = %v, want %v", err, boom)
+	}
+	// the workers are done by the time pumpShards returns
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.canceled == 0 {
+		t.Error("no shard was canceled")
+	}
+}
+
+func TestPumpShardsCanceled(t *testing.T) {
+	client := &shardClient{block: true, blocked: make(chan struct{}, 1)}
+	params := &cloudwatchlogs.FilterLogEventsInput{StartTime: aws.Int64(0), EndTime: aws.Int64(800)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	err := newShardReader(client, 2).pumpShards(ctx, params, func(e Event) error { return nil })
+	if !IsCanceled(err) {
+		t.Errorf("error = %v, want a canceled error", err)
+	}
+}
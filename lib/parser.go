@@ -0,0 +1,515 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+// AutoParser is the name of the parser detecting the format of every stream
+const AutoParser = "auto"
+
+// Parser decodes a log message written in a given format.  It returns false
+// when the message isn't in that format.  Zero times are replaced by the
+// time CloudWatch has for the event.
+type Parser func(message string) (ecslogs.Event, bool)
+
+var (
+	parsersMu sync.RWMutex
+	parsers   = map[string]Parser{
+		"ecs-logs": parseECSLogs,
+		"zap":      parseZap,
+		"logrus":   parseLogrus,
+		"bunyan":   parseBunyan,
+		"logfmt":   parseLogfmt,
+		"raw":      parseRaw,
+	}
+
+	// detectOrder is the order parsers are tried in to detect the format of
+	// a stream, the most specific formats first
+	detectOrder = []string{"ecs-logs", "bunyan", "zap", "logrus", "logfmt"}
+
+	// detectParsers replaces parsers accepting too much to tell formats apart
+	// while detecting the format of a stream
+	detectParsers = map[string]Parser{
+		"ecs-logs": detectECSLogs,
+	}
+)
+
+// RegisterParser adds a parser to the ones available by name.  Parsers
+// registered this way are also tried, after the built-in ones, when
+// detecting the format of a stream.
+func RegisterParser(name string, parser Parser) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	if _, ok := parsers[name]; !ok {
+		detectOrder = append(detectOrder, name)
+	}
+	parsers[name] = parser
+	delete(detectParsers, name)
+}
+
+// ParserNames returns the names accepted by NewEventParser
+func ParserNames() []string {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+	names := []string{AutoParser}
+	for name := range parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// EventParser turns log messages into events.  With AutoParser, the format of
+// each stream is detected from its messages: the parser that last decoded a
+// message of the stream is tried first, then every parser in turn.  JSON
+// objects no parser recognizes are decoded as ecs-logs events, other messages
+// no parser understands are INFO events holding the raw message.
+type EventParser struct {
+	mu      sync.Mutex
+	fixed   Parser
+	streams map[string]Parser
+}
+
+// NewEventParser returns an event parser using the parser called name, or
+// detecting the format of every stream for AutoParser or an empty name
+func NewEventParser(name string) (*EventParser, error) {
+	if name == "" || name == AutoParser {
+		return &EventParser{streams: map[string]Parser{}}, nil
+	}
+
+	parsersMu.RLock()
+	parser, ok := parsers[name]
+	parsersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown parser '%s', expected one of %s", name, strings.Join(ParserNames(), ", "))
+	}
+	return &EventParser{fixed: parser}, nil
+}
+
+// Event takes a cloudwatch log event and returns an Event, like NewEvent
+func (p *EventParser) Event(cwEvent cloudwatchlogs.FilteredLogEvent, group string) Event {
+	return Event{
+		Event:        p.Parse(*cwEvent.LogStreamName, *cwEvent.Message, ParseAWSTimestamp(cwEvent.Timestamp)),
+		Stream:       *cwEvent.LogStreamName,
+		Group:        group,
+		ID:           *cwEvent.EventId,
+		IngestTime:   ParseAWSTimestamp(cwEvent.IngestionTime),
+		CreationTime: ParseAWSTimestamp(cwEvent.Timestamp),
+		Size:         len(*cwEvent.Message),
+	}
+}
+
+// EventFromLine takes a log line read outside of CloudWatch and returns an
+// Event, like NewEventFromLine
+func (p *EventParser) EventFromLine(line string, arrival time.Time, source string) Event {
+	return Event{
+		Event:        p.Parse(source, line, arrival),
+		Stream:       source,
+		IngestTime:   arrival,
+		CreationTime: arrival,
+		Size:         len(line),
+	}
+}
+
+// Parse decodes a message of stream, using fallback when it has no time
+func (p *EventParser) Parse(stream string, message string, fallback time.Time) ecslogs.Event {
+	e, ok := p.parse(stream, message)
+	if !ok {
+		e, _ = parseRaw(message)
+	}
+	if e.Time.IsZero() {
+		e.Time = fallback
+	}
+	return e
+}
+
+func (p *EventParser) parse(stream string, message string) (ecslogs.Event, bool) {
+	if p.fixed != nil {
+		return p.fixed(message)
+	}
+
+	p.mu.Lock()
+	last := p.streams[stream]
+	p.mu.Unlock()
+	if last != nil {
+		if e, ok := last(message); ok {
+			return e, true
+		}
+	}
+
+	parsersMu.RLock()
+	candidates := make([]Parser, 0, len(detectOrder))
+	for _, name := range detectOrder {
+		parser, ok := detectParsers[name]
+		if !ok {
+			parser = parsers[name]
+		}
+		candidates = append(candidates, parser)
+	}
+	parsersMu.RUnlock()
+
+	for _, parser := range candidates {
+		if e, ok := parser(message); ok {
+			p.mu.Lock()
+			p.streams[stream] = parser
+			p.mu.Unlock()
+			return e, true
+		}
+	}
+	return parseECSLogs(message)
+}
+
+// parseRaw returns an INFO event holding the message as is
+func parseRaw(message string) (ecslogs.Event, bool) {
+	return ecslogs.MakeEvent(ecslogs.INFO, message), true
+}
+
+// parseECSLogs decodes any JSON object into an ecs-logs event, keys ecs-logs
+// doesn't know about are dropped
+func parseECSLogs(message string) (ecslogs.Event, bool) {
+	if !strings.HasPrefix(strings.TrimSpace(message), "{") {
+		return ecslogs.Event{}, false
+	}
+
+	var e ecslogs.Event
+	if err := json.Unmarshal([]byte(message), &e); err != nil {
+		return ecslogs.Event{}, false
+	}
+	return e, true
+}
+
+// detectECSLogs only decodes ecs-logs JSON, which always has a message key,
+// leaving the JSON of other loggers to their own parsers
+func detectECSLogs(message string) (ecslogs.Event, bool) {
+	object, ok := jsonObject(message)
+	if !ok {
+		return ecslogs.Event{}, false
+	}
+	if _, ok := object["message"].(string); !ok {
+		return ecslogs.Event{}, false
+	}
+	return parseECSLogs(message)
+}
+
+// parseZap decodes the JSON written by zap's production encoder, which has
+// ts, level and msg keys
+func parseZap(message string) (ecslogs.Event, bool) {
+	object, ok := jsonObject(message)
+	if !ok || !hasKeys(object, "ts", "msg") {
+		return ecslogs.Event{}, false
+	}
+
+	e := newParsedEvent(object, "msg")
+	e.Time, _ = parseAnyTime(object["ts"])
+	e.Level = parseLevelValue(object["level"])
+	e.Info.Source = popString(object, "caller")
+	if err := popString(object, "error"); err != "" {
+		e.Info.Errors = append(e.Info.Errors, ecslogs.EventError{Error: err, Stack: popValue(object, "stacktrace")})
+	}
+	delete(object, "ts")
+	delete(object, "level")
+	return e, true
+}
+
+// parseLogrus decodes the JSON written by logrus' JSONFormatter, which has
+// time, msg and level keys, with the level spelled out unlike bunyan
+func parseLogrus(message string) (ecslogs.Event, bool) {
+	object, ok := jsonObject(message)
+	if !ok || !hasKeys(object, "time", "msg") {
+		return ecslogs.Event{}, false
+	}
+	if _, ok := object["level"].(string); !ok {
+		return ecslogs.Event{}, false
+	}
+
+	e := newParsedEvent(object, "msg")
+	e.Time, _ = parseAnyTime(object["time"])
+	e.Level = parseLevelValue(object["level"])
+	if err := popString(object, "error"); err != "" {
+		e.Info.Errors = append(e.Info.Errors, ecslogs.EventError{Error: err})
+	}
+	delete(object, "time")
+	delete(object, "level")
+	return e, true
+}
+
+// parseBunyan decodes bunyan records, which have a format version v and
+// numeric levels
+func parseBunyan(message string) (ecslogs.Event, bool) {
+	object, ok := jsonObject(message)
+	if !ok || !hasKeys(object, "v", "level", "msg") {
+		return ecslogs.Event{}, false
+	}
+	if _, ok := object["level"].(float64); !ok {
+		return ecslogs.Event{}, false
+	}
+
+	e := newParsedEvent(object, "msg")
+	e.Time, _ = parseAnyTime(object["time"])
+	e.Level = parseLevelValue(object["level"])
+	e.Info.Host = popString(object, "hostname")
+	e.Info.Source = popString(object, "name")
+	if pid, ok := popValue(object, "pid").(float64); ok {
+		e.Info.PID = int(pid)
+	}
+	if err, ok := popValue(object, "err").(map[string]interface{}); ok {
+		e.Info.Errors = append(e.Info.Errors, ecslogs.EventError{
+			Type:  fmt.Sprint(err["name"]),
+			Error: fmt.Sprint(err["message"]),
+			Stack: err["stack"],
+		})
+	}
+	delete(object, "v")
+	delete(object, "time")
+	delete(object, "level")
+	return e, true
+}
+
+// parseLogfmt decodes lines of key=value pairs with a msg or level key
+func parseLogfmt(message string) (ecslogs.Event, bool) {
+	pairs, ok := splitLogfmt(message)
+	if !ok {
+		return ecslogs.Event{}, false
+	}
+
+	object := map[string]interface{}{}
+	for _, pair := range pairs {
+		object[pair[0]] = pair[1]
+	}
+	if !hasAnyKey(object, "msg", "message", "level", "lvl") {
+		return ecslogs.Event{}, false
+	}
+
+	e := newParsedEvent(object, "msg", "message")
+	for _, key := range []string{"time", "ts", "t"} {
+		if t, ok := parseAnyTime(object[key]); ok {
+			e.Time = t
+			delete(object, key)
+			break
+		}
+	}
+	for _, key := range []string{"level", "lvl"} {
+		if _, ok := object[key]; ok {
+			e.Level = parseLevelValue(popValue(object, key))
+			break
+		}
+	}
+	for _, key := range []string{"error", "err"} {
+		if err := popString(object, key); err != "" {
+			e.Info.Errors = append(e.Info.Errors, ecslogs.EventError{Error: err})
+		}
+	}
+	return e, true
+}
+
+// splitLogfmt splits a logfmt line into keys and values, bare keys have an
+// empty value.  It returns false when the line has no key=value pair.
+func splitLogfmt(line string) ([][2]string, bool) {
+	pairs := [][2]string{}
+	hasValue := false
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(line) && line[i] > ' ' && line[i] != '=' && line[i] != '"' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" {
+			return nil, false
+		}
+
+		value := ""
+		if i < len(line) && line[i] == '=' {
+			hasValue = true
+			i++
+			if i < len(line) && line[i] == '"' {
+				end := i + 1
+				for end < len(line) && line[end] != '"' {
+					if line[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				if end >= len(line) {
+					return nil, false
+				}
+				unquoted, err := strconv.Unquote(line[i : end+1])
+				if err != nil {
+					return nil, false
+				}
+				value, i = unquoted, end+1
+			} else {
+				start := i
+				for i < len(line) && line[i] > ' ' {
+					i++
+				}
+				value = line[start:i]
+			}
+		} else if i < len(line) && line[i] > ' ' {
+			// a quote in a key
+			return nil, false
+		}
+		pairs = append(pairs, [2]string{key, value})
+	}
+	return pairs, hasValue
+}
+
+// jsonObject decodes message when it is a JSON object
+func jsonObject(message string) (map[string]interface{}, bool) {
+	message = strings.TrimSpace(message)
+	if !strings.HasPrefix(message, "{") {
+		return nil, false
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(message), &object); err != nil {
+		return nil, false
+	}
+	return object, true
+}
+
+// newParsedEvent returns an event holding the message found at the first of
+// messageKeys.  The keys left in object once the parser is done with it
+// become the data of the event.
+func newParsedEvent(object map[string]interface{}, messageKeys ...string) ecslogs.Event {
+	e := ecslogs.Event{Level: ecslogs.INFO, Data: ecslogs.EventData(object)}
+	for _, key := range messageKeys {
+		if _, ok := object[key]; ok {
+			e.Message = popString(object, key)
+			break
+		}
+	}
+	return e
+}
+
+func hasKeys(object map[string]interface{}, keys ...string) bool {
+	for _, key := range keys {
+		if _, ok := object[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func hasAnyKey(object map[string]interface{}, keys ...string) bool {
+	for _, key := range keys {
+		if _, ok := object[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// popValue removes key from object and returns its value
+func popValue(object map[string]interface{}, key string) interface{} {
+	value := object[key]
+	delete(object, key)
+	return value
+}
+
+// popString removes key from object and returns its value as a string
+func popString(object map[string]interface{}, key string) string {
+	switch v := popValue(object, key).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// parseAnyTime reads RFC3339 times, and epoch times in seconds or, when too
+// large to be seconds, milliseconds
+func parseAnyTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.000Z0700", "2006-01-02 15:04:05.000Z0700", "2006-01-02 15:04:05"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, true
+			}
+		}
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return parseAnyTime(n)
+		}
+	case float64:
+		if v > 1e12 {
+			v /= 1e3
+		}
+		sec := int64(v)
+		return time.Unix(sec, int64((v-float64(sec))*1e9)), true
+	}
+	return time.Time{}, false
+}
+
+// levelNames maps the lower cased level names of common loggers onto
+// ecs-logs levels
+var levelNames = map[string]ecslogs.Level{
+	"trace":     ecslogs.TRACE,
+	"debug":     ecslogs.DEBUG,
+	"dbug":      ecslogs.DEBUG,
+	"info":      ecslogs.INFO,
+	"notice":    ecslogs.NOTICE,
+	"warn":      ecslogs.WARN,
+	"warning":   ecslogs.WARN,
+	"error":     ecslogs.ERROR,
+	"err":       ecslogs.ERROR,
+	"eror":      ecslogs.ERROR,
+	"crit":      ecslogs.CRIT,
+	"critical":  ecslogs.CRIT,
+	"dpanic":    ecslogs.CRIT,
+	"panic":     ecslogs.CRIT,
+	"alert":     ecslogs.ALERT,
+	"fatal":     ecslogs.ALERT,
+	"emerg":     ecslogs.EMERG,
+	"emergency": ecslogs.EMERG,
+}
+
+// bunyanLevels maps bunyan's numeric levels onto ecs-logs levels, each level
+// covers the numbers up to max.  Levels above the last one are ALERT.
+var bunyanLevels = []struct {
+	max   float64
+	level ecslogs.Level
+}{
+	{10, ecslogs.TRACE},
+	{20, ecslogs.DEBUG},
+	{30, ecslogs.INFO},
+	{40, ecslogs.WARN},
+	{50, ecslogs.ERROR},
+}
+
+// parseLevelValue maps the level names of common loggers and the numeric
+// levels of bunyan onto ecs-logs levels, unknown levels are INFO
+func parseLevelValue(value interface{}) ecslogs.Level {
+	switch v := value.(type) {
+	case string:
+		if level, ok := levelNames[strings.ToLower(v)]; ok {
+			return level
+		}
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return parseLevelValue(n)
+		}
+	case float64:
+		for _, bunyan := range bunyanLevels {
+			if v <= bunyan.max {
+				return bunyan.level
+			}
+		}
+		return ecslogs.ALERT
+	}
+	return ecslogs.INFO
+}
@@ -0,0 +1,232 @@
+package lib
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+func TestEventParserDetect(t *testing.T) {
+	fallback := time.Date(2017, 3, 1, 13, 0, 0, 0, time.UTC)
+	at := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		parser  string
+		message string
+		level   ecslogs.Level
+		text    string
+		time    time.Time
+		check   func(e ecslogs.Event) bool
+	}{
+		{
+			parser:  "ecs-logs",
+			message: `{"level":"WARN","time":"2017-03-01T12:00:00Z","message":"disk low","info":{"host":"web-1"}}`,
+			level:   ecslogs.WARN,
+			text:    "disk low",
+			time:    at,
+			check:   func(e ecslogs.Event) bool { return e.Info.Host == "web-1" },
+		},
+		{
+			parser:  "zap",
+			message: `{"level":"error","ts":1488369600,"caller":"main.go:10","msg":"boom","error":"refused","stacktrace":"main.main","user":7}`,
+			level:   ecslogs.ERROR,
+			text:    "boom",
+			time:    at,
+			check: func(e ecslogs.Event) bool {
+				return e.Info.Source == "main.go:10" && len(e.Info.Errors) == 1 && e.Info.Errors[0].Error == "refused" &&
+					e.Info.Errors[0].Stack == "main.main" && len(e.Data) == 1 && e.Data["user"] == float64(7)
+			},
+		},
+		{
+			parser:  "logrus",
+			message: `{"level":"warning","msg":"slow","time":"2017-03-01T12:00:00Z","error":"timeout","path":"/users"}`,
+			level:   ecslogs.WARN,
+			text:    "slow",
+			time:    at,
+			check: func(e ecslogs.Event) bool {
+				return len(e.Info.Errors) == 1 && e.Info.Errors[0].Error == "timeout" && len(e.Data) == 1 && e.Data["path"] == "/users"
+			},
+		},
+		{
+			parser:  "bunyan",
+			message: `{"v":0,"level":50,"name":"api","hostname":"web-1","pid":12,"time":"2017-03-01T12:00:00.000Z","msg":"failed","err":{"name":"TypeError","message":"x is undefined","stack":"at f"}}`,
+			level:   ecslogs.ERROR,
+			text:    "failed",
+			time:    at,
+			check: func(e ecslogs.Event) bool {
+				return e.Info.Host == "web-1" && e.Info.Source == "api" && e.Info.PID == 12 &&
+					len(e.Info.Errors) == 1 && e.Info.Errors[0].Type == "TypeError" && len(e.Data) == 0
+			},
+		},
+		{
+			parser:  "logfmt",
+			message: `time=2017-03-01T12:00:00Z level=warn msg="disk low" disk=/dev/sda err=full`,
+			level:   ecslogs.WARN,
+			text:    "disk low",
+			time:    at,
+			check: func(e ecslogs.Event) bool {
+				return len(e.Info.Errors) == 1 && e.Info.Errors[0].Error == "full" && len(e.Data) == 1 && e.Data["disk"] == "/dev/sda"
+			},
+		},
+		{
+			parser:  "logfmt",
+			message: `lvl=dbug t=1488369600000 message=started`,
+			level:   ecslogs.DEBUG,
+			text:    "started",
+			time:    at,
+		},
+		{
+			parser:  "raw",
+			message: `GET /users 200`,
+			level:   ecslogs.INFO,
+			text:    `GET /users 200`,
+			time:    fallback,
+		},
+		// key=value pairs without a level or message aren't logfmt
+		{
+			parser:  "raw",
+			message: `user=7 path=/users`,
+			level:   ecslogs.INFO,
+			text:    `user=7 path=/users`,
+			time:    fallback,
+		},
+	}
+
+	for _, test := range tests {
+		auto, err := NewEventParser(AutoParser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixed, err := NewEventParser(test.parser)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := auto.Parse("stream", test.message, fallback)
+		if e.Level != test.level || e.Message != test.text || !e.Time.Equal(test.time) {
+			t.Errorf("%s: %q = %s %q at %s, want %s %q at %s", test.parser, test.message, e.Level, e.Message, e.Time, test.level, test.text, test.time)
+		}
+		if test.check != nil && !test.check(e) {
+			t.Errorf("%s: %q = %+v", test.parser, test.message, e)
+		}
+		// the format detected is the one of the parser
+		if want := fixed.Parse("stream", test.message, fallback); !reflect.DeepEqual(e, want) {
+			t.Errorf("%s: detected %+v, the parser returns %+v", test.parser, e, want)
+		}
+	}
+}
+
+func TestEventParserStreams(t *testing.T) {
+	p, err := NewEventParser(AutoParser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fallback := time.Date(2017, 3, 1, 13, 0, 0, 0, time.UTC)
+
+	// zap JSON has a level and a message an ecs-logs event would take, the
+	// stream's format is tried first but the others are still detected
+	zap := `{"level":"error","ts":1488369600,"msg":"boom"}`
+	if e := p.Parse("a", zap, fallback); e.Level != ecslogs.ERROR || e.Message != "boom" {
+		t.Errorf("zap = %s %q", e.Level, e.Message)
+	}
+	if e := p.Parse("a", `level=warn msg=slow`, fallback); e.Level != ecslogs.WARN || e.Message != "slow" {
+		t.Errorf("logfmt after zap = %s %q", e.Level, e.Message)
+	}
+	if e := p.Parse("a", zap, fallback); e.Level != ecslogs.ERROR || e.Message != "boom" {
+		t.Errorf("zap after logfmt = %s %q", e.Level, e.Message)
+	}
+
+	// JSON no parser recognizes is decoded as ecs-logs, without a message
+	e := p.Parse("b", `{"level":"ERROR","data":{"id":1}}`, fallback)
+	if e.Level != ecslogs.ERROR || e.Message != "" || e.Data["id"] != float64(1) {
+		t.Errorf("JSON without a message = %+v", e)
+	}
+	if e := p.Parse("b", `{"level":"ERROR"`, fallback); e.Level != ecslogs.INFO || e.Message != `{"level":"ERROR"` {
+		t.Errorf("invalid JSON = %s %q", e.Level, e.Message)
+	}
+
+	if _, err := NewEventParser("xml"); err == nil {
+		t.Error("expected an error for an unknown parser")
+	}
+}
+
+func TestParserLevels(t *testing.T) {
+	tests := []struct {
+		parser string
+		format string
+		levels map[string]ecslogs.Level
+	}{
+		{
+			parser: "zap",
+			format: `{"level":%q,"ts":1488369600,"msg":"m"}`,
+			levels: map[string]ecslogs.Level{
+				"debug":  ecslogs.DEBUG,
+				"info":   ecslogs.INFO,
+				"warn":   ecslogs.WARN,
+				"error":  ecslogs.ERROR,
+				"dpanic": ecslogs.CRIT,
+				"panic":  ecslogs.CRIT,
+				"fatal":  ecslogs.ALERT,
+				"custom": ecslogs.INFO,
+			},
+		},
+		{
+			parser: "logrus",
+			format: `{"level":%q,"time":"2017-03-01T12:00:00Z","msg":"m"}`,
+			levels: map[string]ecslogs.Level{
+				"trace":   ecslogs.TRACE,
+				"debug":   ecslogs.DEBUG,
+				"info":    ecslogs.INFO,
+				"warning": ecslogs.WARN,
+				"error":   ecslogs.ERROR,
+				"fatal":   ecslogs.ALERT,
+				"panic":   ecslogs.CRIT,
+			},
+		},
+		{
+			parser: "bunyan",
+			format: `{"v":0,"level":%s,"time":"2017-03-01T12:00:00Z","msg":"m"}`,
+			levels: map[string]ecslogs.Level{
+				"10": ecslogs.TRACE,
+				"20": ecslogs.DEBUG,
+				"30": ecslogs.INFO,
+				"35": ecslogs.WARN,
+				"40": ecslogs.WARN,
+				"50": ecslogs.ERROR,
+				"60": ecslogs.ALERT,
+			},
+		},
+		{
+			parser: "logfmt",
+			format: `level=%s msg=m`,
+			levels: map[string]ecslogs.Level{
+				"trace":    ecslogs.TRACE,
+				"DEBUG":    ecslogs.DEBUG,
+				"info":     ecslogs.INFO,
+				"notice":   ecslogs.NOTICE,
+				"warn":     ecslogs.WARN,
+				"eror":     ecslogs.ERROR,
+				"critical": ecslogs.CRIT,
+				"alert":    ecslogs.ALERT,
+				"emerg":    ecslogs.EMERG,
+				"50":       ecslogs.ERROR,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		p, err := NewEventParser(test.parser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range test.levels {
+			message := fmt.Sprintf(test.format, name)
+			if e := p.Parse("stream", message, time.Time{}); e.Level != want {
+				t.Errorf("%s: %s = %s, want %s", test.parser, message, e.Level, want)
+			}
+		}
+	}
+}
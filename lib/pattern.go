@@ -0,0 +1,736 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PatternError reports a syntax error in a filter pattern
+type PatternError struct {
+	Pattern string
+	// Column is the 1-based position of the offending character
+	Column  int
+	Message string
+}
+
+func (e *PatternError) Error() string {
+	return fmt.Sprintf("Invalid filter pattern at column %d: %s\n\n  %s\n  %s^", e.Column, e.Message, e.Pattern, strings.Repeat(" ", e.Column-1))
+}
+
+// FilterPattern is a parsed CloudWatch Logs filter pattern.  Three syntaxes
+// are understood:
+//
+//	ERROR ?WARN -"health check"          terms and quoted phrases
+//	{ $.level = "ERROR" && $.code > 4 }  JSON selectors
+//	[ip, user, ..., status=5*, size]     space delimited fields
+//
+// Patterns are passed as is to CloudWatch, parsing them locally reports syntax
+// errors before any API call and allows matching events client side.
+type FilterPattern struct {
+	source string
+	terms  []patternTerm
+	json   patternExpr
+	fields []patternField
+}
+
+// ParseFilterPattern parses a filter pattern, returning a *PatternError if
+// its syntax is invalid.  An empty pattern matches every event.
+func ParseFilterPattern(pattern string) (*FilterPattern, error) {
+	p := &FilterPattern{source: pattern}
+	trimmed := strings.TrimLeftFunc(pattern, unicode.IsSpace)
+
+	var err error
+	switch {
+	case strings.HasPrefix(trimmed, "{"):
+		p.json, err = parseJSONPattern(pattern)
+	case strings.HasPrefix(trimmed, "["):
+		p.fields, err = parseFieldsPattern(pattern)
+	default:
+		p.terms, err = parseTermsPattern(pattern)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// String returns the pattern as given to ParseFilterPattern
+func (p *FilterPattern) String() string {
+	return p.source
+}
+
+// Match reports whether a raw log message matches the pattern
+func (p *FilterPattern) Match(message string) bool {
+	switch {
+	case p.json != nil:
+		var doc interface{}
+		if err := json.Unmarshal([]byte(message), &doc); err != nil {
+			return false
+		}
+		return p.json.eval(doc)
+	case p.fields != nil:
+		return matchFields(p.fields, splitLogFields(message))
+	default:
+		return matchTerms(p.terms, message)
+	}
+}
+
+// --- terms and quoted phrases ---
+
+type patternTerm struct {
+	text string
+	// kind is 0 for required terms, '?' for optional and '-' for excluded
+	kind byte
+}
+
+func parseTermsPattern(pattern string) ([]patternTerm, error) {
+	terms := []patternTerm{}
+	for i := 0; i < len(pattern); {
+		if pattern[i] == ' ' || pattern[i] == '\t' {
+			i++
+			continue
+		}
+
+		term := patternTerm{}
+		if pattern[i] == '?' || pattern[i] == '-' {
+			term.kind = pattern[i]
+			i++
+			if i == len(pattern) || pattern[i] == ' ' || pattern[i] == '\t' {
+				return nil, &PatternError{pattern, i, fmt.Sprintf("expected a term after '%c'", term.kind)}
+			}
+		}
+
+		if pattern[i] == '"' {
+			text, next, err := scanQuoted(pattern, i)
+			if err != nil {
+				return nil, err
+			}
+			term.text = text
+			i = next
+			if i < len(pattern) && pattern[i] != ' ' && pattern[i] != '\t' {
+				return nil, &PatternError{pattern, i + 1, "expected a space after quoted phrase"}
+			}
+		} else {
+			start := i
+			for i < len(pattern) && pattern[i] != ' ' && pattern[i] != '\t' {
+				if c := pattern[i]; c < utf8.RuneSelf && !isWordByte(c) {
+					return nil, &PatternError{pattern, i + 1, fmt.Sprintf("unexpected character '%c', terms with special characters must be quoted", c)}
+				}
+				i++
+			}
+			term.text = pattern[start:i]
+		}
+
+		terms = append(terms, term)
+	}
+	return terms, nil
+}
+
+// scanQuoted reads a double quoted string starting at pattern[start],
+// returning its unescaped content and the index following the closing quote
+func scanQuoted(pattern string, start int) (string, int, error) {
+	var text []byte
+	for i := start + 1; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				text = append(text, pattern[i])
+			}
+		case '"':
+			return string(text), i + 1, nil
+		default:
+			text = append(text, pattern[i])
+		}
+	}
+	return "", 0, &PatternError{pattern, start + 1, "unterminated quoted string"}
+}
+
+func matchTerms(terms []patternTerm, message string) bool {
+	optional, anyOptional := false, false
+	for _, term := range terms {
+		found := strings.Contains(message, term.text)
+		switch term.kind {
+		case '-':
+			if found {
+				return false
+			}
+		case '?':
+			anyOptional = true
+			optional = optional || found
+		default:
+			if !found {
+				return false
+			}
+		}
+	}
+	return !anyOptional || optional
+}
+
+// --- JSON selectors ---
+
+type patternToken struct {
+	kind  string
+	text  string
+	col   int
+	value string
+}
+
+// tokenizePattern splits JSON and space delimited patterns into tokens.
+// Token kinds are punctuation ("{", "&&", "=", ...), "selector", "string",
+// "word" and "eof".
+func tokenizePattern(pattern string) ([]patternToken, error) {
+	tokens := []patternToken{}
+	for i := 0; i < len(pattern); {
+		c := pattern[i]
+		col := i + 1
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case operatorPrefix(pattern[i:]) != "":
+			op := operatorPrefix(pattern[i:])
+			tokens = append(tokens, patternToken{kind: op, text: op, col: col})
+			i += len(op)
+		case strings.IndexByte("{}()[],=<>", c) >= 0:
+			tokens = append(tokens, patternToken{kind: string(c), text: string(c), col: col})
+			i++
+		case c == '"':
+			text, next, err := scanQuoted(pattern, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, patternToken{kind: "string", text: pattern[i:next], value: text, col: col})
+			i = next
+		case c == '$':
+			start := i
+			i++
+			for i < len(pattern) && (isWordByte(pattern[i]) || pattern[i] == '.' || pattern[i] == '[' || pattern[i] == ']' || pattern[i] == '*') {
+				i++
+			}
+			tokens = append(tokens, patternToken{kind: "selector", text: pattern[start:i], value: pattern[start:i], col: col})
+		case isWordByte(c) || c == '*' || c == '-' || c == '.':
+			start := i
+			for i < len(pattern) && (isWordByte(pattern[i]) || pattern[i] == '*' || pattern[i] == '-' || pattern[i] == '.' || pattern[i] == ':' || pattern[i] == '/') {
+				if strings.HasPrefix(pattern[i:], "...") && i > start {
+					break
+				}
+				i++
+			}
+			tokens = append(tokens, patternToken{kind: "word", text: pattern[start:i], value: pattern[start:i], col: col})
+		default:
+			return nil, &PatternError{pattern, col, fmt.Sprintf("unexpected character '%c'", c)}
+		}
+	}
+	return append(tokens, patternToken{kind: "eof", col: len(pattern) + 1}), nil
+}
+
+// operatorPrefix returns the two or three character operator s starts with
+func operatorPrefix(s string) string {
+	for _, p := range []string{"&&", "||", "!=", "<=", ">=", "..."} {
+		if strings.HasPrefix(s, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+type patternParser struct {
+	pattern string
+	tokens  []patternToken
+	pos     int
+}
+
+func (p *patternParser) peek() patternToken { return p.tokens[p.pos] }
+
+func (p *patternParser) next() patternToken {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *patternParser) expect(kind string, what string) (patternToken, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, p.errorf(t, "expected %s", what)
+	}
+	return t, nil
+}
+
+func (p *patternParser) errorf(t patternToken, format string, args ...interface{}) error {
+	found := t.text
+	if found == "" {
+		found = t.kind
+	}
+	if t.kind == "eof" {
+		found = "end of pattern"
+	}
+	return &PatternError{p.pattern, t.col, fmt.Sprintf(format, args...) + fmt.Sprintf(", found %s", found)}
+}
+
+type patternExpr interface {
+	eval(doc interface{}) bool
+}
+
+type patternAnd struct{ left, right patternExpr }
+
+func (e patternAnd) eval(doc interface{}) bool { return e.left.eval(doc) && e.right.eval(doc) }
+
+type patternOr struct{ left, right patternExpr }
+
+func (e patternOr) eval(doc interface{}) bool { return e.left.eval(doc) || e.right.eval(doc) }
+
+type patternComparison struct {
+	path []interface{}
+	op   string
+	cond patternCondition
+}
+
+func (e patternComparison) eval(doc interface{}) bool {
+	value, found := lookupJSON(doc, e.path)
+	switch e.op {
+	case "NOT EXISTS":
+		return !found
+	case "IS NULL":
+		return found && value == nil
+	case "IS TRUE":
+		return found && value == true
+	case "IS FALSE":
+		return found && value == false
+	}
+	if !found || value == nil {
+		return false
+	}
+	switch v := value.(type) {
+	case string:
+		return e.cond.matchString(v)
+	case float64:
+		return e.cond.matchNumber(v)
+	case bool:
+		return e.cond.matchString(strconv.FormatBool(v))
+	}
+	return false
+}
+
+func parseJSONPattern(pattern string) (patternExpr, error) {
+	tokens, err := tokenizePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	p := &patternParser{pattern: pattern, tokens: tokens}
+
+	if _, err := p.expect("{", "'{'"); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("}", "'&&', '||' or '}'"); err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, p.errorf(t, "expected end of pattern")
+	}
+	return expr, nil
+}
+
+func (p *patternParser) parseOr() (patternExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = patternOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *patternParser) parseAnd() (patternExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = patternAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *patternParser) parseUnary() (patternExpr, error) {
+	if p.peek().kind == "(" {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")", "'&&', '||' or ')'"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	}
+
+	t, err := p.expect("selector", "a selector such as $.field or '('")
+	if err != nil {
+		return nil, err
+	}
+	path, err := parseSelector(p.pattern, t)
+	if err != nil {
+		return nil, err
+	}
+
+	op := p.next()
+	switch {
+	case op.kind == "word" && op.value == "IS":
+		what, err := p.expect("word", "NULL, TRUE or FALSE")
+		if err != nil {
+			return nil, err
+		}
+		switch what.value {
+		case "NULL", "TRUE", "FALSE":
+			return patternComparison{path: path, op: "IS " + what.value}, nil
+		}
+		return nil, p.errorf(what, "expected NULL, TRUE or FALSE")
+	case op.kind == "word" && op.value == "NOT":
+		what, err := p.expect("word", "EXISTS")
+		if err != nil || what.value != "EXISTS" {
+			return nil, p.errorf(what, "expected EXISTS")
+		}
+		return patternComparison{path: path, op: "NOT EXISTS"}, nil
+	case isComparisonOp(op.kind):
+		cond, err := p.parseCondition(op)
+		if err != nil {
+			return nil, err
+		}
+		return patternComparison{path: path, op: op.kind, cond: cond}, nil
+	}
+	return nil, p.errorf(op, "expected a comparison operator, IS or NOT EXISTS")
+}
+
+func isComparisonOp(kind string) bool {
+	switch kind {
+	case "=", "!=", "<", ">", "<=", ">=":
+		return true
+	}
+	return false
+}
+
+// parseSelector turns $.a.b[0] into a path of map keys and slice indexes
+func parseSelector(pattern string, t patternToken) ([]interface{}, error) {
+	s := t.value
+	path := []interface{}{}
+	if s == "$" {
+		return nil, &PatternError{pattern, t.col + 1, "expected '.' or '[' after '$'"}
+	}
+	for i := 1; i < len(s); {
+		switch s[i] {
+		case '.':
+			start := i + 1
+			i++
+			for i < len(s) && (isWordByte(s[i]) || s[i] == '*') {
+				i++
+			}
+			if i == start {
+				return nil, &PatternError{pattern, t.col + start, "expected a field name after '.'"}
+			}
+			path = append(path, s[start:i])
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, &PatternError{pattern, t.col + i, "unterminated '['"}
+			}
+			index, err := strconv.Atoi(s[i+1 : i+end])
+			if err != nil || index < 0 {
+				return nil, &PatternError{pattern, t.col + i + 1, "expected an array index"}
+			}
+			path = append(path, index)
+			i += end + 1
+		default:
+			return nil, &PatternError{pattern, t.col + i, fmt.Sprintf("unexpected character '%c' in selector", s[i])}
+		}
+	}
+	return path, nil
+}
+
+func lookupJSON(doc interface{}, path []interface{}) (interface{}, bool) {
+	for _, key := range path {
+		switch k := key.(type) {
+		case string:
+			m, ok := doc.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if doc, ok = m[k]; !ok {
+				return nil, false
+			}
+		case int:
+			a, ok := doc.([]interface{})
+			if !ok || k >= len(a) {
+				return nil, false
+			}
+			doc = a[k]
+		}
+	}
+	return doc, true
+}
+
+// --- conditions shared by JSON and space delimited patterns ---
+
+type patternCondition struct {
+	op       string
+	text     string
+	number   float64
+	isNumber bool
+}
+
+func (p *patternParser) parseCondition(op patternToken) (patternCondition, error) {
+	value := p.next()
+	cond := patternCondition{op: op.kind}
+	switch value.kind {
+	case "string":
+		cond.text = value.value
+	case "word":
+		cond.text = value.value
+		if n, err := strconv.ParseFloat(value.value, 64); err == nil {
+			cond.number, cond.isNumber = n, true
+		}
+	default:
+		return cond, p.errorf(value, "expected a value after '%s'", op.kind)
+	}
+
+	if op.kind != "=" && op.kind != "!=" && !cond.isNumber {
+		return cond, p.errorf(value, "expected a number after '%s'", op.kind)
+	}
+	return cond, nil
+}
+
+func (c patternCondition) matchString(s string) bool {
+	if c.isNumber {
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return c.matchNumber(n)
+		}
+	}
+	switch c.op {
+	case "=":
+		return wildcardMatch(c.text, s)
+	case "!=":
+		return !wildcardMatch(c.text, s)
+	}
+	return false
+}
+
+func (c patternCondition) matchNumber(n float64) bool {
+	if !c.isNumber {
+		return c.op == "!="
+	}
+	switch c.op {
+	case "=":
+		return n == c.number
+	case "!=":
+		return n != c.number
+	case "<":
+		return n < c.number
+	case ">":
+		return n > c.number
+	case "<=":
+		return n <= c.number
+	case ">=":
+		return n >= c.number
+	}
+	return false
+}
+
+// wildcardMatch matches s against pattern where `*` matches any sequence
+func wildcardMatch(pattern string, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		ix := strings.Index(s, part)
+		if ix < 0 {
+			return false
+		}
+		s = s[ix+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// --- space delimited fields ---
+
+type patternField struct {
+	name     string
+	ellipsis bool
+	// conditions is a disjunction of conjunctions
+	conditions [][]patternCondition
+}
+
+func parseFieldsPattern(pattern string) ([]patternField, error) {
+	tokens, err := tokenizePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	p := &patternParser{pattern: pattern, tokens: tokens}
+
+	if _, err := p.expect("[", "'['"); err != nil {
+		return nil, err
+	}
+
+	fields := []patternField{}
+	for {
+		t := p.next()
+		switch t.kind {
+		case "...":
+			fields = append(fields, patternField{ellipsis: true})
+		case "word":
+			field := patternField{name: t.value}
+			if isComparisonOp(p.peek().kind) {
+				if field.conditions, err = p.parseFieldConditions(field.name); err != nil {
+					return nil, err
+				}
+			}
+			fields = append(fields, field)
+		default:
+			return nil, p.errorf(t, "expected a field name or '...'")
+		}
+
+		t = p.next()
+		if t.kind == "]" {
+			break
+		}
+		if t.kind != "," {
+			return nil, p.errorf(t, "expected ',' or ']'")
+		}
+	}
+
+	if t := p.peek(); t.kind != "eof" {
+		return nil, p.errorf(t, "expected end of pattern")
+	}
+	return fields, nil
+}
+
+func (p *patternParser) parseFieldConditions(name string) ([][]patternCondition, error) {
+	disjunction := [][]patternCondition{}
+	conjunction := []patternCondition{}
+	for {
+		cond, err := p.parseCondition(p.next())
+		if err != nil {
+			return nil, err
+		}
+		conjunction = append(conjunction, cond)
+
+		switch p.peek().kind {
+		case "&&":
+		case "||":
+			disjunction = append(disjunction, conjunction)
+			conjunction = []patternCondition{}
+		default:
+			return append(disjunction, conjunction), nil
+		}
+		p.next()
+
+		// the field name is repeated after && and ||
+		t, err := p.expect("word", "field name")
+		if err != nil {
+			return nil, err
+		}
+		if t.value != name {
+			return nil, p.errorf(t, "expected field name '%s'", name)
+		}
+		if op := p.peek(); !isComparisonOp(op.kind) {
+			return nil, p.errorf(op, "expected a comparison operator")
+		}
+	}
+}
+
+// splitLogFields splits a space delimited log line into fields, keeping
+// quoted and bracketed values together
+func splitLogFields(message string) []string {
+	fields := []string{}
+	for i := 0; i < len(message); {
+		switch message[i] {
+		case ' ', '\t':
+			i++
+		case '"', '[':
+			closing := byte('"')
+			if message[i] == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(message[i+1:], closing)
+			if end < 0 {
+				fields = append(fields, message[i+1:])
+				return fields
+			}
+			fields = append(fields, message[i+1:i+1+end])
+			i += end + 2
+		default:
+			start := i
+			for i < len(message) && message[i] != ' ' && message[i] != '\t' {
+				i++
+			}
+			fields = append(fields, message[start:i])
+		}
+	}
+	return fields
+}
+
+func matchFields(pattern []patternField, values []string) bool {
+	if len(pattern) == 0 {
+		return len(values) == 0
+	}
+	field := pattern[0]
+	if field.ellipsis {
+		for skip := 0; skip <= len(values); skip++ {
+			if matchFields(pattern[1:], values[skip:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(values) == 0 || !field.match(values[0]) {
+		return false
+	}
+	return matchFields(pattern[1:], values[1:])
+}
+
+func (f patternField) match(value string) bool {
+	if len(f.conditions) == 0 {
+		return true
+	}
+	for _, conjunction := range f.conditions {
+		ok := true
+		for _, cond := range conjunction {
+			if !cond.matchString(value) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
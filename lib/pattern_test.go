@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+)
+
+const apacheLine = `127.0.0.1 frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 503 2326`
+
+func TestFilterPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		match   []string
+		noMatch []string
+	}{
+		{``, []string{"anything", ""}, nil},
+
+		// terms and quoted phrases
+		{`ERROR`, []string{"an ERROR here"}, []string{"an error here"}},
+		{`ERROR timeout`, []string{"ERROR: timeout after 5s"}, []string{"ERROR: refused"}},
+		{`"time-out" ERROR`, []string{"ERROR time-out"}, []string{"ERROR timeout"}},
+		{`"say \"hi\""`, []string{`they say "hi"`}, []string{"say hi"}},
+		{`café`, []string{"au café"}, []string{"au cafe"}},
+		{`?ERROR ?WARN`, []string{"ERROR", "WARN"}, []string{"INFO"}},
+		{`ERROR -"health check"`, []string{"ERROR in /users"}, []string{"ERROR in health check"}},
+		{`-DEBUG`, []string{"INFO"}, []string{"DEBUG"}},
+		{`ERROR ?db ?cache`, []string{"ERROR in db"}, []string{"ERROR in web", "db error"}},
+
+		// JSON selectors
+		{`{ $.level = "ERROR" }`, []string{`{"level":"ERROR"}`}, []string{`{"level":"INFO"}`, `{}`, `ERROR`}},
+		{`{$.level!="DEBUG"}`, []string{`{"level":"INFO"}`}, []string{`{"level":"DEBUG"}`, `{}`}},
+		{`{ $.path = "/api/*" }`, []string{`{"path":"/api/users"}`}, []string{`{"path":"/web/users"}`}},
+		{`{ $.code > 400 && $.method = "GET" }`, []string{`{"code":503,"method":"GET"}`, `{"code":"503","method":"GET"}`}, []string{`{"code":200,"method":"GET"}`, `{"code":503,"method":"PUT"}`}},
+		{`{ $.code = 200 }`, []string{`{"code":200}`}, []string{`{"code":201}`, `{"code":"ok"}`}},
+		{`{ $.level = "ERROR" || ($.code >= 500 && $.code < 600) }`, []string{`{"level":"ERROR"}`, `{"code":500}`}, []string{`{"code":600}`, `{"level":"WARN","code":404}`}},
+		{`{ $.items[1].id = 2 }`, []string{`{"items":[{"id":1},{"id":2}]}`}, []string{`{"items":[{"id":2}]}`, `{"items":{"id":2}}`}},
+		{`{ $.user.name = "frank" }`, []string{`{"user":{"name":"frank"}}`}, []string{`{"user":"frank"}`}},
+		{`{ $.error IS NULL }`, []string{`{"error":null}`}, []string{`{}`, `{"error":""}`}},
+		{`{ $.ok IS TRUE }`, []string{`{"ok":true}`}, []string{`{"ok":false}`, `{"ok":"true"}`}},
+		{`{ $.ok IS FALSE }`, []string{`{"ok":false}`}, []string{`{"ok":true}`, `{}`}},
+		{`{ $.trace NOT EXISTS }`, []string{`{}`}, []string{`{"trace":null}`}},
+		{`{ $.ok = true }`, []string{`{"ok":true}`}, []string{`{"ok":false}`}},
+
+		// space delimited fields
+		{`[ip, user, ..., status=5*, size]`, []string{apacheLine}, []string{strings.Replace(apacheLine, "503", "200", 1)}},
+		{`[..., status=4* || status=5*, size > 1000]`, []string{apacheLine}, []string{strings.Replace(apacheLine, "2326", "12", 1)}},
+		{`[a, b]`, []string{"x y", `x "y z"`}, []string{"x", "x y z"}},
+		{`[a, b != "-"]`, []string{"x y"}, []string{"x -"}},
+		{`[a, b >= 10 && b < 20]`, []string{"x 10", "x 19.5"}, []string{"x 20", "x ten"}},
+		{`[...]`, []string{"", "x y z"}, nil},
+	}
+
+	for _, test := range tests {
+		p, err := ParseFilterPattern(test.pattern)
+		if err != nil {
+			t.Errorf("%q: %s", test.pattern, err)
+			continue
+		}
+		if p.String() != test.pattern {
+			t.Errorf("%q: String() = %q", test.pattern, p.String())
+		}
+		for _, message := range test.match {
+			if !p.Match(message) {
+				t.Errorf("%q doesn't match %q", test.pattern, message)
+			}
+		}
+		for _, message := range test.noMatch {
+			if p.Match(message) {
+				t.Errorf("%q matches %q", test.pattern, message)
+			}
+		}
+	}
+}
+
+func TestFilterPatternErrors(t *testing.T) {
+	tests := []struct {
+		pattern string
+		column  int
+		message string
+	}{
+		// terms with special characters must be quoted
+		{`time-out`, 5, `unexpected character '-', terms with special characters must be quoted`},
+		{`ERROR:`, 6, `unexpected character ':', terms with special characters must be quoted`},
+		{`%ERROR%`, 1, `unexpected character '%', terms with special characters must be quoted`},
+		{`?`, 1, `expected a term after '?'`},
+		{`ERROR - foo`, 7, `expected a term after '-'`},
+		{`ERROR "health check`, 7, `unterminated quoted string`},
+		{`"a"b`, 4, `expected a space after quoted phrase`},
+
+		{`{ $.level = }`, 13, `expected a value after '=', found }`},
+		{`{ $.code > "abc" }`, 12, `expected a number after '>', found "abc"`},
+		{`{ $.level = "ERROR"`, 20, `expected '&&', '||' or '}', found end of pattern`},
+		{`{ level = "x" }`, 3, `expected a selector such as $.field or '(', found level`},
+		{`{ $ = 1 }`, 4, `expected '.' or '[' after '$'`},
+		{`{ $. = 1 }`, 5, `expected a field name after '.'`},
+		{`{ $.a[x] = 1 }`, 7, `expected an array index`},
+		{`{ $.a = 1 } extra`, 13, `expected end of pattern, found extra`},
+		{`{ $.a IS MAYBE }`, 10, `expected NULL, TRUE or FALSE, found MAYBE`},
+		{`{ $.a NOT THERE }`, 11, `expected EXISTS, found THERE`},
+		{`{ $.a ~ 1 }`, 7, `unexpected character '~'`},
+		{`{ $.a }`, 7, `expected a comparison operator, IS or NOT EXISTS, found }`},
+		{`{ ($.a = 1 }`, 12, `expected '&&', '||' or ')', found }`},
+		{`{ $.a = 1 && }`, 14, `expected a selector such as $.field or '(', found }`},
+
+		{`[a, b`, 6, `expected ',' or ']', found end of pattern`},
+		{`[a,, b]`, 4, `expected a field name or '...', found ,`},
+		{`[a, b >]`, 8, `expected a value after '>', found ]`},
+		{`[a, b=5 || c=6]`, 12, `expected field name 'b', found c`},
+		{`[a, b=5 || b]`, 13, `expected a comparison operator, found ]`},
+		{`[a] b`, 5, `expected end of pattern, found b`},
+	}
+
+	for _, test := range tests {
+		_, err := ParseFilterPattern(test.pattern)
+		perr, ok := err.(*PatternError)
+		if !ok {
+			t.Errorf("%q: error = %v, want a *PatternError", test.pattern, err)
+			continue
+		}
+		if perr.Column != test.column || perr.Message != test.message {
+			t.Errorf("%q: error at column %d %q, want column %d %q", test.pattern, perr.Column, perr.Message, test.column, test.message)
+		}
+	}
+}
+
+func TestPatternErrorCaret(t *testing.T) {
+	_, err := ParseFilterPattern(`ERROR time-out`)
+	want := "Invalid filter pattern at column 11: unexpected character '-', terms with special characters must be quoted\n\n  ERROR time-out\n            ^"
+	if err == nil || err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
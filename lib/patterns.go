@@ -0,0 +1,246 @@
+package lib
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+// DefaultPatternSimilarity is the share of tokens two fingerprints of the
+// same length must have in common to be clustered in one pattern
+const DefaultPatternSimilarity = 0.7
+
+// Placeholders replacing the variable parts of messages in fingerprints
+const (
+	placeholderString   = "<str>"
+	placeholderUUID     = "<uuid>"
+	placeholderIP       = "<ip>"
+	placeholderHex      = "<hex>"
+	placeholderDuration = "<duration>"
+	placeholderNumber   = "<num>"
+
+	// placeholderAny replaces the tokens differing between the fingerprints
+	// of a cluster
+	placeholderAny = "<*>"
+)
+
+// fingerprintRules are applied in order, quoted strings first so that
+// nothing inside them is kept, and durations before numbers so that 5021ms
+// isn't read as a number
+var fingerprintRules = []struct {
+	pattern     *regexp.Regexp
+	placeholder string
+}{
+	{regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`), placeholderString},
+	{regexp.MustCompile(`\b[[:xdigit:]]{8}-[[:xdigit:]]{4}-[[:xdigit:]]{4}-[[:xdigit:]]{4}-[[:xdigit:]]{12}\b`), placeholderUUID},
+	{regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}(?::\d+)?\b`), placeholderIP},
+	{regexp.MustCompile(`\b0[xX][[:xdigit:]]+\b`), placeholderHex},
+	{regexp.MustCompile(`\b(?:\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h|d))+\b`), placeholderDuration},
+}
+
+var (
+	// hexPattern matches hexadecimal words, which are only replaced when
+	// they hold both digits and letters so that words like "deadline" stay
+	hexPattern = regexp.MustCompile(`\b[[:xdigit:]]{8,}\b`)
+
+	numberPattern = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+)
+
+// Fingerprint normalizes message by replacing its variable parts with
+// placeholders: quoted strings, UUIDs, IP addresses, hexadecimal numbers and
+// identifiers, durations and numbers.  Messages logged by the same line of
+// code usually share a fingerprint, like "user <num> timed out after
+// <duration>".
+func Fingerprint(message string) string {
+	for _, rule := range fingerprintRules {
+		message = rule.pattern.ReplaceAllString(message, rule.placeholder)
+	}
+	message = hexPattern.ReplaceAllStringFunc(message, func(word string) string {
+		if strings.IndexAny(word, "0123456789") < 0 || strings.IndexAny(word, "abcdefABCDEF") < 0 {
+			return word
+		}
+		return placeholderHex
+	})
+	message = numberPattern.ReplaceAllString(message, placeholderNumber)
+	return strings.Join(strings.Fields(message), " ")
+}
+
+// Pattern is a cluster of events with similar fingerprints
+type Pattern struct {
+	tokens  []string
+	Count   int
+	Levels  map[string]int
+	First   time.Time
+	Last    time.Time
+	Streams map[string]int
+	Sample  Event
+}
+
+// String returns the fingerprint of the pattern, the tokens differing between
+// its events replaced with <*>
+func (p *Pattern) String() string {
+	return strings.Join(p.tokens, " ")
+}
+
+// Level returns the most severe level of the events of the pattern, events
+// without a level counting as INFO
+func (p *Pattern) Level() ecslogs.Level {
+	var level ecslogs.Level
+	for name := range p.Levels {
+		l, _ := ecslogs.ParseLevel(name)
+		if level == ecslogs.NONE || l < level {
+			level = l
+		}
+	}
+	return level
+}
+
+func (p *Pattern) add(e Event) {
+	if p.Count == 0 {
+		p.Sample = e
+	}
+	p.Count++
+	if p.First.IsZero() || e.Time.Before(p.First) {
+		p.First = e.Time
+	}
+	if e.Time.After(p.Last) {
+		p.Last = e.Time
+	}
+
+	level := e.Level
+	if level == ecslogs.NONE {
+		level = ecslogs.INFO
+	}
+	p.Levels[level.String()]++
+	p.Streams[e.TaskShort()]++
+}
+
+// similarity returns the share of tokens p has in common with tokens, which
+// has as many
+func (p *Pattern) similarity(tokens []string) float64 {
+	same := 0
+	for i, token := range tokens {
+		if p.tokens[i] == token || p.tokens[i] == placeholderAny {
+			same++
+		}
+	}
+	return float64(same) / float64(len(tokens))
+}
+
+// merge replaces the tokens of p differing from tokens with <*>
+func (p *Pattern) merge(tokens []string) {
+	for i, token := range tokens {
+		if p.tokens[i] != token {
+			p.tokens[i] = placeholderAny
+		}
+	}
+}
+
+// PatternSet clusters events by the fingerprint of their message.  Events
+// with the same fingerprint are in the same pattern, and so are events whose
+// fingerprints have as many tokens and share at least the similarity of them,
+// the differing tokens being replaced with <*> in the pattern.
+type PatternSet struct {
+	similarity float64
+	patterns   []*Pattern
+	byLength   map[int][]*Pattern
+	byPrint    map[string]*Pattern
+}
+
+// NewPatternSet returns an empty set clustering fingerprints with the given
+// similarity, from 0 to 1 where 1 only clusters identical fingerprints.  A
+// zero similarity is DefaultPatternSimilarity.
+func NewPatternSet(similarity float64) *PatternSet {
+	if similarity <= 0 {
+		similarity = DefaultPatternSimilarity
+	}
+	return &PatternSet{
+		similarity: similarity,
+		byLength:   map[int][]*Pattern{},
+		byPrint:    map[string]*Pattern{},
+	}
+}
+
+// Add counts e in the pattern of its message
+func (s *PatternSet) Add(e Event) {
+	fingerprint := Fingerprint(e.Message)
+	if p, ok := s.byPrint[fingerprint]; ok {
+		p.add(e)
+		return
+	}
+
+	tokens := strings.Fields(fingerprint)
+	var best *Pattern
+	bestSimilarity := 0.0
+	for _, p := range s.byLength[len(tokens)] {
+		if similarity := p.similarity(tokens); similarity > bestSimilarity {
+			best, bestSimilarity = p, similarity
+		}
+	}
+
+	if best != nil && bestSimilarity >= s.similarity {
+		best.merge(tokens)
+	} else {
+		best = &Pattern{
+			tokens:  tokens,
+			Levels:  map[string]int{},
+			Streams: map[string]int{},
+		}
+		s.patterns = append(s.patterns, best)
+		s.byLength[len(tokens)] = append(s.byLength[len(tokens)], best)
+	}
+	s.byPrint[fingerprint] = best
+	best.add(e)
+}
+
+// Len returns the number of patterns
+func (s *PatternSet) Len() int {
+	return len(s.patterns)
+}
+
+// Top returns the n patterns with the most events, or every pattern when n
+// is 0, ties ordered by first seen
+func (s *PatternSet) Top(n int) []*Pattern {
+	top := make([]*Pattern, len(s.patterns))
+	copy(top, s.patterns)
+	sort.SliceStable(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].First.Before(top[j].First)
+	})
+	if n > 0 && n < len(top) {
+		top = top[:n]
+	}
+	return top
+}
+
+// PatternReport is the JSON form of a Pattern
+type PatternReport struct {
+	Pattern string       `json:"pattern"`
+	Count   int          `json:"count"`
+	Level   string       `json:"level"`
+	Levels  []StatsCount `json:"levels"`
+	First   time.Time    `json:"first"`
+	Last    time.Time    `json:"last"`
+	Streams []StatsCount `json:"streams"`
+	Sample  outputEvent  `json:"sample"`
+}
+
+// Report returns the pattern in its JSON form, with the breakdowns sorted like
+// SortCounts and the sample in the schema of the json output format
+func (p *Pattern) Report() PatternReport {
+	return PatternReport{
+		Pattern: p.String(),
+		Count:   p.Count,
+		Level:   p.Level().String(),
+		Levels:  SortCounts(p.Levels, true),
+		First:   p.First.UTC(),
+		Last:    p.Last.UTC(),
+		Streams: SortCounts(p.Streams, false),
+		Sample:  newOutputEvent(p.Sample),
+	}
+}
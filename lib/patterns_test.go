@@ -0,0 +1,132 @@
+package lib
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"user 123 timed out after 5021ms", "user <num> timed out after <duration>"},
+		{"retrying in 1.5s", "retrying in <duration>"},
+		{"uptime 1h30m12s", "uptime <duration>"},
+		{"took 20 s", "took <num> s"},
+		{"status=503 size=12.5", "status=<num> size=<num>"},
+		{"request 0f4c5a3e-1b2c-4d5e-8f90-A1B2C3D4E5F6 failed", "request <uuid> failed"},
+		{"connect 10.0.1.12:5432: connection refused", "connect <ip>: connection refused"},
+		{"from 192.168.0.1 to 8.8.8.8", "from <ip> to <ip>"},
+		{"segfault at 0x7ffd5c2e", "segfault at <hex>"},
+		{"deployed commit 3f2a9c81d4e5", "deployed commit <hex>"},
+		// hexadecimal looking words need digits and letters
+		{"deadline deadbeef exceeded", "deadline deadbeef exceeded"},
+		{"order 12345678 shipped", "order <num> shipped"},
+		{`key "user:42 10.0.0.1" not found`, "key <str> not found"},
+		{`key 'it\'s 5ms' not found`, "key <str> not found"},
+		{`escaped "a \"b\" c" done`, "escaped <str> done"},
+		{"  spaced\tout  words ", "spaced out words"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := Fingerprint(test.message); got != test.want {
+			t.Errorf("Fingerprint(%q) = %q, want %q", test.message, got, test.want)
+		}
+	}
+}
+
+func TestPatternSetClusters(t *testing.T) {
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	add := func(s *PatternSet, message string) {
+		e := Event{Stream: "web"}
+		e.Message = message
+		e.Time = start
+		s.Add(e)
+	}
+	patterns := func(s *PatternSet) string {
+		names := []string{}
+		for _, p := range s.Top(0) {
+			names = append(names, fmt.Sprintf("%s:%d", p, p.Count))
+		}
+		return fmt.Sprintf("%q", names)
+	}
+
+	s := NewPatternSet(0)
+	// the same fingerprint
+	add(s, "user 1 timed out after 5ms")
+	add(s, "user 22 timed out after 1.5s")
+	// 7 tokens out of 10 in common is the default similarity
+	add(s, "a b c d e f g h i j")
+	add(s, "a b c d e f g x y z")
+	// the differing tokens match anything, 6 out of 10 in common
+	add(s, "p q r s e f g 1 2 3")
+	// fingerprints of different lengths are never clustered
+	add(s, "a b c d e f g")
+	want := `["user <num> timed out after <duration>:2" "a b c d e f g <*> <*> <*>:2" "p q r s e f g <num> <num> <num>:1" "a b c d e f g:1"]`
+	if got := patterns(s); got != want {
+		t.Errorf("patterns = %s, want %s", got, want)
+	}
+	if s.Len() != 4 {
+		t.Errorf("%d patterns, want 4", s.Len())
+	}
+
+	// a similarity of 1 only clusters identical fingerprints
+	s = NewPatternSet(1)
+	add(s, "a b c d e f g h i j")
+	add(s, "a b c d e f g h i z")
+	add(s, "a b c d e f g h i 42")
+	add(s, "a b c d e f g h i 7")
+	want = `["a b c d e f g h i <num>:2" "a b c d e f g h i j:1" "a b c d e f g h i z:1"]`
+	if got := patterns(s); got != want {
+		t.Errorf("patterns = %s, want %s", got, want)
+	}
+}
+
+func TestPatternSetTop(t *testing.T) {
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := NewPatternSet(0)
+	add := func(message string, seconds int, level ecslogs.Level, stream string) {
+		e := Event{Stream: stream}
+		e.Message = message
+		e.Time = start.Add(time.Duration(seconds) * time.Second)
+		e.Level = level
+		s.Add(e)
+	}
+
+	add("slow query", 5, ecslogs.WARN, "web")
+	add("cache miss", 2, ecslogs.NONE, "web")
+	add("slow query", 1, ecslogs.ERROR, "worker")
+	add("started", 0, ecslogs.INFO, "web")
+	add("cache miss", 3, ecslogs.DEBUG, "web")
+	add("slow query", 9, ecslogs.WARN, "web")
+	add("cache miss", 4, ecslogs.INFO, "worker")
+
+	// ties go to the pattern seen first in time, not in order of arrival
+	top := s.Top(0)
+	if got := fmt.Sprint(top); got != "[slow query cache miss started]" {
+		t.Errorf("top = %s", got)
+	}
+	if got := fmt.Sprint(s.Top(2)); got != "[slow query cache miss]" {
+		t.Errorf("top 2 = %s", got)
+	}
+	if got := fmt.Sprint(s.Top(10)); got != "[slow query cache miss started]" {
+		t.Errorf("top 10 = %s", got)
+	}
+
+	slow := top[0]
+	if slow.Count != 3 || !slow.First.Equal(start.Add(time.Second)) || !slow.Last.Equal(start.Add(9*time.Second)) {
+		t.Errorf("slow query: %d events from %s to %s", slow.Count, slow.First, slow.Last)
+	}
+	if slow.Level() != ecslogs.ERROR || slow.Sample.Level != ecslogs.WARN {
+		t.Errorf("slow query: level %s, sample %s", slow.Level(), slow.Sample.Level)
+	}
+	// events without a level count as INFO
+	r := top[1].Report()
+	if r.Level != "INFO" || fmt.Sprint(r.Levels) != "[{INFO 2} {DEBUG 1}]" || fmt.Sprint(r.Streams) != "[{web 2} {worker 1}]" {
+		t.Errorf("cache miss report = %+v", r)
+	}
+}
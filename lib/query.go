@@ -0,0 +1,533 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+// QueryError reports a syntax error in a query
+type QueryError struct {
+	Query string
+	// Column is the 1-based position of the offending character
+	Column  int
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("Invalid query at column %d: %s\n\n  %s\n  %s^", e.Column, e.Message, e.Query, strings.Repeat(" ", e.Column-1))
+}
+
+// Query is a compiled expression evaluated against events, such as
+//
+//	level >= WARN and (data.http.status >= 500 or message =~ /timeout/i)
+//
+// Fields are level, message, stream, task, group, id, time, info.host,
+// info.source, info.id, info.pid, info.uid, info.gid and data.<path>, where
+// data paths are the dotted keys of Event.DataFlat.  Operators are =, !=, <,
+// <=, >, >=, =~ and !~ (regular expressions, written /re/ or /re/i or as a
+// string), combined with and, or, not and parentheses.
+//
+// Levels compare by severity, `level >= WARN` keeps WARN, ERROR, CRIT, ALERT
+// and EMERG events, and events without a level count as INFO.  Numbers
+// compare numerically, strings lexically, and times accept the same values as
+// --since.  A field missing from an event only satisfies != and !~.
+type Query struct {
+	source string
+	root   queryNode
+}
+
+// ParseQuery compiles a query, returning a *QueryError if its syntax is
+// invalid
+func ParseQuery(query string) (*Query, error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{query: query, tokens: tokens}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, p.errorf(t, "expected 'and', 'or' or end of query")
+	}
+	return &Query{source: query, root: root}, nil
+}
+
+// String returns the query as given to ParseQuery
+func (q *Query) String() string {
+	return q.source
+}
+
+// Match reports whether an event satisfies the query
+func (q *Query) Match(e Event) bool {
+	return q.root.eval(&queryContext{event: e})
+}
+
+// queryContext lazily computes the flattened data of the event being matched
+type queryContext struct {
+	event Event
+	flat  map[string]interface{}
+}
+
+func (c *queryContext) data(path string) (interface{}, bool) {
+	if c.flat == nil {
+		c.flat = c.event.DataFlat()
+	}
+	value, ok := c.flat[path]
+	return value, ok
+}
+
+type queryNode interface {
+	eval(c *queryContext) bool
+}
+
+type queryAnd struct{ left, right queryNode }
+
+func (n queryAnd) eval(c *queryContext) bool { return n.left.eval(c) && n.right.eval(c) }
+
+type queryOr struct{ left, right queryNode }
+
+func (n queryOr) eval(c *queryContext) bool { return n.left.eval(c) || n.right.eval(c) }
+
+type queryNot struct{ node queryNode }
+
+func (n queryNot) eval(c *queryContext) bool { return !n.node.eval(c) }
+
+// --- tokens ---
+
+type queryToken struct {
+	kind  string
+	text  string
+	value string
+	col   int
+}
+
+func tokenizeQuery(query string) ([]queryToken, error) {
+	tokens := []queryToken{}
+	for i := 0; i < len(query); {
+		c := query[i]
+		col := i + 1
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case strings.HasPrefix(query[i:], "=="), strings.HasPrefix(query[i:], "!="),
+			strings.HasPrefix(query[i:], "<="), strings.HasPrefix(query[i:], ">="),
+			strings.HasPrefix(query[i:], "=~"), strings.HasPrefix(query[i:], "!~"),
+			strings.HasPrefix(query[i:], "&&"), strings.HasPrefix(query[i:], "||"):
+			op := query[i : i+2]
+			switch op {
+			case "==":
+				op = "="
+			case "&&":
+				op = "and"
+			case "||":
+				op = "or"
+			}
+			tokens = append(tokens, queryToken{kind: op, text: query[i : i+2], col: col})
+			i += 2
+		case strings.IndexByte("()=<>!", c) >= 0:
+			kind := string(c)
+			if c == '!' {
+				kind = "not"
+			}
+			tokens = append(tokens, queryToken{kind: kind, text: string(c), col: col})
+			i++
+		case c == '"' || c == '\'':
+			value, next, err := scanQueryString(query, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, queryToken{kind: "string", text: query[i:next], value: value, col: col})
+			i = next
+		case c == '/':
+			value, next, err := scanQueryRegexp(query, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, queryToken{kind: "regexp", text: query[i:next], value: value, col: col})
+			i = next
+		case isQueryWordByte(c):
+			start := i
+			for i < len(query) && isQueryWordByte(query[i]) {
+				i++
+			}
+			word := query[start:i]
+			kind := "word"
+			switch strings.ToLower(word) {
+			case "and", "or", "not":
+				kind = strings.ToLower(word)
+			}
+			tokens = append(tokens, queryToken{kind: kind, text: word, value: word, col: col})
+		default:
+			return nil, &QueryError{query, col, fmt.Sprintf("unexpected character '%c'", c)}
+		}
+	}
+	return append(tokens, queryToken{kind: "eof", col: len(query) + 1}), nil
+}
+
+func isQueryWordByte(c byte) bool {
+	return isWordByte(c) || c == '.' || c == '-' || c == ':' || c == '+' || c == '*' || c >= 0x80
+}
+
+func scanQueryString(query string, start int) (string, int, error) {
+	quote := query[start]
+	var text []byte
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if i+1 < len(query) {
+				i++
+				text = append(text, query[i])
+			}
+		case quote:
+			return string(text), i + 1, nil
+		default:
+			text = append(text, query[i])
+		}
+	}
+	return "", 0, &QueryError{query, start + 1, "unterminated string"}
+}
+
+// scanQueryRegexp reads /re/ or /re/i, returning the regular expression with
+// the flag folded in
+func scanQueryRegexp(query string, start int) (string, int, error) {
+	var text []byte
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if i+1 < len(query) && query[i+1] == '/' {
+				i++
+			} else if i+1 < len(query) {
+				text = append(text, query[i])
+				i++
+			}
+			text = append(text, query[i])
+		case '/':
+			next := i + 1
+			if next < len(query) && query[next] == 'i' {
+				return "(?i)" + string(text), next + 1, nil
+			}
+			return string(text), next, nil
+		default:
+			text = append(text, query[i])
+		}
+	}
+	return "", 0, &QueryError{query, start + 1, "unterminated regular expression"}
+}
+
+// --- parser ---
+
+type queryParser struct {
+	query  string
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() queryToken { return p.tokens[p.pos] }
+
+func (p *queryParser) next() queryToken {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *queryParser) errorf(t queryToken, format string, args ...interface{}) error {
+	found := "'" + t.text + "'"
+	if t.kind == "eof" {
+		found = "end of query"
+	}
+	return &QueryError{p.query, t.col, fmt.Sprintf(format, args...) + ", found " + found}
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == "or" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = queryOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == "and" {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = queryAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseNot() (queryNode, error) {
+	if p.peek().kind == "not" {
+		p.next()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return queryNot{node}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	t := p.next()
+	switch t.kind {
+	case "(":
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != ")" {
+			return nil, p.errorf(closing, "expected ')'")
+		}
+		return node, nil
+	case "word":
+		return p.parseComparison(t)
+	}
+	return nil, p.errorf(t, "expected a field name, 'not' or '('")
+}
+
+func (p *queryParser) parseComparison(field queryToken) (queryNode, error) {
+	getter, kind, err := queryField(field.value)
+	if err != nil {
+		return nil, &QueryError{p.query, field.col, err.Error()}
+	}
+
+	op := p.next()
+	switch op.kind {
+	case "=", "!=", "<", "<=", ">", ">=", "=~", "!~":
+	default:
+		return nil, p.errorf(op, "expected an operator (=, !=, <, <=, >, >=, =~, !~) after '%s'", field.value)
+	}
+
+	value := p.next()
+	if value.kind != "string" && value.kind != "word" && value.kind != "regexp" {
+		return nil, p.errorf(value, "expected a value after '%s'", op.text)
+	}
+
+	cmp := queryComparison{get: getter, op: op.kind}
+	if op.kind == "=~" || op.kind == "!~" {
+		re, err := regexp.Compile(value.value)
+		if err != nil {
+			return nil, &QueryError{p.query, value.col, fmt.Sprintf("invalid regular expression: %s", err)}
+		}
+		cmp.re = re
+		return cmp, nil
+	}
+	if value.kind == "regexp" {
+		return nil, p.errorf(value, "regular expressions can only be used with =~ and !~")
+	}
+
+	switch kind {
+	case queryLevel:
+		level, err := ecslogs.ParseLevel(value.value)
+		if err != nil {
+			return nil, &QueryError{p.query, value.col, fmt.Sprintf("unknown level '%s'", value.value)}
+		}
+		cmp.value = level
+	case queryTime:
+		t, err := GetTime(value.value, time.Now())
+		if err != nil {
+			return nil, &QueryError{p.query, value.col, fmt.Sprintf("invalid time '%s'", value.value)}
+		}
+		cmp.value = t
+	default:
+		cmp.value = queryLiteral(value)
+	}
+	return cmp, nil
+}
+
+// queryLiteral converts a value token into a float64, bool or string
+func queryLiteral(t queryToken) interface{} {
+	if t.kind == "word" {
+		if n, err := strconv.ParseFloat(t.value, 64); err == nil {
+			return n
+		}
+		switch t.value {
+		case "true":
+			return true
+		case "false":
+			return false
+		}
+	}
+	return t.value
+}
+
+// --- fields ---
+
+type queryFieldKind int
+
+const (
+	queryAny queryFieldKind = iota
+	queryLevel
+	queryTime
+)
+
+type queryGetter func(c *queryContext) (interface{}, bool)
+
+func queryField(name string) (queryGetter, queryFieldKind, error) {
+	if strings.HasPrefix(name, "data.") && len(name) > len("data.") {
+		path := name[len("data."):]
+		return func(c *queryContext) (interface{}, bool) { return c.data(path) }, queryAny, nil
+	}
+
+	switch name {
+	case "level":
+		// events without a level count as INFO, like they do for LevelRange
+		return func(c *queryContext) (interface{}, bool) {
+			if c.event.Level == ecslogs.NONE {
+				return ecslogs.INFO, true
+			}
+			return c.event.Level, true
+		}, queryLevel, nil
+	case "time":
+		return func(c *queryContext) (interface{}, bool) { return c.event.Time, true }, queryTime, nil
+	}
+
+	fields := map[string]func(e Event) interface{}{
+		"message":     func(e Event) interface{} { return e.Message },
+		"stream":      func(e Event) interface{} { return e.Stream },
+		"task":        func(e Event) interface{} { return e.TaskShort() },
+		"group":       func(e Event) interface{} { return e.Group },
+		"id":          func(e Event) interface{} { return e.ID },
+		"info.host":   func(e Event) interface{} { return e.Info.Host },
+		"info.source": func(e Event) interface{} { return e.Info.Source },
+		"info.id":     func(e Event) interface{} { return e.Info.ID },
+		"info.pid":    func(e Event) interface{} { return float64(e.Info.PID) },
+		"info.uid":    func(e Event) interface{} { return float64(e.Info.UID) },
+		"info.gid":    func(e Event) interface{} { return float64(e.Info.GID) },
+	}
+	if get, ok := fields[name]; ok {
+		return func(c *queryContext) (interface{}, bool) { return get(c.event), true }, queryAny, nil
+	}
+	return nil, queryAny, fmt.Errorf("unknown field '%s', expected level, message, stream, task, group, id, time, info.<field> or data.<path>", name)
+}
+
+// --- comparisons ---
+
+type queryComparison struct {
+	get   queryGetter
+	op    string
+	value interface{}
+	re    *regexp.Regexp
+}
+
+func (n queryComparison) eval(c *queryContext) bool {
+	value, ok := n.get(c)
+	if !ok || value == nil {
+		return n.op == "!=" || n.op == "!~"
+	}
+
+	if n.re != nil {
+		matched := n.re.MatchString(queryString(value))
+		return matched == (n.op == "=~")
+	}
+
+	var cmp int
+	switch expected := n.value.(type) {
+	case ecslogs.Level:
+		// lower levels are more severe, compare by severity
+		cmp = compareInts(int(expected), int(value.(ecslogs.Level)))
+	case time.Time:
+		actual := value.(time.Time)
+		switch {
+		case actual.Before(expected):
+			cmp = -1
+		case actual.After(expected):
+			cmp = 1
+		}
+	case float64:
+		actual, ok := queryNumber(value)
+		if !ok {
+			return n.op == "!="
+		}
+		switch {
+		case actual < expected:
+			cmp = -1
+		case actual > expected:
+			cmp = 1
+		}
+	case bool:
+		if n.op != "=" && n.op != "!=" {
+			return false
+		}
+		equal := queryString(value) == strconv.FormatBool(expected)
+		return equal == (n.op == "=")
+	default:
+		cmp = strings.Compare(queryString(value), queryString(expected))
+	}
+
+	switch n.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func queryNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func queryString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+func TestQueryLevel(t *testing.T) {
+	levels := []ecslogs.Level{ecslogs.DEBUG, ecslogs.INFO, ecslogs.NONE, ecslogs.WARN, ecslogs.ERROR}
+	tests := []struct {
+		query string
+		want  []bool
+	}{
+		{"level >= WARN", []bool{false, false, false, true, true}},
+		{"level >= INFO", []bool{false, true, true, true, true}},
+		{"level = INFO", []bool{false, true, true, false, false}},
+		{"level != INFO", []bool{true, false, false, true, true}},
+		{"level < INFO", []bool{true, false, false, false, false}},
+	}
+
+	for _, test := range tests {
+		q, err := ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for ix, level := range levels {
+			var e Event
+			e.Level = level
+			if got := q.Match(e); got != test.want[ix] {
+				t.Errorf("%q on a %s event = %v, want %v", test.query, level, got, test.want[ix])
+			}
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	e := Event{Stream: "web-3", Group: "api", ID: "42"}
+	e.Level = ecslogs.ERROR
+	e.Time = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	e.Message = "GET /users timed out"
+	e.Info.Host = "web-1"
+	e.Info.PID = 42
+	e.Data = ecslogs.EventData{
+		"http":  map[string]interface{}{"status": float64(503), "method": "GET"},
+		"user":  map[string]interface{}{"id": "u-7"},
+		"retry": true,
+	}
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		// dotted data paths
+		{`data.http.status >= 500`, true},
+		{`data.http.status = 503`, true},
+		{`data.http.status == 503`, true},
+		{`data.http.status < 500`, false},
+		{`data.http.status = "503"`, true},
+		{`data.http.method = GET`, true},
+		{`data.http.method = "GET"`, true},
+		{`data.http.method > "A"`, true},
+		{`data.user.id = u-7`, true},
+		{`data.retry = true`, true},
+		{`data.retry != true`, false},
+		{`data.http = 503`, false},
+		// missing fields only satisfy != and !~
+		{`data.missing = 1`, false},
+		{`data.missing != 1`, true},
+		{`data.missing =~ /x/`, false},
+		{`data.missing !~ /x/`, true},
+
+		// regular expressions
+		{`message =~ /timed out/`, true},
+		{`message =~ /TIMED/`, false},
+		{`message =~ /TIMED/i`, true},
+		{`message !~ /timeout/`, true},
+		{`message =~ "^GET /users"`, true},
+		{`message =~ /^GET \/users/`, true},
+		{`data.http.status =~ /^5/`, true},
+
+		// other fields
+		{`info.host = web-1`, true},
+		{`info.host != web-1`, false},
+		{`info.host =~ /^web-/`, true},
+		{`info.pid = 42`, true},
+		{`info.pid > 100`, false},
+		{`info.source = ""`, true},
+		{`stream = web-3`, true},
+		{`stream = "web"`, false},
+		{`group = api`, true},
+		{`id = 42`, true},
+		{`time > 2017-03-01T11:00:00Z`, true},
+		{`time >= 2017-03-01T12:00:01Z`, false},
+
+		// and, or, not and parentheses
+		{`level >= WARN and data.http.status >= 500`, true},
+		{`level >= WARN && data.http.status < 500`, false},
+		{`level = INFO or data.http.status = 503`, true},
+		{`level = INFO || info.pid = 7`, false},
+		{`not level = ERROR`, false},
+		{`!(level = ERROR)`, false},
+		{`not (level < WARN or info.host = web-2)`, true},
+		{`not not level = ERROR`, true},
+		// and binds tighter than or
+		{`level = INFO and data.http.status = 503 or message =~ /timed/`, true},
+		{`level = INFO and (data.http.status = 503 or message =~ /timed/)`, false},
+		{`(level = INFO or level = ERROR) and (info.host = web-2 or data.retry = true)`, true},
+		{`level >= warn AND NOT info.host = web-2`, true},
+	}
+
+	for _, test := range tests {
+		q, err := ParseQuery(test.query)
+		if err != nil {
+			t.Errorf("%q: %s", test.query, err)
+			continue
+		}
+		if got := q.Match(e); got != test.want {
+			t.Errorf("%q = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	tests := []struct {
+		query   string
+		column  int
+		message string
+	}{
+		{`level >`, 8, `expected a value after '>', found end of query`},
+		{`levle = INFO`, 1, `unknown field 'levle', expected level, message, stream, task, group, id, time, info.<field> or data.<path>`},
+		{`data. = 1`, 1, `unknown field 'data.'`},
+		{`info.hostname = web-1`, 1, `unknown field 'info.hostname'`},
+		{`level = LOUD`, 9, `unknown level 'LOUD'`},
+		{`time > never`, 8, `invalid time 'never'`},
+		{`message =~ /(/`, 12, `invalid regular expression: `},
+		{`message = /x/`, 11, `regular expressions can only be used with =~ and !~, found '/x/'`},
+		{`(level = INFO`, 14, `expected ')', found end of query`},
+		{`(level = INFO or (stream = a)`, 30, `expected ')', found end of query`},
+		{`level = INFO info.host = x`, 14, `expected 'and', 'or' or end of query, found 'info.host'`},
+		{`level INFO`, 7, `expected an operator (=, !=, <, <=, >, >=, =~, !~) after 'level', found 'INFO'`},
+		{`and level = INFO`, 1, `expected a field name, 'not' or '(', found 'and'`},
+		{`level = INFO or`, 16, `expected a field name, 'not' or '(', found end of query`},
+		{`not`, 4, `expected a field name, 'not' or '(', found end of query`},
+		{`message = "open`, 11, `unterminated string`},
+		{`message =~ /open`, 12, `unterminated regular expression`},
+		{`level = INFO; x`, 13, `unexpected character ';'`},
+	}
+
+	for _, test := range tests {
+		_, err := ParseQuery(test.query)
+		qerr, ok := err.(*QueryError)
+		if !ok {
+			t.Errorf("%q: error = %v, want a *QueryError", test.query, err)
+			continue
+		}
+		if qerr.Column != test.column || !strings.HasPrefix(qerr.Message, test.message) {
+			t.Errorf("%q: error at column %d %q, want column %d %q", test.query, qerr.Column, qerr.Message, test.column, test.message)
+		}
+	}
+
+	_, err := ParseQuery(`level >= LOUD`)
+	want := "Invalid query at column 10: unknown level 'LOUD'\n\n  level >= LOUD\n           ^"
+	if err == nil || err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
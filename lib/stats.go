@@ -0,0 +1,150 @@
+package lib
+
+import (
+	"sort"
+	"time"
+
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+// Stats summarizes a set of events.  Events without a level count as INFO,
+// like they do for LevelRange, and events without a host count under "-".
+// Errors are counted by type, once per error of Info.Errors, errors without a
+// type count under "-".
+type Stats struct {
+	Events  int
+	Bytes   int64
+	First   time.Time
+	Last    time.Time
+	Levels  map[string]int
+	Streams map[string]int
+	Hosts   map[string]int
+	Errors  map[string]int
+}
+
+// NewStats returns empty stats
+func NewStats() *Stats {
+	return &Stats{
+		Levels:  map[string]int{},
+		Streams: map[string]int{},
+		Hosts:   map[string]int{},
+		Errors:  map[string]int{},
+	}
+}
+
+// Add counts e
+func (s *Stats) Add(e Event) {
+	s.Events++
+	s.Bytes += int64(e.Size)
+	if s.First.IsZero() || e.Time.Before(s.First) {
+		s.First = e.Time
+	}
+	if e.Time.After(s.Last) {
+		s.Last = e.Time
+	}
+
+	level := e.Level
+	if level == ecslogs.NONE {
+		level = ecslogs.INFO
+	}
+	s.Levels[level.String()]++
+	s.Streams[e.TaskShort()]++
+	s.Hosts[statsName(e.Info.Host)]++
+	for _, err := range e.Info.Errors {
+		s.Errors[statsName(err.Type)]++
+	}
+}
+
+// Duration is the time between the first and last events
+func (s *Stats) Duration() time.Duration {
+	return s.Last.Sub(s.First)
+}
+
+// EventsPerSecond is the average rate of events between the first and last
+// events, which are taken to be at least a second apart
+func (s *Stats) EventsPerSecond() float64 {
+	return float64(s.Events) / s.seconds()
+}
+
+// BytesPerSecond is the average rate of bytes between the first and last
+// events, which are taken to be at least a second apart
+func (s *Stats) BytesPerSecond() float64 {
+	return float64(s.Bytes) / s.seconds()
+}
+
+func (s *Stats) seconds() float64 {
+	if seconds := s.Duration().Seconds(); seconds > 1 {
+		return seconds
+	}
+	return 1
+}
+
+func statsName(name string) string {
+	if name == "" {
+		return "-"
+	}
+	return name
+}
+
+// StatsCount is a line of a breakdown of Stats
+type StatsCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// SortCounts returns counts ordered from the largest, ties ordered by name.
+// Levels are ordered by severity instead.
+func SortCounts(counts map[string]int, byLevel bool) []StatsCount {
+	sorted := make([]StatsCount, 0, len(counts))
+	for name, count := range counts {
+		sorted = append(sorted, StatsCount{Name: name, Count: count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if byLevel {
+			a, _ := ecslogs.ParseLevel(sorted[i].Name)
+			b, _ := ecslogs.ParseLevel(sorted[j].Name)
+			return a < b
+		}
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// StatsReport is the JSON form of Stats
+type StatsReport struct {
+	Events          int          `json:"events"`
+	Bytes           int64        `json:"bytes"`
+	First           *time.Time   `json:"first"`
+	Last            *time.Time   `json:"last"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	EventsPerSecond float64      `json:"events_per_second"`
+	BytesPerSecond  float64      `json:"bytes_per_second"`
+	Levels          []StatsCount `json:"levels"`
+	Streams         []StatsCount `json:"streams"`
+	Hosts           []StatsCount `json:"hosts"`
+	Errors          []StatsCount `json:"errors"`
+}
+
+// Report returns the stats in their JSON form, with the breakdowns sorted
+// like SortCounts and null times when there are no events
+func (s *Stats) Report() StatsReport {
+	report := StatsReport{
+		Events:  s.Events,
+		Bytes:   s.Bytes,
+		Levels:  SortCounts(s.Levels, true),
+		Streams: SortCounts(s.Streams, false),
+		Hosts:   SortCounts(s.Hosts, false),
+		Errors:  SortCounts(s.Errors, false),
+	}
+	if s.Events > 0 {
+		first, last := s.First.UTC(), s.Last.UTC()
+		report.First, report.Last = &first, &last
+		report.DurationSeconds = s.Duration().Seconds()
+		report.EventsPerSecond = s.EventsPerSecond()
+		report.BytesPerSecond = s.BytesPerSecond()
+	}
+	return report
+}
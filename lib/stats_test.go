@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+func TestStats(t *testing.T) {
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := NewStats()
+	add := func(seconds int, level ecslogs.Level, stream string, host string, errorTypes ...string) {
+		e := Event{Stream: stream, Size: 100}
+		e.Time = start.Add(time.Duration(seconds) * time.Second)
+		e.Level = level
+		e.Info.Host = host
+		for _, errorType := range errorTypes {
+			e.Info.Errors = append(e.Info.Errors, ecslogs.EventError{Type: errorType})
+		}
+		s.Add(e)
+	}
+
+	// a single event is taken to last a second
+	add(10, ecslogs.ERROR, "web", "web-1", "timeout", "")
+	if s.Duration() != 0 || s.EventsPerSecond() != 1 || s.BytesPerSecond() != 100 {
+		t.Errorf("one event: %s, %.2f events/s, %.2f bytes/s", s.Duration(), s.EventsPerSecond(), s.BytesPerSecond())
+	}
+
+	add(0, ecslogs.NONE, "0f4c5a3e-1b2c-4d5e-8f90-a1b2c3d4e5f6", "")
+	add(20, ecslogs.INFO, "web", "web-1")
+	add(5, ecslogs.WARN, "web", "web-2", "timeout")
+
+	if s.Events != 4 || s.Bytes != 400 {
+		t.Errorf("%d events, %d bytes, want 4 and 400", s.Events, s.Bytes)
+	}
+	if !s.First.Equal(start) || !s.Last.Equal(start.Add(20*time.Second)) || s.Duration() != 20*time.Second {
+		t.Errorf("first %s, last %s, duration %s", s.First, s.Last, s.Duration())
+	}
+	if s.EventsPerSecond() != 0.2 || s.BytesPerSecond() != 20 {
+		t.Errorf("%.2f events/s, %.2f bytes/s, want 0.2 and 20", s.EventsPerSecond(), s.BytesPerSecond())
+	}
+
+	tests := []struct {
+		name   string
+		counts []StatsCount
+		want   string
+	}{
+		// events without a level count as INFO
+		{"levels", SortCounts(s.Levels, true), "[{ERROR 1} {WARN 1} {INFO 2}]"},
+		// task streams are shortened
+		{"streams", SortCounts(s.Streams, false), "[{web 3} {0f4c5a3e 1}]"},
+		{"hosts", SortCounts(s.Hosts, false), "[{web-1 2} {- 1} {web-2 1}]"},
+		{"errors", SortCounts(s.Errors, false), "[{timeout 2} {- 1}]"},
+	}
+	for _, test := range tests {
+		if got := fmt.Sprint(test.counts); got != test.want {
+			t.Errorf("%s = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestStatsReport(t *testing.T) {
+	report, err := json.Marshal(NewStats().Report())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"events":0,"bytes":0,"first":null,"last":null,"duration_seconds":0,"events_per_second":0,"bytes_per_second":0,"levels":[],"streams":[],"hosts":[],"errors":[]}`
+	if string(report) != want {
+		t.Errorf("empty report = %s, want %s", report, want)
+	}
+
+	s := NewStats()
+	start := time.Date(2017, 3, 1, 12, 0, 0, 0, time.FixedZone("PST", -8*3600))
+	for i := 0; i < 3; i++ {
+		e := Event{Stream: "web", Size: 10}
+		e.Time = start.Add(time.Duration(i) * 2 * time.Second)
+		s.Add(e)
+	}
+	r := s.Report()
+	if r.First == nil || r.First.Location() != time.UTC || !r.First.Equal(start) || !r.Last.Equal(start.Add(4*time.Second)) {
+		t.Errorf("report from %v to %v", r.First, r.Last)
+	}
+	if r.DurationSeconds != 4 || r.EventsPerSecond != 0.75 || r.BytesPerSecond != 7.5 {
+		t.Errorf("report = %+v", r)
+	}
+}
@@ -0,0 +1,150 @@
+package lib
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// streamSet tracks the streams a following reader filters on, along with the
+// last time activity was seen on each of them
+type streamSet struct {
+	active     map[string]bool
+	lastActive map[string]int64
+}
+
+func newStreamSet(streams []*cloudwatchlogs.LogStream) *streamSet {
+	s := &streamSet{
+		active:     map[string]bool{},
+		lastActive: map[string]int64{},
+	}
+	for _, stream := range streams {
+		s.active[*stream.LogStreamName] = true
+		s.observe(*stream.LogStreamName, streamActivity(stream))
+	}
+	return s
+}
+
+// observe records activity on a stream at the given timestamp (in ms)
+func (s *streamSet) observe(name string, timestamp int64) {
+	if timestamp > s.lastActive[name] {
+		s.lastActive[name] = timestamp
+	}
+}
+
+// update merges freshly discovered streams into the set.  Streams with no
+// activity since cutoff leave the set, and at most max of the most recently
+// active streams are kept.
+func (s *streamSet) update(discovered []*cloudwatchlogs.LogStream, cutoff int64, max int) (joined []string, left []string) {
+	for _, stream := range discovered {
+		s.observe(*stream.LogStreamName, streamActivity(stream))
+	}
+
+	candidates := []string{}
+	for name, last := range s.lastActive {
+		if last >= cutoff {
+			candidates = append(candidates, name)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if s.lastActive[candidates[i]] != s.lastActive[candidates[j]] {
+			return s.lastActive[candidates[i]] > s.lastActive[candidates[j]]
+		}
+		return candidates[i] < candidates[j]
+	})
+	if len(candidates) > max {
+		candidates = candidates[:max]
+	}
+
+	next := map[string]bool{}
+	for _, name := range candidates {
+		next[name] = true
+		if !s.active[name] {
+			joined = append(joined, name)
+		}
+	}
+	for name := range s.active {
+		if !next[name] {
+			left = append(left, name)
+		}
+	}
+	sort.Strings(left)
+
+	// forget about streams that are neither active nor recent
+	for name, last := range s.lastActive {
+		if !next[name] && last < cutoff {
+			delete(s.lastActive, name)
+		}
+	}
+
+	s.active = next
+	return joined, left
+}
+
+// names returns the active stream names, sorted
+func (s *streamSet) names() []*string {
+	names := make([]string, 0, len(s.active))
+	for name := range s.active {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return aws.StringSlice(names)
+}
+
+// streamActivity returns the most recent timestamp known for a stream.  The
+// last event timestamp is only eventually consistent, so the creation and
+// ingestion times are considered as well.
+func streamActivity(stream *cloudwatchlogs.LogStream) int64 {
+	var last int64
+	for _, ts := range []*int64{stream.CreationTime, stream.LastEventTimestamp, stream.LastIngestionTime} {
+		if ts != nil && *ts > last {
+			last = *ts
+		}
+	}
+	return last
+}
+
+// discoverStreams lists every stream of the group matching the reader's
+// prefix
+func (c *CloudwatchLogsReader) discoverStreams(ctx context.Context) ([]*cloudwatchlogs.LogStream, error) {
+	params := &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        aws.String(c.logGroupName),
+		LogStreamNamePrefix: aws.String(c.streamPrefix),
+	}
+
+	streams := []*cloudwatchlogs.LogStream{}
+	err := c.svc.DescribeLogStreamsPagesWithContext(ctx, params, func(o *cloudwatchlogs.DescribeLogStreamsOutput, lastPage bool) bool {
+		streams = append(streams, o.LogStreams...)
+		return !lastPage
+	})
+	return streams, err
+}
+
+// refreshStreams re-runs stream discovery and updates the set of followed
+// streams, returning true if it changed.  Discovery errors are reported like
+// retries, since discovery runs again on the next interval.
+func (c *CloudwatchLogsReader) refreshStreams(ctx context.Context, set *streamSet, now time.Time) bool {
+	discovered, err := c.discoverStreams(ctx)
+	if err != nil {
+		// keep following the current streams, discovery is retried on the
+		// next interval
+		if !IsCanceled(err) {
+			c.svc.NotifyBackoff("DescribeLogStreams", err, c.discoveryInterval)
+		}
+		return false
+	}
+
+	cutoff := (now.Add(-c.idleStreamTimeout).UnixNano()) / int64(time.Millisecond)
+	joined, left := set.update(discovered, cutoff, c.maxStreams)
+	if len(joined) == 0 && len(left) == 0 {
+		return false
+	}
+
+	if c.onStreamsChanged != nil {
+		c.onStreamsChanged(c.logGroupName, joined, left)
+	}
+	return true
+}
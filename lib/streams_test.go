@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+func TestStreamSetUpdate(t *testing.T) {
+	stream := func(name string, lastEvent int64) *cloudwatchlogs.LogStream {
+		return &cloudwatchlogs.LogStream{
+			LogStreamName:      aws.String(name),
+			CreationTime:       aws.Int64(0),
+			LastEventTimestamp: aws.Int64(lastEvent),
+		}
+	}
+
+	s := newStreamSet([]*cloudwatchlogs.LogStream{stream("a", 100), stream("b", 200)})
+	if got := aws.StringValueSlice(s.names()); fmt.Sprint(got) != "[a b]" {
+		t.Fatalf("names = %v, want [a b]", got)
+	}
+
+	tests := []struct {
+		name       string
+		discovered []*cloudwatchlogs.LogStream
+		observed   map[string]int64
+		cutoff     int64
+		max        int
+		joined     string
+		left       string
+		names      string
+	}{
+		{
+			name:       "new stream joins",
+			discovered: []*cloudwatchlogs.LogStream{stream("a", 100), stream("b", 200), stream("c", 300)},
+			cutoff:     50, max: 10,
+			joined: "[c]", left: "[]", names: "[a b c]",
+		},
+		{
+			name:   "unchanged",
+			cutoff: 50, max: 10,
+			joined: "[]", left: "[]", names: "[a b c]",
+		},
+		{
+			name:   "idle stream leaves",
+			cutoff: 150, max: 10,
+			joined: "[]", left: "[a]", names: "[b c]",
+		},
+		{
+			// events seen while filtering count as activity
+			name:     "stream kept active by its events",
+			observed: map[string]int64{"b": 400},
+			cutoff:   250, max: 10,
+			joined: "[]", left: "[]", names: "[b c]",
+		},
+		{
+			name:       "the most recently active streams are kept",
+			discovered: []*cloudwatchlogs.LogStream{stream("d", 500), stream("e", 450)},
+			cutoff:     250, max: 2,
+			joined: "[d e]", left: "[b c]", names: "[d e]",
+		},
+		{
+			name:   "trimmed streams come back",
+			cutoff: 250, max: 4,
+			joined: "[b c]", left: "[]", names: "[b c d e]",
+		},
+		{
+			// a stream that went idle has to be discovered again
+			name:   "forgotten stream",
+			cutoff: 1000, max: 4,
+			joined: "[]", left: "[b c d e]", names: "[]",
+		},
+		{
+			name:       "rediscovered stream",
+			discovered: []*cloudwatchlogs.LogStream{stream("a", 1200)},
+			cutoff:     1000, max: 4,
+			joined: "[a]", left: "[]", names: "[a]",
+		},
+	}
+	for _, test := range tests {
+		for name, timestamp := range test.observed {
+			s.observe(name, timestamp)
+		}
+		joined, left := s.update(test.discovered, test.cutoff, test.max)
+		if fmt.Sprint(joined) != test.joined || fmt.Sprint(left) != test.left {
+			t.Errorf("%s: joined %v and left %v, want %s and %s", test.name, joined, left, test.joined, test.left)
+		}
+		if got := fmt.Sprint(aws.StringValueSlice(s.names())); got != test.names {
+			t.Errorf("%s: names = %s, want %s", test.name, got, test.names)
+		}
+	}
+	if len(s.lastActive) != 1 {
+		t.Errorf("idle streams remembered: %v", s.lastActive)
+	}
+}
+
+func TestStreamActivity(t *testing.T) {
+	s := &cloudwatchlogs.LogStream{CreationTime: aws.Int64(300)}
+	if got := streamActivity(s); got != 300 {
+		t.Errorf("activity of a new stream = %d, want 300", got)
+	}
+	s.LastEventTimestamp = aws.Int64(200)
+	s.LastIngestionTime = aws.Int64(500)
+	if got := streamActivity(s); got != 500 {
+		t.Errorf("activity = %d, want the ingestion time 500", got)
+	}
+}
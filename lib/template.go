@@ -0,0 +1,254 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// TemplateFunc documents a function available to event templates
+type TemplateFunc struct {
+	Name  string
+	Usage string
+	Doc   string
+	Func  interface{}
+}
+
+// TemplateFuncs are the functions available to event templates.  Functions
+// taking options put them first, so that `{{ .Message | truncate 80 }}`
+// works in pipelines.
+var TemplateFuncs = []TemplateFunc{
+	{"red", "red TEXT", "Colors text red", Red},
+	{"green", "green TEXT", "Colors text green", Green},
+	{"yellow", "yellow TEXT", "Colors text yellow", Yellow},
+	{"blue", "blue TEXT", "Colors text blue", Blue},
+	{"magenta", "magenta TEXT", "Colors text magenta", Magenta},
+	{"cyan", "cyan TEXT", "Colors text cyan", Cyan},
+	{"white", "white TEXT", "Colors text white", White},
+	{"highlight", "highlight TEXT", "Colors text like --grep matches", Highlight},
+	{"uniquecolor", "uniquecolor TEXT", "Colors text with a color picked from the text, the same text always gets the same color", Unique},
+	{"colorlevel", "colorlevel LEVEL", "Colors a level by severity", ColorLevel},
+	{"json", "json VALUE", "Encodes a value as compact JSON", templateJSON},
+	{"toJSON", "toJSON VALUE", "Same as json", templateJSON},
+	{"get", `get "PATH" EVENT`, "Looks up a field of an event by name, such as data.http.status or info.host, see --fields", templateGet},
+	{"default", "default FALLBACK VALUE", "Returns FALLBACK when VALUE is missing, empty or zero", templateDefault},
+	{"truncate", "truncate N TEXT", "Cuts TEXT to N characters, ending with ... when it was longer", templateTruncate},
+	{"pad", "pad N TEXT", "Pads TEXT with spaces on the right to N characters", templatePad},
+	{"padLeft", "padLeft N TEXT", "Pads TEXT with spaces on the left to N characters", templatePadLeft},
+	{"upper", "upper TEXT", "Upper cases TEXT", templateUpper},
+	{"lower", "lower TEXT", "Lower cases TEXT", templateLower},
+	{"timefmt", `timefmt "LAYOUT" TIME`, "Formats a time in the local time zone with a Go layout such as 15:04:05.000", templateTimefmt},
+	{"since", "since TIME", "Age of a time rounded to the second, such as 3m12s", templateSince},
+	{"utc", "utc TIME", "Converts a time to UTC", templateUTC},
+	{"duration", "duration VALUE", "Formats a duration given as nanoseconds or as a string such as 1500ms", templateDuration},
+	{"hasPrefix", `hasPrefix "PREFIX" TEXT`, "Reports whether TEXT starts with PREFIX", templateHasPrefix},
+	{"contains", `contains "SUBSTRING" TEXT`, "Reports whether TEXT contains SUBSTRING", templateContains},
+	{"regexReplace", `regexReplace "REGEXP" "REPLACEMENT" TEXT`, "Replaces the matches of a regular expression, REPLACEMENT can refer to groups as $1", templateRegexReplace},
+	{"indent", "indent N TEXT", "Indents every line of TEXT with N spaces", templateIndent},
+}
+
+// TemplateFuncMap returns TemplateFuncs as a template.FuncMap
+func TemplateFuncMap() template.FuncMap {
+	funcs := template.FuncMap{}
+	for _, f := range TemplateFuncs {
+		funcs[f.Name] = f.Func
+	}
+	return funcs
+}
+
+// templateText converts a template value to a string
+func templateText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return outputValue(v, true)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return outputValue(value, true)
+}
+
+func templateJSON(value interface{}) (string, error) {
+	b, err := json.Marshal(value)
+	return string(b), err
+}
+
+func templateGet(path string, e Event) (interface{}, error) {
+	get, err := outputField(path)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := get(e)
+	if !ok {
+		return nil, nil
+	}
+	return value, nil
+}
+
+func templateDefault(fallback interface{}, value interface{}) interface{} {
+	if value == nil {
+		return fallback
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Map, reflect.Slice, reflect.Array:
+		if v.Len() == 0 {
+			return fallback
+		}
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return fallback
+		}
+	default:
+		if reflect.DeepEqual(value, reflect.Zero(v.Type()).Interface()) {
+			return fallback
+		}
+	}
+	return value
+}
+
+func templateTruncate(n int, value interface{}) string {
+	text := templateText(value)
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	if n <= 3 {
+		return string([]rune(text)[:n])
+	}
+	return string([]rune(text)[:n-3]) + "..."
+}
+
+func templatePad(n int, value interface{}) string {
+	text := templateText(value)
+	if missing := n - utf8.RuneCountInString(text); missing > 0 {
+		return text + strings.Repeat(" ", missing)
+	}
+	return text
+}
+
+func templatePadLeft(n int, value interface{}) string {
+	text := templateText(value)
+	if missing := n - utf8.RuneCountInString(text); missing > 0 {
+		return strings.Repeat(" ", missing) + text
+	}
+	return text
+}
+
+func templateUpper(value interface{}) string {
+	return strings.ToUpper(templateText(value))
+}
+
+func templateLower(value interface{}) string {
+	return strings.ToLower(templateText(value))
+}
+
+func templateTimefmt(layout string, t time.Time) string {
+	return t.Local().Format(layout)
+}
+
+func templateSince(t time.Time) string {
+	return (time.Since(t) / time.Second * time.Second).String()
+}
+
+func templateUTC(t time.Time) time.Time {
+	return t.UTC()
+}
+
+func templateDuration(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case time.Duration:
+		return v.String(), nil
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			if n, numErr := strconv.ParseFloat(v, 64); numErr == nil {
+				return time.Duration(n).String(), nil
+			}
+			return "", err
+		}
+		return d.String(), nil
+	case nil:
+		return "", nil
+	}
+	if n, ok := queryNumber(value); ok {
+		return time.Duration(n).String(), nil
+	}
+	return "", fmt.Errorf("duration: can't use %T as a duration", value)
+}
+
+func templateHasPrefix(prefix string, value interface{}) bool {
+	return strings.HasPrefix(templateText(value), prefix)
+}
+
+func templateContains(substring string, value interface{}) bool {
+	return strings.Contains(templateText(value), substring)
+}
+
+func templateRegexReplace(pattern string, replacement string, value interface{}) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(templateText(value), replacement), nil
+}
+
+func templateIndent(n int, value interface{}) string {
+	prefix := strings.Repeat(" ", n)
+	return prefix + strings.Replace(templateText(value), "\n", "\n"+prefix, -1)
+}
+
+// TemplateField describes a field or method of Event available to templates
+type TemplateField struct {
+	Path string
+	Type string
+}
+
+// TemplateFields lists the fields of Event, including the nested fields of
+// Info, and the methods of Event taking no arguments.  Slices of structs are
+// listed with a [] suffix, their fields are reached with range.
+func TemplateFields() []TemplateField {
+	fields := []TemplateField{}
+	var walk func(prefix string, t reflect.Type)
+	walk = func(prefix string, t reflect.Type) {
+		for ix := 0; ix < t.NumField(); ix++ {
+			f := t.Field(ix)
+			// fields left out of the JSON of events are bookkeeping
+			if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+				continue
+			}
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				walk(prefix, f.Type)
+				continue
+			}
+
+			path := prefix + "." + f.Name
+			fields = append(fields, TemplateField{Path: path, Type: f.Type.String()})
+			switch {
+			case f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(time.Time{}):
+				walk(path, f.Type)
+			case f.Type.Kind() == reflect.Slice && f.Type.Elem().Kind() == reflect.Struct:
+				walk(path+"[]", f.Type.Elem())
+			}
+		}
+	}
+	t := reflect.TypeOf(Event{})
+	walk("", t)
+
+	for ix := 0; ix < t.NumMethod(); ix++ {
+		m := t.Method(ix)
+		// the receiver is the only input
+		if m.Type.NumIn() == 1 && m.Type.NumOut() == 1 {
+			fields = append(fields, TemplateField{Path: "." + m.Name, Type: m.Type.Out(0).String()})
+		}
+	}
+	return fields
+}
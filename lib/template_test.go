@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+	"time"
+
+	ecslogs "github.com/segmentio/ecs-logs-go"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	created := time.Date(2017, 3, 1, 12, 30, 15, 0, time.FixedZone("PST", -8*3600))
+	e := Event{
+		Stream:       "web/1234",
+		CreationTime: created,
+	}
+	e.Level = ecslogs.INFO
+	e.Time = time.Now().Add(-3*time.Minute - 12*time.Second - 400*time.Millisecond)
+	e.Message = "GET /users took 15ms"
+	e.Info.Host = "web-1"
+	e.Data = ecslogs.EventData{
+		"http":    map[string]interface{}{"status": float64(503)},
+		"elapsed": "1500ms",
+		"nanos":   float64(2e9),
+		"empty":   "",
+		"zero":    float64(0),
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		err      bool
+	}{
+		{name: "json", template: `{{ json .Data.http }}`, want: `{"status":503}`},
+		{name: "toJSON", template: `{{ toJSON .Info.Host }}`, want: `"web-1"`},
+		{name: "json nil", template: `{{ json (get "data.missing" .) }}`, want: `null`},
+
+		{name: "get data", template: `{{ get "data.http.status" . }}`, want: `503`},
+		{name: "get data without prefix", template: `{{ get "elapsed" . }}`, err: true},
+		{name: "get info", template: `{{ get "info.host" . }}`, want: `web-1`},
+		{name: "get missing", template: `{{ get "data.missing" . }}`, want: `<no value>`},
+		{name: "get empty path", template: `{{ get "" . }}`, err: true},
+
+		{name: "default missing", template: `{{ default "none" (get "data.missing" .) }}`, want: `none`},
+		{name: "default empty", template: `{{ default "none" (get "data.empty" .) }}`, want: `none`},
+		{name: "default zero", template: `{{ default "none" (get "data.zero" .) }}`, want: `none`},
+		{name: "default set", template: `{{ default "none" (get "data.http.status" .) }}`, want: `503`},
+		{name: "default pipeline", template: `{{ .Message | default "none" }}`, want: `GET /users took 15ms`},
+
+		{name: "truncate", template: `{{ truncate 8 .Message }}`, want: `GET /...`},
+		{name: "truncate short", template: `{{ truncate 80 .Message }}`, want: `GET /users took 15ms`},
+		{name: "truncate exact", template: `{{ truncate 5 "hello" }}`, want: `hello`},
+		{name: "truncate 3", template: `{{ truncate 3 "hello" }}`, want: `hel`},
+		{name: "truncate 1", template: `{{ truncate 1 "hello" }}`, want: `h`},
+		{name: "truncate 0", template: `{{ truncate 0 "hello" }}`, want: ``},
+		{name: "truncate multibyte", template: `{{ truncate 5 "héllo wörld" }}`, want: `hé...`},
+		{name: "truncate multibyte 2", template: `{{ truncate 2 "日本語" }}`, want: `日本`},
+		{name: "truncate number", template: `{{ truncate 2 (get "data.http.status" .) }}`, want: `50`},
+
+		{name: "pad", template: `[{{ pad 6 "ab" }}]`, want: `[ab    ]`},
+		{name: "pad multibyte", template: `[{{ pad 3 "é" }}]`, want: `[é  ]`},
+		{name: "pad longer", template: `[{{ pad 1 "ab" }}]`, want: `[ab]`},
+		{name: "padLeft", template: `[{{ padLeft 6 "ab" }}]`, want: `[    ab]`},
+		{name: "padLeft level", template: `[{{ .Level | padLeft 5 }}]`, want: `[ INFO]`},
+		{name: "upper", template: `{{ upper .Message }}`, want: `GET /USERS TOOK 15MS`},
+		{name: "lower level", template: `{{ .Level | lower }}`, want: `info`},
+
+		{name: "timefmt", template: `{{ timefmt "2006-01-02 15:04" .CreationTime }}`, want: created.Local().Format("2006-01-02 15:04")},
+		{name: "since", template: `{{ since .Time }}`, want: `3m12s`},
+		{name: "utc", template: `{{ (utc .CreationTime).Format "15:04:05 MST" }}`, want: `20:30:15 UTC`},
+
+		{name: "duration string", template: `{{ duration (get "data.elapsed" .) }}`, want: `1.5s`},
+		{name: "duration number", template: `{{ duration (get "data.nanos" .) }}`, want: `2s`},
+		{name: "duration number string", template: `{{ duration "2000000000" }}`, want: `2s`},
+		{name: "duration int", template: `{{ duration 1000 }}`, want: `1µs`},
+		{name: "duration nil", template: `{{ duration (get "data.missing" .) }}`, want: ``},
+		{name: "duration invalid", template: `{{ duration "soon" }}`, err: true},
+		{name: "duration map", template: `{{ duration .Data.http }}`, err: true},
+
+		{name: "hasPrefix", template: `{{ hasPrefix "GET" .Message }}`, want: `true`},
+		{name: "hasPrefix false", template: `{{ hasPrefix "POST" .Message }}`, want: `false`},
+		{name: "contains", template: `{{ if contains "/users" .Message }}users{{ end }}`, want: `users`},
+		{name: "contains number", template: `{{ contains "50" (get "data.http.status" .) }}`, want: `true`},
+
+		{name: "regexReplace", template: `{{ regexReplace "([0-9]+)ms" "${1} ms" .Message }}`, want: `GET /users took 15 ms`},
+		{name: "regexReplace no match", template: `{{ regexReplace "x+" "y" "abc" }}`, want: `abc`},
+		{name: "regexReplace invalid", template: `{{ regexReplace "(" "" .Message }}`, err: true},
+
+		{name: "indent", template: `{{ indent 2 "a\nb" }}`, want: "  a\n  b"},
+		{name: "indent zero", template: `{{ indent 0 "a\nb" }}`, want: "a\nb"},
+	}
+
+	for _, test := range tests {
+		tmpl, err := template.New(test.name).Funcs(TemplateFuncMap()).Parse(test.template)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		var out bytes.Buffer
+		err = tmpl.Execute(&out, e)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", test.name, out.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if out.String() != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, out.String(), test.want)
+		}
+	}
+}
+
+func TestTemplateFuncsDocumented(t *testing.T) {
+	names := map[string]bool{}
+	for _, f := range TemplateFuncs {
+		if names[f.Name] {
+			t.Errorf("%s is listed twice", f.Name)
+		}
+		names[f.Name] = true
+		if f.Usage == "" || f.Doc == "" {
+			t.Errorf("%s has no usage or doc", f.Name)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package lib
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+)
+
+// Defaults used for zero valued ThrottleConfig fields
+const (
+	// DefaultThrottleRetries is the number of times a throttled or failed
+	// call is retried before the error is returned
+	DefaultThrottleRetries = 10
+
+	// DefaultMinBackoff is the delay before the first retry
+	DefaultMinBackoff = 200 * time.Millisecond
+
+	// DefaultMaxBackoff caps the delay between retries
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// throttleCodes are the error codes returned when calls are rate limited
+var throttleCodes = map[string]bool{
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"RequestLimitExceeded":     true,
+	"RequestThrottled":         true,
+	"TooManyRequestsException": true,
+}
+
+// transientCodes are the error codes of failures worth retrying
+var transientCodes = map[string]bool{
+	"RequestError":   true,
+	"RequestTimeout": true,
+	cloudwatchlogs.ErrCodeServiceUnavailableException: true,
+}
+
+// IsThrottle reports whether err is a CloudWatch Logs throttling error
+func IsThrottle(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return throttleCodes[awsErr.Code()]
+	}
+	return false
+}
+
+// IsRetryable reports whether err is a throttling or transient error, such as
+// a network failure or a server side error, after which a call can be retried
+func IsRetryable(err error) bool {
+	if err == nil || IsCanceled(err) {
+		return false
+	}
+	if IsThrottle(err) {
+		return true
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() >= 500 {
+		return true
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		if transientCodes[awsErr.Code()] {
+			return true
+		}
+		err = awsErr.OrigErr()
+	}
+	if netErr, ok := err.(net.Error); ok {
+		return netErr.Temporary() || netErr.Timeout()
+	}
+	return false
+}
+
+// IsCanceled reports whether err is the result of a canceled context, either
+// directly or wrapped by the AWS SDK
+func IsCanceled(err error) bool {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return true
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == request.CanceledErrorCode
+	}
+	return false
+}
+
+// RateLimiter is a token bucket limiting the rate of API calls.  It is safe
+// for concurrent use so a single limiter can be shared by several readers.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// now and after are time.Now and time.After, replaced by tests
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+}
+
+// NewRateLimiter returns a limiter allowing callsPerSecond calls on average
+// and bursts of up to burst calls
+func NewRateLimiter(callsPerSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   callsPerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+		after:  time.After,
+	}
+}
+
+// Wait blocks until a call is allowed or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := l.now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		select {
+		case <-l.after(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ThrottleConfig configures a ThrottledClient.  Zero values are replaced by
+// the matching defaults.
+type ThrottleConfig struct {
+	// CallsPerSecond limits the rate of calls across all operations, zero
+	// means unlimited
+	CallsPerSecond float64
+
+	// Burst is the number of calls allowed at once, defaults to one second
+	// worth of calls
+	Burst int
+
+	// MaxRetries is the number of times a throttled or failed call is
+	// retried, a negative value disables retries
+	MaxRetries int
+
+	// MinBackoff and MaxBackoff bound the exponential backoff between
+	// retries
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// OnBackoff, if set, is called before waiting to retry a call
+	OnBackoff func(operation string, err error, delay time.Duration)
+}
+
+func (config ThrottleConfig) withDefaults() ThrottleConfig {
+	if config.Burst <= 0 {
+		config.Burst = int(config.CallsPerSecond)
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = DefaultThrottleRetries
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultMaxBackoff
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = config.MinBackoff
+	}
+	return config
+}
+
+// ThrottledClient wraps a CloudwatchLogsClient with a client side rate limit
+// shared by every operation, and retries throttled and transiently failing
+// calls with exponential backoff and jitter.
+type ThrottledClient struct {
+	client  CloudwatchLogsClient
+	config  ThrottleConfig
+	limiter *RateLimiter
+}
+
+var _ CloudwatchLogsClient = (*ThrottledClient)(nil)
+
+// NewThrottledClient wraps client according to config
+func NewThrottledClient(client CloudwatchLogsClient, config ThrottleConfig) *ThrottledClient {
+	config = config.withDefaults()
+
+	t := &ThrottledClient{client: client, config: config}
+	if config.CallsPerSecond > 0 {
+		t.limiter = NewRateLimiter(config.CallsPerSecond, config.Burst)
+	}
+	return t
+}
+
+// Backoff returns the delay before retry number attempt (starting at 0), with
+// full jitter applied
+func (t *ThrottledClient) Backoff(attempt int) time.Duration {
+	return backoff(t.config.MinBackoff, t.config.MaxBackoff, attempt)
+}
+
+// NotifyBackoff calls the OnBackoff callback, if any
+func (t *ThrottledClient) NotifyBackoff(operation string, err error, delay time.Duration) {
+	if t.config.OnBackoff != nil {
+		t.config.OnBackoff(operation, err, delay)
+	}
+}
+
+func backoff(min time.Duration, max time.Duration, attempt int) time.Duration {
+	delay := min
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	// keep at least half the delay so retries don't bunch up at zero
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// do runs call, waiting on the rate limiter before each attempt and retrying
+// retryable errors.  It gives up as soon as ctx is done, whether waiting on
+// the limiter or backing off.
+func (t *ThrottledClient) do(ctx context.Context, operation string, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+		}
+		if t.limiter != nil {
+			if err := t.limiter.Wait(ctx); err != nil {
+				return awserr.New(request.CanceledErrorCode, "request context canceled", err)
+			}
+		}
+
+		err := call()
+		if err == nil || !IsRetryable(err) || attempt >= t.config.MaxRetries {
+			return err
+		}
+
+		delay := t.Backoff(attempt)
+		t.NotifyBackoff(operation, err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+		}
+	}
+}
+
+// DescribeLogGroupsWithContext calls the wrapped client
+func (t *ThrottledClient) DescribeLogGroupsWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogGroupsInput, opts ...request.Option) (out *cloudwatchlogs.DescribeLogGroupsOutput, err error) {
+	err = t.do(ctx, "DescribeLogGroups", func() error {
+		out, err = t.client.DescribeLogGroupsWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+// DescribeLogStreamsPagesWithContext calls the wrapped client.  A failed page
+// is retried from where it failed instead of restarting from the first page.
+func (t *ThrottledClient) DescribeLogStreamsPagesWithContext(ctx aws.Context, input *cloudwatchlogs.DescribeLogStreamsInput, fn func(*cloudwatchlogs.DescribeLogStreamsOutput, bool) bool, opts ...request.Option) error {
+	params := *input
+	for {
+		var page *cloudwatchlogs.DescribeLogStreamsOutput
+		var lastPage bool
+		err := t.do(ctx, "DescribeLogStreams", func() error {
+			page, lastPage = nil, false
+			single := params
+			return t.client.DescribeLogStreamsPagesWithContext(ctx, &single, func(o *cloudwatchlogs.DescribeLogStreamsOutput, last bool) bool {
+				page, lastPage = o, last
+				return false
+			}, opts...)
+		})
+		if err != nil {
+			return err
+		}
+		if page == nil {
+			return nil
+		}
+
+		if !fn(page, lastPage) || lastPage || page.NextToken == nil {
+			return nil
+		}
+		params.NextToken = page.NextToken
+	}
+}
+
+// FilterLogEventsWithContext calls the wrapped client
+func (t *ThrottledClient) FilterLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.FilterLogEventsInput, opts ...request.Option) (out *cloudwatchlogs.FilterLogEventsOutput, err error) {
+	err = t.do(ctx, "FilterLogEvents", func() error {
+		out, err = t.client.FilterLogEventsWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
+
+// GetLogEventsWithContext calls the wrapped client
+func (t *ThrottledClient) GetLogEventsWithContext(ctx aws.Context, input *cloudwatchlogs.GetLogEventsInput, opts ...request.Option) (out *cloudwatchlogs.GetLogEventsOutput, err error) {
+	err = t.do(ctx, "GetLogEvents", func() error {
+		out, err = t.client.GetLogEventsWithContext(ctx, input, opts...)
+		return err
+	})
+	return out, err
+}
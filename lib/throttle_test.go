@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// fakeClock replaces the clock of a RateLimiter, waiting advances it
+type fakeClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) install(l *RateLimiter) {
+	l.last = c.now
+	l.now = func() time.Time { return c.now }
+	l.after = func(d time.Duration) <-chan time.Time {
+		c.waits = append(c.waits, d)
+		c.now = c.now.Add(d)
+		ready := make(chan time.Time, 1)
+		ready <- c.now
+		return ready
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)}
+	l := NewRateLimiter(10, 2)
+	clock.install(l)
+	ctx := context.Background()
+
+	// the burst goes through at once, then calls are spaced at the rate
+	for i := 0; i < 4; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(clock.waits) != "[100ms 100ms]" {
+		t.Errorf("waits = %v, want [100ms 100ms]", clock.waits)
+	}
+
+	// idle time refills the bucket up to the burst only
+	clock.now = clock.now.Add(time.Minute)
+	clock.waits = nil
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fmt.Sprint(clock.waits) != "[100ms]" {
+		t.Errorf("waits after idling = %v, want [100ms]", clock.waits)
+	}
+
+	// a canceled wait gives up
+	l.after = func(time.Duration) <-chan time.Time { return nil }
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := l.Wait(ctx); err != context.Canceled {
+		t.Errorf("canceled wait = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestThrottledClientRetries(t *testing.T) {
+	throttled := awserr.New("ThrottlingException", "Rate exceeded", nil)
+	unavailable := awserr.NewRequestFailure(awserr.New("InternalFailure", "oops", nil), 503, "id")
+	invalid := awserr.NewRequestFailure(awserr.New("InvalidParameterException", "bad", nil), 400, "id")
+	boom := errors.New("boom")
+
+	tests := []struct {
+		name  string
+		errs  []error
+		calls int
+		err   error
+	}{
+		{"success", nil, 1, nil},
+		{"throttled", []error{throttled, throttled}, 3, nil},
+		{"server error", []error{unavailable}, 2, nil},
+		{"client error", []error{invalid, invalid}, 1, invalid},
+		{"other error", []error{boom}, 1, boom},
+		// the first call and 3 retries
+		{"give up", []error{throttled, throttled, throttled, throttled, throttled}, 4, throttled},
+	}
+	for _, test := range tests {
+		backoffs := 0
+		client := NewThrottledClient(nil, ThrottleConfig{
+			MaxRetries: 3,
+			MinBackoff: time.Millisecond,
+			MaxBackoff: time.Millisecond,
+			OnBackoff:  func(string, error, time.Duration) { backoffs++ },
+		})
+		calls := 0
+		err := client.do(context.Background(), "Test", func() error {
+			calls++
+			if calls <= len(test.errs) {
+				return test.errs[calls-1]
+			}
+			return nil
+		})
+		if err != test.err {
+			t.Errorf("%s: error = %v, want %v", test.name, err, test.err)
+		}
+		if calls != test.calls || backoffs != calls-1 {
+			t.Errorf("%s: %d calls and %d backoffs, want %d calls", test.name, calls, backoffs, test.calls)
+		}
+	}
+}
+
+func TestThrottledClientCanceled(t *testing.T) {
+	throttled := awserr.New("ThrottlingException", "Rate exceeded", nil)
+
+	// canceled before the first call
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client := NewThrottledClient(nil, ThrottleConfig{})
+	calls := 0
+	err := client.do(ctx, "Test", func() error {
+		calls++
+		return nil
+	})
+	if !IsCanceled(err) || calls != 0 {
+		t.Errorf("canceled before calling: %d calls, error %v", calls, err)
+	}
+
+	// canceled while backing off
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	client = NewThrottledClient(nil, ThrottleConfig{
+		MinBackoff: time.Hour,
+		OnBackoff:  func(string, error, time.Duration) { cancel() },
+	})
+	calls = 0
+	err = client.do(ctx, "Test", func() error {
+		calls++
+		return throttled
+	})
+	if !IsCanceled(err) || calls != 1 {
+		t.Errorf("canceled while backing off: %d calls, error %v", calls, err)
+	}
+
+	// canceled errors aren't retried
+	if IsRetryable(awserr.New("RequestCanceled", "canceled", context.Canceled)) {
+		t.Error("canceled requests are retryable")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		// capped
+		{5, time.Second},
+		{50, time.Second},
+	}
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			delay := backoff(100*time.Millisecond, time.Second, test.attempt)
+			if delay < test.max/2 || delay > test.max {
+				t.Errorf("backoff(%d) = %s, want between %s and %s", test.attempt, delay, test.max/2, test.max)
+				break
+			}
+		}
+	}
+}